@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/security"
+)
+
+func main() {
+	dir := flag.String("dir", "/var/log/build-cache/audit", "Directory containing audit log segments")
+	head := flag.String("expect-head", "", "Optional head hash recorded out-of-band, detects truncation of the newest records")
+	flag.Parse()
+
+	result, err := security.VerifyAuditLog(*dir)
+	if err != nil {
+		if result != nil {
+			fmt.Fprintf(os.Stderr, "verified %d records before failure\n", result.Records)
+		}
+		fmt.Fprintf(os.Stderr, "audit chain verification FAILED: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *head != "" && *head != result.HeadHash {
+		fmt.Fprintf(os.Stderr, "audit chain verification FAILED: head hash %s does not match expected %s\n", result.HeadHash, *head)
+		os.Exit(1)
+	}
+
+	fmt.Printf("audit chain OK: %d records in %d files, head hash %s\n", result.Records, result.Files, result.HeadHash)
+}
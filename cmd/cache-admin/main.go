@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/ruslanbaba/distributed-build-cache/pkg/grpc/server"
+)
+
+const usage = `usage: cache-admin [flags] <command> [args]
+
+commands:
+  why-missing <hash>[/<size>]   report whether a digest is cached, when and by
+                                which rule it was pruned, or that it was never
+                                uploaded
+  invocation-report <id>        list the targets and mnemonics of a Bazel
+                                invocation by cache misses
+
+flags:
+`
+
+func main() {
+	addr := flag.String("addr", env("CACHE_ADDR", "localhost:8443"), "Cache server address")
+	instance := flag.String("instance", "", "Instance name")
+	caFile := flag.String("ca", "", "PEM CA bundle to verify the server with, system roots if empty")
+	certFile := flag.String("cert", "", "PEM client certificate for mTLS")
+	keyFile := flag.String("key", "", "PEM client key for mTLS")
+	plaintext := flag.Bool("plaintext", false, "Connect without TLS")
+	clientID := flag.String("client-id", env("CACHE_CLIENT_ID", ""), "Identity sent in the "+server.ClientIDHeader+" header when not using mTLS")
+	timeout := flag.Duration("timeout", time.Minute, "RPC timeout")
+	maxResults := flag.Int("max-results", 20, "Targets and mnemonics listed by invocation-report, 0 for all")
+	all := flag.Bool("all", false, "Also list targets and mnemonics without misses in invocation-report")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	creds, err := transportCredentials(*plaintext, *caFile, *certFile, *keyFile)
+	if err != nil {
+		fail("%v", err)
+	}
+	conn, err := grpc.Dial(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		fail("failed to connect to %s: %v", *addr, err)
+	}
+	defer conn.Close()
+	client := server.NewBuildCacheServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if *clientID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, server.ClientIDHeader, *clientID)
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "why-missing":
+		if len(args) != 1 {
+			fail("why-missing needs exactly one digest")
+		}
+		digest, err := parseDigest(args[0])
+		if err != nil {
+			fail("%v", err)
+		}
+		resp, err := client.WhyMissing(ctx, &server.WhyMissingRequest{InstanceName: *instance, Digest: digest})
+		if err != nil {
+			fail("why-missing failed: %v", err)
+		}
+		printHistory(resp)
+	case "invocation-report":
+		if len(args) != 1 {
+			fail("invocation-report needs exactly one invocation id")
+		}
+		report, err := client.GetInvocationReport(ctx, &server.InvocationReportRequest{
+			InvocationId: args[0],
+			MaxResults:   int32(*maxResults),
+			MissesOnly:   !*all,
+		})
+		if err != nil {
+			fail("invocation-report failed: %v", err)
+		}
+		printInvocationReport(report)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// printHistory explains each entry stored under the digest, CAS blob first
+func printHistory(resp *server.WhyMissingResponse) {
+	for i, entry := range resp.Entries {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s %s\n", entry.Kind, entry.Key)
+		switch entry.State {
+		case "present":
+			fmt.Printf("  cached: %d bytes, last accessed %s\n", entry.SizeBytes, formatTime(entry.LastAccessed))
+		case "pruned":
+			fmt.Println("  missing: pruned")
+		default:
+			fmt.Println("  missing: never uploaded, or deleted before the decision log retention")
+		}
+
+		for _, d := range entry.Decisions {
+			action := "deleted"
+			if d.Trashed {
+				action = "trashed"
+			}
+			fmt.Printf("  %s %s by %s run %s, rule %s (%d bytes, last accessed %s)\n",
+				action, formatTime(d.DeletedAt), d.Pruner, d.RunId, d.Reason, d.SizeBytes, formatTime(d.LastAccessed))
+		}
+	}
+}
+
+// printInvocationReport shows the totals of an invocation, then the targets
+// and mnemonics that missed most
+func printInvocationReport(report *server.InvocationReport) {
+	fmt.Printf("invocation %s", report.InvocationId)
+	if report.CorrelatedInvocationsId != "" {
+		fmt.Printf(" (correlated %s)", report.CorrelatedInvocationsId)
+	}
+	fmt.Println()
+	if report.ToolName != "" {
+		fmt.Printf("  tool: %s %s\n", report.ToolName, report.ToolVersion)
+	}
+	fmt.Printf("  calls: %s to %s\n", formatTime(report.FirstSeen), formatTime(report.LastSeen))
+	fmt.Printf("  totals: %s\n", formatCounts(report.Totals))
+
+	for _, section := range []struct {
+		title string
+		rows  []*server.CacheBreakdown
+	}{{"targets", report.Targets}, {"mnemonics", report.Mnemonics}} {
+		fmt.Printf("\n%s:\n", section.title)
+		if len(section.rows) == 0 {
+			fmt.Println("  none")
+		}
+		for _, row := range section.rows {
+			fmt.Printf("  %s\n    %s\n", row.Name, formatCounts(row.Counts))
+		}
+	}
+}
+
+func formatCounts(c *server.CacheCounts) string {
+	return fmt.Sprintf("actions %d hit %d missed (%s), blobs %d hit %d missed",
+		c.GetActionHits(), c.GetActionMisses(), hitRate(c.GetActionHits(), c.GetActionMisses()),
+		c.GetBlobHits(), c.GetBlobMisses())
+}
+
+func hitRate(hits, misses int64) string {
+	if hits+misses == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%% hit", 100*float64(hits)/float64(hits+misses))
+}
+
+// parseDigest parses "hash" or "hash/size"
+func parseDigest(arg string) (*server.Digest, error) {
+	hash, size, ok := strings.Cut(arg, "/")
+	digest := &server.Digest{Hash: hash}
+	if ok {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest size in %q", arg)
+		}
+		digest.SizeBytes = n
+	}
+	return digest, nil
+}
+
+func transportCredentials(plaintext bool, caFile, certFile, keyFile string) (credentials.TransportCredentials, error) {
+	if plaintext {
+		return insecure.NewCredentials(), nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+func formatTime(unixNanos int64) string {
+	return time.Unix(0, unixNanos).UTC().Format(time.RFC3339)
+}
+
+func env(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/ruslanbaba/distributed-build-cache/pkg/grpc/server"
+)
+
+const usage = `usage: cache-pin [flags] <command> [args]
+
+commands:
+  add <hash>[/<size>]...   pin CAS blobs, or action results with -ac
+  list                     list pins and the bytes they hold
+  rm <id>...               remove pins
+
+flags:
+`
+
+func main() {
+	addr := flag.String("addr", env("CACHE_ADDR", "localhost:8443"), "Cache server address")
+	instance := flag.String("instance", "", "Instance name")
+	caFile := flag.String("ca", "", "PEM CA bundle to verify the server with, system roots if empty")
+	certFile := flag.String("cert", "", "PEM client certificate for mTLS")
+	keyFile := flag.String("key", "", "PEM client key for mTLS")
+	plaintext := flag.Bool("plaintext", false, "Connect without TLS")
+	clientID := flag.String("client-id", env("CACHE_CLIENT_ID", ""), "Identity sent in the "+server.ClientIDHeader+" header when not using mTLS")
+	actionResults := flag.Bool("ac", false, "add: the hashes are action digests")
+	reachable := flag.Bool("reachable", false, "add: also pin every blob the action results reference (implies -ac)")
+	label := flag.String("label", "", "add: label describing the pin, e.g. the release branch")
+	ttl := flag.Duration("ttl", 0, "add: pin lifetime, 0 for no expiry")
+	all := flag.Bool("all", false, "list: include expired pins")
+	timeout := flag.Duration("timeout", 5*time.Minute, "RPC timeout")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	creds, err := transportCredentials(*plaintext, *caFile, *certFile, *keyFile)
+	if err != nil {
+		fail("%v", err)
+	}
+	conn, err := grpc.Dial(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		fail("failed to connect to %s: %v", *addr, err)
+	}
+	defer conn.Close()
+	client := server.NewBuildCacheServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if *clientID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, server.ClientIDHeader, *clientID)
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "add":
+		if len(args) == 0 {
+			fail("add needs at least one hash")
+		}
+		req := &server.PinRequest{
+			InstanceName:     *instance,
+			IncludeReachable: *reachable,
+			Label:            *label,
+			TtlSeconds:       int64(ttl.Seconds()),
+		}
+		for _, arg := range args {
+			digest, err := parseDigest(arg)
+			if err != nil {
+				fail("%v", err)
+			}
+			if *actionResults || *reachable {
+				req.ActionDigests = append(req.ActionDigests, digest)
+			} else {
+				req.Digests = append(req.Digests, digest)
+			}
+		}
+
+		pin, err := client.Pin(ctx, req)
+		if err != nil {
+			fail("pin failed: %v", err)
+		}
+		fmt.Printf("pinned %d entries (%d bytes) as %s\n", pin.Entries, pin.BytesHeld, pin.Id)
+	case "list":
+		resp, err := client.ListPins(ctx, &server.ListPinsRequest{InstanceName: *instance, IncludeExpired: *all})
+		if err != nil {
+			fail("list failed: %v", err)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tLABEL\tENTRIES\tBYTES\tCREATED BY\tCREATED\tEXPIRES")
+		var total int64
+		for _, pin := range resp.Pins {
+			expires := "never"
+			if pin.ExpiresAt != 0 {
+				expires = formatTime(pin.ExpiresAt)
+			}
+			if pin.Expired {
+				expires += " (expired)"
+			} else {
+				total += pin.BytesHeld
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+				pin.Id, pin.Label, pin.Entries, pin.BytesHeld, pin.CreatedBy, formatTime(pin.CreatedAt), expires)
+		}
+		tw.Flush()
+		fmt.Printf("%d pins holding %d bytes\n", len(resp.Pins), total)
+	case "rm":
+		if len(args) == 0 {
+			fail("rm needs at least one pin id")
+		}
+		failed := 0
+		for _, id := range args {
+			resp, err := client.Unpin(ctx, &server.UnpinRequest{InstanceName: *instance, Id: id})
+			switch {
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "failed to remove %s: %v\n", id, err)
+				failed++
+			case !resp.Found:
+				fmt.Fprintf(os.Stderr, "no pin %s in instance %q\n", id, *instance)
+				failed++
+			default:
+				fmt.Printf("removed %s\n", id)
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// parseDigest parses "hash" or "hash/size"
+func parseDigest(arg string) (*server.Digest, error) {
+	hash, size, ok := strings.Cut(arg, "/")
+	digest := &server.Digest{Hash: hash}
+	if ok {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest size in %q", arg)
+		}
+		digest.SizeBytes = n
+	}
+	return digest, nil
+}
+
+func transportCredentials(plaintext bool, caFile, certFile, keyFile string) (credentials.TransportCredentials, error) {
+	if plaintext {
+		return insecure.NewCredentials(), nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+func formatTime(unixNanos int64) string {
+	return time.Unix(0, unixNanos).UTC().Format(time.RFC3339)
+}
+
+func env(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,16 +14,27 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/ruslanbaba/distributed-build-cache/internal/accesstrace"
+	"github.com/ruslanbaba/distributed-build-cache/internal/bandwidth"
 	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
 	"github.com/ruslanbaba/distributed-build-cache/internal/config"
+	"github.com/ruslanbaba/distributed-build-cache/internal/determinism"
+	cachehealth "github.com/ruslanbaba/distributed-build-cache/internal/health"
+	"github.com/ruslanbaba/distributed-build-cache/internal/invocation"
 	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+	"github.com/ruslanbaba/distributed-build-cache/internal/observability"
+	"github.com/ruslanbaba/distributed-build-cache/internal/provenance"
 	"github.com/ruslanbaba/distributed-build-cache/internal/pruning"
+	"github.com/ruslanbaba/distributed-build-cache/internal/scheduler"
+	"github.com/ruslanbaba/distributed-build-cache/internal/security"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
 	"github.com/ruslanbaba/distributed-build-cache/pkg/grpc/server"
 )
 
@@ -51,8 +63,65 @@ func main() {
 	metricsCollector := metrics.NewCollector()
 	metricsRegistry.MustRegister(metricsCollector)
 
-	// Initialize Cloud Storage client
+	// Admin routes change server state, so they are kept off the metrics
+	// port and served on their own listener behind a bearer token
+	adminMux := http.NewServeMux()
+	var adminToken string
+	if cfg.Admin.TokenFile != "" {
+		if adminToken, err = security.LoadAdminToken(cfg.Admin.TokenFile); err != nil {
+			logger.Fatal("Failed to load admin token", zap.Error(err))
+		}
+	}
+
 	ctx := context.Background()
+
+	// Identify this replica to the scheduler lease, invocation reports and
+	// SLO alerts
+	identity := cfg.Scheduler.Identity
+	if identity == "" {
+		if identity, err = os.Hostname(); err != nil {
+			logger.Fatal("Failed to determine replica identity", zap.Error(err))
+		}
+	}
+
+	// Initialize tracing and SLO tracking
+	stackConfig := observability.Config{ServiceName: "build-cache", Version: version}
+	if cfg.Tracing.Enabled {
+		stackConfig.Tracing = &observability.TracingConfig{
+			Endpoint:           cfg.Tracing.Endpoint,
+			Insecure:           cfg.Tracing.Insecure,
+			SampleRatio:        cfg.Tracing.SampleRatio,
+			MethodSampleRatios: cfg.Tracing.MethodSampleRatios,
+		}
+	}
+	if cfg.SLO.Enabled {
+		objectives := make(map[string]float64)
+		for name, objective := range map[string]float64{
+			observability.SLOGetAvailability: cfg.SLO.GetAvailability,
+			observability.SLOGetLatency:      cfg.SLO.GetLatency,
+			observability.SLOACFreshness:     cfg.SLO.ACFreshness,
+		} {
+			if objective > 0 {
+				objectives[name] = objective
+			}
+		}
+		stackConfig.SLOs = &observability.SLOConfig{
+			Objectives:        objectives,
+			LatencyThreshold:  cfg.SLO.GetLatencyThreshold,
+			EvaluateInterval:  cfg.SLO.EvaluateInterval,
+			FastBurnThreshold: cfg.SLO.FastBurnThreshold,
+			WebhookURL:        cfg.SLO.WebhookURL,
+			AlertCooldown:     cfg.SLO.AlertCooldown,
+			Replica:           identity,
+		}
+	}
+	stack, err := observability.NewObservabilityStack(ctx, stackConfig, logger.Named("observability"))
+	if err != nil {
+		logger.Fatal("Failed to initialize observability", zap.Error(err))
+	}
+	metricsRegistry.MustRegister(stack)
+
+	// Initialize Cloud Storage client
 	storageClient, err := storage.NewClient(ctx)
 	if err != nil {
 		logger.Fatal("Failed to create storage client", zap.Error(err))
@@ -66,6 +135,18 @@ func main() {
 		logger.Named("cache"),
 		metricsCollector,
 	)
+	cacheService.SetResurrectTrashed(cfg.Pruning.ResurrectTrashed)
+
+	// Initialize eviction strategies
+	evictionPolicy, err := eviction.NewPolicy(cfg.Pruning.Strategy, cfg.Pruning.InstanceStrategies, eviction.Options{
+		Retention: time.Duration(cfg.Pruning.RetentionDays) * 24 * time.Hour,
+	})
+	if err == nil {
+		err = evictionPolicy.Streamable()
+	}
+	if err != nil {
+		logger.Fatal("Invalid eviction strategy configuration", zap.Error(err))
+	}
 
 	// Initialize pruning service
 	pruningService := pruning.NewService(
@@ -73,29 +154,362 @@ func main() {
 		logger.Named("pruning"),
 		metricsCollector,
 		pruning.Config{
-			MaxCacheSize:    cfg.Pruning.MaxCacheSizeGB * 1024 * 1024 * 1024, // Convert GB to bytes
+			MaxCacheSize:    int64(cfg.Pruning.MaxCacheSizeGB) * 1024 * 1024 * 1024, // Convert GB to bytes
 			PruningInterval: cfg.Pruning.IntervalHours * time.Hour,
 			RetentionDays:   cfg.Pruning.RetentionDays,
+			DryRun:          cfg.Pruning.DryRun,
+			ReportFile:      cfg.Pruning.ReportFile,
+			Eviction:        evictionPolicy,
+			ReferenceAware:  cfg.Pruning.ReferenceAware,
+			References: eviction.ReferenceOptions{
+				OrphanGrace:   cfg.Pruning.OrphanGrace,
+				ProtectWindow: cfg.Pruning.ProtectWindow,
+			},
+			MaxReportCandidates: cfg.Pruning.ReportMaxCandidates,
+			SoftDelete:          cfg.Pruning.SoftDelete,
+			TrashRetention:      cfg.Pruning.TrashRetention,
+			HighWatermark:       cfg.Pruning.HighWatermark,
+			LowWatermark:        cfg.Pruning.LowWatermark,
+			WatermarkInterval:   cfg.Pruning.WatermarkInterval,
+			ScanFraction:        cfg.Pruning.ScanFraction,
+			DecisionRetention:   cfg.Pruning.DecisionRetention,
 		},
 	)
 
-	// Start pruning service
-	go pruningService.Start(ctx)
+	// Count hits and misses per Bazel invocation for GetInvocationReport
+	var tracker *invocation.Tracker
+	if cfg.Invocations.Enabled {
+		tracker = invocation.NewTracker(cacheService, invocation.Config{
+			Replica:        identity,
+			FlushInterval:  cfg.Invocations.FlushInterval,
+			IdleTimeout:    cfg.Invocations.IdleTimeout,
+			Retention:      cfg.Invocations.Retention,
+			MaxInvocations: cfg.Invocations.MaxInvocations,
+		}, logger.Named("invocations"))
+	}
+
+	// Run pruning and other background jobs on the replica holding the
+	// scheduler lease, so scaling out does not multiply full-bucket scans
+	jobScheduler := scheduler.New(cacheService, scheduler.Config{
+		Identity:    identity,
+		LeaseName:   cfg.Scheduler.LeaseName,
+		LeaseTTL:    cfg.Scheduler.LeaseTTL,
+		HistorySize: cfg.Scheduler.HistorySize,
+	}, logger.Named("scheduler"), metricsCollector)
+
+	if cfg.Pruning.EnablePruning {
+		jobScheduler.Register(scheduler.Job{
+			Name:     "prune",
+			Interval: cfg.Pruning.IntervalHours * time.Hour,
+			Run: func(ctx context.Context) error {
+				err := pruningService.RunPruning(ctx)
+				if err != nil {
+					metricsCollector.PruningErrors.Inc()
+				}
+				return err
+			},
+		})
+	}
+	watchUsage := cfg.Pruning.EnablePruning && cfg.Pruning.WatermarkInterval > 0
+	if watchUsage {
+		jobScheduler.Register(scheduler.Job{
+			Name:     "watermark",
+			Interval: cfg.Pruning.WatermarkInterval,
+			Run: func(ctx context.Context) error {
+				err := pruningService.CheckUsage(ctx)
+				if err != nil {
+					metricsCollector.PruningErrors.Inc()
+				}
+				return err
+			},
+		})
+	}
+	if cfg.Scheduler.ScrubInterval > 0 {
+		jobScheduler.Register(scheduler.Job{
+			Name:     "scrub",
+			Interval: cfg.Scheduler.ScrubInterval,
+			Run: func(ctx context.Context) error {
+				stats, err := cacheService.Scrub(ctx)
+				logger.Info("Scrub finished",
+					zap.Int64("checked", stats.Checked),
+					zap.Int64("checked_bytes", stats.CheckedBytes),
+					zap.Int64("corrupt", stats.Corrupt),
+					zap.Int64("deleted", stats.Deleted),
+				)
+				return err
+			},
+		})
+	}
+	if cfg.Scheduler.ReportInterval > 0 {
+		jobScheduler.Register(scheduler.Job{
+			Name:     "report",
+			Interval: cfg.Scheduler.ReportInterval,
+			Run:      pruningService.Report,
+		})
+	}
+	if tracker != nil {
+		jobScheduler.Register(scheduler.Job{
+			Name:     "invocation-purge",
+			Interval: cfg.Invocations.PurgeInterval,
+			Run:      tracker.Purge,
+		})
+	}
+	adminMux.Handle("/admin/jobs", jobScheduler)
+
+	schedulerCtx, stopScheduler := context.WithCancel(ctx)
+	schedulerDone := make(chan struct{})
+	go func() {
+		jobScheduler.Run(schedulerCtx)
+		close(schedulerDone)
+	}()
+
+	// Background writers of what the RPCs record run until the gRPC server
+	// has stopped, so calls still in flight at shutdown are counted
+	writersCtx, stopWriters := context.WithCancel(ctx)
+
+	// Every replica publishes the bytes it writes and deletes, so the leader
+	// sees the cache grow between full pruning cycles
+	usageDone := make(chan struct{})
+	go func() {
+		if watchUsage {
+			cacheService.SyncUsage(writersCtx, cfg.Pruning.UsageSyncInterval)
+		}
+		close(usageDone)
+	}()
+
+	// Every replica evaluates the burn rates of the requests it served
+	go stack.Run(writersCtx)
+
+	// Build interceptor chains
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		server.UnaryRequestMetadataInterceptor(),
+		server.UnaryLoggingInterceptor(logger),
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		server.StreamSLOInterceptor(stack),
+		server.StreamRequestMetadataInterceptor(),
+		server.StreamLoggingInterceptor(logger),
+	}
+
+	// Initialize audit log
+	var auditLogger *security.AuditLogger
+	if cfg.Audit.Enabled {
+		auditLogger = security.NewAuditLogger(security.AuditConfig{
+			Dir:            cfg.Audit.Dir,
+			MaxSizeBytes:   cfg.Audit.MaxSizeMB * 1024 * 1024,
+			RotateInterval: cfg.Audit.RotateInterval,
+			SyncWrites:     cfg.Audit.SyncWrites,
+		}, logger.Named("audit"))
+		if err := auditLogger.Err(); err != nil {
+			logger.Fatal("Failed to initialize audit log", zap.Error(err))
+		}
+		defer auditLogger.Close()
+
+		unaryInterceptors = append(unaryInterceptors, server.UnaryAuditInterceptor(auditLogger))
+		streamInterceptors = append(streamInterceptors, server.StreamAuditInterceptor(auditLogger))
+	}
+
+	// Initialize threat detection
+	if cfg.Threat.Enabled {
+		threatDetector := security.NewThreatDetector(security.ThreatConfig{
+			Window:               cfg.Threat.Window,
+			RequestThreshold:     cfg.Threat.RequestThreshold,
+			MissThreshold:        cfg.Threat.MissThreshold,
+			MismatchThreshold:    cfg.Threat.MismatchThreshold,
+			AuthFailureThreshold: cfg.Threat.AuthFailureThreshold,
+			RequestWeight:        cfg.Threat.RequestWeight,
+			MissWeight:           cfg.Threat.MissWeight,
+			MismatchWeight:       cfg.Threat.MismatchWeight,
+			AuthFailureWeight:    cfg.Threat.AuthFailureWeight,
+			BlockScore:           cfg.Threat.BlockScore,
+			QuarantineTTL:        cfg.Threat.QuarantineTTL,
+			QuarantineFile:       cfg.Threat.QuarantineFile,
+		}, logger.Named("threat"))
+		threatDetector.Quarantine().OnChange(func(active int) {
+			metricsCollector.QuarantinedClients.Set(float64(active))
+		})
+		if auditLogger != nil {
+			threatDetector.Quarantine().AuditTo(auditLogger)
+		}
+		adminMux.Handle("/admin/quarantine", threatDetector.Quarantine())
+
+		unaryInterceptors = append(unaryInterceptors, server.UnaryThreatInterceptor(threatDetector, metricsCollector))
+		streamInterceptors = append(streamInterceptors, server.StreamThreatInterceptor(threatDetector, metricsCollector))
+	}
+
+	// Initialize request validation
+	if cfg.Validation.Enabled {
+		rules := security.DefaultValidationRules()
+		if cfg.Validation.HashPattern != "" {
+			rules.HashPattern = cfg.Validation.HashPattern
+		}
+		if cfg.Validation.InstanceNamePattern != "" {
+			rules.InstanceNamePattern = cfg.Validation.InstanceNamePattern
+		}
+		if cfg.Validation.ContentTypePattern != "" {
+			rules.ContentTypePattern = cfg.Validation.ContentTypePattern
+		}
+		rules.MaxInstanceNameLength = cfg.Validation.MaxInstanceNameLength
+		rules.AllowEmptyInstance = cfg.Validation.AllowEmptyInstance
+		rules.AllowedContentTypes = cfg.Validation.AllowedContentTypes
+		rules.MaxDigestsPerRequest = cfg.Validation.MaxDigestsPerRequest
+		rules.MaxBlobSizeBytes = cfg.Validation.MaxBlobSizeBytes
+
+		validator, err := security.NewInputValidatorWithRules(rules)
+		if err != nil {
+			logger.Fatal("Invalid validation rules", zap.Error(err))
+		}
+
+		unaryInterceptors = append(unaryInterceptors, server.UnaryValidationInterceptor(validator, metricsCollector))
+		streamInterceptors = append(streamInterceptors, server.StreamValidationInterceptor(validator, metricsCollector))
+	}
 
 	// Initialize gRPC server
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(server.UnaryLoggingInterceptor(logger)),
-		grpc.StreamInterceptor(server.StreamLoggingInterceptor(logger)),
-	)
+	grpcOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	if cfg.Tracing.Enabled {
+		// The stats handler starts each RPC's span before the interceptors
+		// run, so their logs and the handlers' storage spans fall inside it
+		grpcOpts = append(grpcOpts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	}
+	grpcServer := grpc.NewServer(grpcOpts...)
+
+	// Initialize bandwidth shaping
+	shaper := bandwidth.NewShaper(bandwidth.Config{
+		InstanceUploadBytesPerSec:   cfg.Bandwidth.InstanceUploadBytesPerSec,
+		InstanceDownloadBytesPerSec: cfg.Bandwidth.InstanceDownloadBytesPerSec,
+		IdentityUploadBytesPerSec:   cfg.Bandwidth.IdentityUploadBytesPerSec,
+		IdentityDownloadBytesPerSec: cfg.Bandwidth.IdentityDownloadBytesPerSec,
+		BurstBytes:                  cfg.Bandwidth.BurstBytes,
+		InstanceOverrides:           cfg.Bandwidth.InstanceOverrides,
+	}, metricsCollector)
+
+	serverOpts := []server.Option{
+		server.WithBandwidthShaper(shaper),
+	}
+
+	// Restrict shared action cache writes to trusted identities
+	if cfg.Trust.Enforce {
+		trustPolicy, err := server.NewTrustPolicy(cfg.Trust.TrustedWriters, cfg.Trust.RequireVerified)
+		if err != nil {
+			logger.Fatal("Failed to create trust policy", zap.Error(err))
+		}
+		serverOpts = append(serverOpts, server.WithTrustPolicy(trustPolicy))
+		logger.Info("Action cache trust policy enforced",
+			zap.Strings("trusted_writers", cfg.Trust.TrustedWriters),
+			zap.Bool("require_verified", cfg.Trust.RequireVerified),
+		)
+	}
+
+	// Sign stored action results
+	if cfg.Provenance.Enabled {
+		signer, err := provenance.NewSigner(cfg.Provenance.KeyFile, cfg.Provenance.BuilderID)
+		if err != nil {
+			logger.Fatal("Failed to load provenance signing key", zap.Error(err))
+		}
+		serverOpts = append(serverOpts, server.WithProvenanceSigner(signer))
+		logger.Info("Action result provenance signing enabled", zap.String("key_id", signer.KeyID()))
+	}
+
+	// Track ActionResult history to flag nondeterministic actions
+	if cfg.Determinism.Enabled {
+		detector := determinism.NewDetector(cacheService, determinism.Config{
+			HistorySize: cfg.Determinism.HistorySize,
+		}, logger.Named("determinism"), metricsCollector)
+		serverOpts = append(serverOpts, server.WithNondeterminismDetector(detector))
+	}
+
+	// Scan action logs and small blobs for credentials
+	if cfg.Secrets.Enabled {
+		var customPatterns map[string]string
+		if cfg.Secrets.PatternsFile != "" {
+			customPatterns, err = security.LoadSecretPatterns(cfg.Secrets.PatternsFile)
+			if err != nil {
+				logger.Fatal("Failed to load secret patterns", zap.Error(err))
+			}
+		}
+
+		scanner, err := security.NewSecretScanner(security.SecretScanConfig{
+			Policy:           security.SecretPolicy(cfg.Secrets.Policy),
+			RulePolicies:     cfg.Secrets.RulePolicies,
+			CustomPatterns:   customPatterns,
+			MaxBlobScanBytes: cfg.Secrets.MaxBlobScanBytes,
+		})
+		if err != nil {
+			logger.Fatal("Invalid secret scanning configuration", zap.Error(err))
+		}
+		serverOpts = append(serverOpts, server.WithSecretScanner(scanner, auditLogger))
+	}
+
+	// Record accesses for replay by cache-sim
+	if cfg.Trace.Enabled {
+		recorder, err := accesstrace.NewRecorder(accesstrace.Config{
+			File:         cfg.Trace.File,
+			MaxSizeBytes: cfg.Trace.MaxSizeMB * 1024 * 1024,
+		}, logger.Named("trace"))
+		if err != nil {
+			logger.Fatal("Failed to open access trace", zap.Error(err))
+		}
+		defer recorder.Close()
+		serverOpts = append(serverOpts, server.WithAccessTrace(recorder))
+		logger.Info("Recording cache accesses", zap.String("file", cfg.Trace.File))
+	}
+
+	// Every replica stores the invocation counts of the calls it served
+	invocationsDone := make(chan struct{})
+	if tracker != nil {
+		go func() {
+			tracker.Run(writersCtx)
+			close(invocationsDone)
+		}()
+		serverOpts = append(serverOpts, server.WithInvocationTracker(tracker))
+	} else {
+		close(invocationsDone)
+	}
+
+	if cfg.SLO.Enabled {
+		sampleRatio := cfg.SLO.ACFreshnessSampleRatio
+		if cfg.SLO.ACFreshness == 0 {
+			sampleRatio = 0 // Not tracked, so skip the lookups
+		}
+		serverOpts = append(serverOpts, server.WithSLOs(stack, sampleRatio))
+	}
 
 	// Register services
-	cacheGRPCServer := server.NewCacheServer(cacheService, logger.Named("grpc"))
+	cacheGRPCServer := server.NewCacheServer(cacheService, logger.Named("grpc"), metricsCollector, serverOpts...)
 	server.RegisterBuildCacheServiceServer(grpcServer, cacheGRPCServer)
 
-	// Register health service
+	// Register health service, serving once the backend probes pass
 	healthServer := health.NewServer()
 	grpc_health_v1.RegisterHealthServer(grpcServer, healthServer)
-	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+
+	probes := []cachehealth.Probe{{Name: "bucket", Check: cacheService.ProbeBucket}}
+	if cfg.Health.Canary {
+		probes = append(probes, cachehealth.Probe{
+			Name:  "canary",
+			Check: func(ctx context.Context) error { return cacheService.ProbeCanary(ctx, identity) },
+		})
+	}
+	probeNames := make([]string, 0, len(probes))
+	for _, probe := range probes {
+		probeNames = append(probeNames, probe.Name)
+	}
+	healthChecker := cachehealth.NewChecker(cachehealth.Config{
+		Interval:         cfg.Health.Interval,
+		Timeout:          cfg.Health.Timeout,
+		FailureThreshold: cfg.Health.FailureThreshold,
+		Services: map[string][]string{
+			"": probeNames,
+			server.BuildCacheService_ServiceDesc.ServiceName: probeNames,
+		},
+	}, healthServer, logger.Named("health"), metricsCollector, probes...)
+	healthCtx, stopHealth := context.WithCancel(ctx)
+	defer stopHealth()
+	go healthChecker.Run(healthCtx)
+	http.Handle("/healthz", healthChecker.Liveness())
+	http.Handle("/readyz", healthChecker.Readiness())
 
 	// Enable reflection for development
 	if cfg.Server.EnableReflection {
@@ -122,13 +536,28 @@ func main() {
 
 	// Start metrics server
 	go func() {
-		http.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+		http.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{
+			// Exemplars linking latency buckets to traces need OpenMetrics
+			EnableOpenMetrics: true,
+		}))
 		logger.Info("Starting metrics server", zap.Int("port", cfg.Metrics.Port))
 		if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Metrics.Port), nil); err != nil {
 			logger.Error("Metrics server failed", zap.Error(err))
 		}
 	}()
 
+	// Start admin server
+	if adminToken != "" {
+		go func() {
+			logger.Info("Starting admin server", zap.Int("port", cfg.Admin.Port))
+			if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Admin.Port), security.RequireBearerToken(adminToken, adminMux)); err != nil {
+				logger.Error("Admin server failed", zap.Error(err))
+			}
+		}()
+	} else {
+		logger.Info("Admin routes disabled, set CACHE_ADMIN_TOKEN_FILE to serve them")
+	}
+
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -137,10 +566,14 @@ func main() {
 	logger.Info("Shutting down gracefully")
 
 	// Graceful shutdown
-	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	healthChecker.Drain()
+	stopHealth()
 	
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelStop()
+
+	// Hand background jobs over to another replica while the server stops
+	stopScheduler()
 
 	done := make(chan struct{})
 	go func() {
@@ -151,8 +584,34 @@ func main() {
 	select {
 	case <-done:
 		logger.Info("Server stopped gracefully")
-	case <-shutdownCtx.Done():
+	case <-stopCtx.Done():
 		logger.Warn("Forcing server shutdown")
 		grpcServer.Stop()
 	}
+
+	// No more calls are served, so the writers flush complete counts
+	stopWriters()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	select {
+	case <-schedulerDone:
+	case <-shutdownCtx.Done():
+		logger.Warn("Background jobs did not stop in time")
+	}
+	select {
+	case <-usageDone:
+	case <-shutdownCtx.Done():
+		logger.Warn("Cache usage was not flushed in time")
+	}
+	select {
+	case <-invocationsDone:
+	case <-shutdownCtx.Done():
+		logger.Warn("Invocation reports were not flushed in time")
+	}
+
+	if err := stack.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Failed to flush traces", zap.Error(err))
+	}
 }
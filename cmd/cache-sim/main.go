@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/accesstrace"
+	"github.com/ruslanbaba/distributed-build-cache/internal/simulation"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+const usage = `usage: cache-sim [flags] <trace.jsonl | ->
+
+Replays an access trace recorded with CACHE_TRACE_ENABLED against one or more
+pruning configurations. Each -config is a comma separated list of:
+
+  name=<label>          name shown in the report
+  strategy=<name>       lru, lfu, gdsf or retention_score (default retention_score)
+  instances=<i:s;...>   per-instance strategies, e.g. ci:lfu;dev:lru
+  max=<size>            max cache size, e.g. 500GB (default 1000GB)
+  target=<size>         size pruning frees down to (default 80% of max)
+  retention=<duration>  retention_score idle limit, e.g. 30d (default 30d)
+  interval=<duration>   time between pruning cycles (default 24h)
+
+Without -config the server defaults are simulated.
+
+flags:
+`
+
+// configFlag collects repeated -config flags
+type configFlag []string
+
+func (c *configFlag) String() string     { return strings.Join(*c, " ") }
+func (c *configFlag) Set(v string) error { *c = append(*c, v); return nil }
+
+func main() {
+	var configs configFlag
+	flag.Var(&configs, "config", "Pruning configuration to simulate, repeatable")
+	window := flag.Duration("window", 24*time.Hour, "Length of the timeline windows, 0 for totals only")
+	timeline := flag.Bool("timeline", false, "Print hit rates and deletions per window")
+	jsonOutput := flag.Bool("json", false, "Print results as JSON")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if len(configs) == 0 {
+		configs = configFlag{""}
+	}
+
+	sims := make([]*simulation.Simulator, 0, len(configs))
+	for i, spec := range configs {
+		cfg, err := parseConfig(spec)
+		if err != nil {
+			fail("-config %q: %v", spec, err)
+		}
+		if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("config-%d", i+1)
+		}
+		sims = append(sims, simulation.New(cfg, *window))
+	}
+
+	var in io.Reader = os.Stdin
+	if path := flag.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fail("failed to open trace: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	// Every configuration sees the trace in a single pass
+	var records int64
+	err := accesstrace.Read(in, func(rec accesstrace.Record) error {
+		records++
+		for _, sim := range sims {
+			sim.Apply(rec)
+		}
+		return nil
+	})
+	if err != nil {
+		fail("failed to read trace: %v", err)
+	}
+	if records == 0 {
+		fail("trace is empty")
+	}
+
+	results := make([]*simulation.Result, len(sims))
+	for i, sim := range sims {
+		results[i] = sim.Finish()
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fail("%v", err)
+		}
+		return
+	}
+
+	fmt.Printf("replayed %d records\n\n", records)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONFIG\tSTRATEGY\tMAX\tGETS\tHIT RATE\tBYTE HIT RATE\tDELETED\tDELETED BYTES\tPEAK\tCYCLES")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%.2f%%\t%.2f%%\t%d\t%s\t%s\t%d\n",
+			r.Name, r.Strategy, formatBytes(r.MaxSize), r.Total.Gets,
+			100*r.Total.HitRate(), 100*r.Total.ByteHitRate(),
+			r.Total.Deleted, formatBytes(r.Total.DeletedBytes), formatBytes(r.PeakSize), r.Cycles)
+	}
+	tw.Flush()
+
+	if *timeline && *window > 0 {
+		printTimeline(results)
+	}
+}
+
+// printTimeline prints the windows of every configuration side by side
+func printTimeline(results []*simulation.Result) {
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "WINDOW"
+	for _, r := range results {
+		header += fmt.Sprintf("\t%s HIT\t%s BYTE HIT\t%s DELETED\t%s SIZE", r.Name, r.Name, r.Name, r.Name)
+	}
+	fmt.Fprintln(tw, header)
+
+	for i := range results[0].Windows {
+		row := results[0].Windows[i].Start.UTC().Format(time.RFC3339)
+		for _, r := range results {
+			if i >= len(r.Windows) {
+				row += "\t-\t-\t-\t-"
+				continue
+			}
+			w := r.Windows[i]
+			row += fmt.Sprintf("\t%.2f%%\t%.2f%%\t%s\t%s",
+				100*w.HitRate(), 100*w.ByteHitRate(), formatBytes(w.DeletedBytes), formatBytes(w.Size))
+		}
+		fmt.Fprintln(tw, row)
+	}
+	tw.Flush()
+}
+
+// parseConfig parses a -config value, starting from the server defaults
+func parseConfig(spec string) (simulation.Config, error) {
+	cfg := simulation.Config{
+		MaxSize:  1000 << 30,
+		Interval: 24 * time.Hour,
+	}
+	strategy := eviction.StrategyRetentionScore
+	perInstance := map[string]string{}
+	retention := 30 * 24 * time.Hour
+
+	for _, field := range strings.Split(spec, ",") {
+		if field == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return cfg, fmt.Errorf("expected key=value, got %q", field)
+		}
+
+		var err error
+		switch k {
+		case "name":
+			cfg.Name = v
+		case "strategy":
+			strategy = v
+		case "instances":
+			for _, pair := range strings.Split(v, ";") {
+				instance, name, ok := strings.Cut(pair, ":")
+				if !ok {
+					return cfg, fmt.Errorf("expected instance:strategy, got %q", pair)
+				}
+				perInstance[instance] = name
+			}
+		case "max":
+			cfg.MaxSize, err = parseBytes(v)
+		case "target":
+			cfg.TargetSize, err = parseBytes(v)
+		case "retention":
+			retention, err = parseDuration(v)
+		case "interval":
+			cfg.Interval, err = parseDuration(v)
+		default:
+			return cfg, fmt.Errorf("unknown key %q", k)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+
+	if cfg.MaxSize <= 0 {
+		return cfg, fmt.Errorf("max must be positive")
+	}
+	if cfg.TargetSize > cfg.MaxSize {
+		return cfg, fmt.Errorf("target cannot exceed max")
+	}
+
+	policy, err := eviction.NewPolicy(strategy, perInstance, eviction.Options{Retention: retention})
+	if err == nil {
+		err = policy.Streamable()
+	}
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Policy = policy
+	return cfg, nil
+}
+
+var byteUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseBytes parses sizes such as 500GB or 1.5TB, in binary units
+func parseBytes(v string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(v))
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(upper, unit.suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %q", v)
+			}
+			return int64(n * unit.scale), nil
+		}
+	}
+	return strconv.ParseInt(upper, 10, 64)
+}
+
+// parseDuration accepts time.ParseDuration syntax and whole days such as 30d
+func parseDuration(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+func formatBytes(b int64) string {
+	for _, unit := range byteUnits {
+		if float64(b) >= unit.scale && unit.scale > 1 {
+			return fmt.Sprintf("%.1f%s", float64(b)/unit.scale, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", b)
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
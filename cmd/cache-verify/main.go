@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/provenance"
+)
+
+func main() {
+	envelopePath := flag.String("envelope", "", "DSSE envelope returned by GetProvenance")
+	publicKey := flag.String("pubkey", "", "PEM encoded ed25519 public key of the cache signer")
+	outputs := flag.String("outputs", ".", "Directory containing the downloaded action outputs")
+	writer := flag.String("writer", "", "Optional identity the action result must have been written by")
+	requireVerified := flag.Bool("require-verified", true, "With -writer, also require the writer identity to have been verified by mTLS")
+	actionDigest := flag.String("action-digest", "", "Optional action digest the attestation must cover")
+	keygen := flag.String("keygen", "", "Generate a signing key at this path (public key written to <path>.pub) and exit")
+	flag.Parse()
+
+	if *keygen != "" {
+		if err := provenance.GenerateKeyPair(*keygen); err != nil {
+			fail("%v", err)
+		}
+		fmt.Printf("wrote %s and %s.pub\n", *keygen, *keygen)
+		return
+	}
+
+	if *envelopePath == "" || *publicKey == "" {
+		fail("-envelope and -pubkey are required")
+	}
+
+	key, err := provenance.LoadPublicKey(*publicKey)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	data, err := os.ReadFile(*envelopePath)
+	if err != nil {
+		fail("failed to read envelope: %v", err)
+	}
+
+	var envelope provenance.Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		fail("failed to parse envelope: %v", err)
+	}
+
+	statement, err := provenance.Verify(&envelope, key)
+	if err != nil {
+		fail("signature verification FAILED: %v", err)
+	}
+
+	params := statement.Predicate.BuildDefinition.ExternalParameters
+	builder := statement.Predicate.RunDetails.Builder
+	if *writer != "" && builder.ID != *writer {
+		fail("attestation written by %q, expected %q", builder.ID, *writer)
+	}
+	if *writer != "" && *requireVerified && !builder.Verified {
+		fail("attestation written by %q, but the identity was not verified by mTLS", builder.ID)
+	}
+	if *actionDigest != "" && params.ActionDigest != *actionDigest {
+		fail("attestation covers action %s, expected %s", params.ActionDigest, *actionDigest)
+	}
+
+	failed := 0
+	for _, subject := range statement.Subject {
+		if subject.IsTree() {
+			// Tree blobs are opaque to the cache, so only the directory's
+			// presence can be checked here
+			path := filepath.Join(*outputs, filepath.FromSlash(subject.Name))
+			if info, err := os.Stat(path); err != nil || !info.IsDir() {
+				fmt.Fprintf(os.Stderr, "MISSING  %s: not a directory\n", subject.Name)
+				failed++
+				continue
+			}
+			fmt.Printf("TREE     %s: tree %s, contents not checked\n", subject.Name, subject.Digest["sha256"])
+			continue
+		}
+
+		actual, err := fileSHA256(filepath.Join(*outputs, filepath.FromSlash(subject.Name)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "MISSING  %s: %v\n", subject.Name, err)
+			failed++
+			continue
+		}
+		if actual != subject.Digest["sha256"] {
+			fmt.Fprintf(os.Stderr, "MISMATCH %s: expected %s, found %s\n", subject.Name, subject.Digest["sha256"], actual)
+			failed++
+			continue
+		}
+		fmt.Printf("OK       %s\n", subject.Name)
+	}
+
+	if failed > 0 {
+		fail("%d of %d outputs do not match the attestation", failed, len(statement.Subject))
+	}
+
+	fmt.Printf("provenance OK: action %s written by %s (verified=%t) at %s, %d outputs\n",
+		params.ActionDigest, builder.ID, builder.Verified,
+		statement.Predicate.RunDetails.Metadata.FinishedOn.Format("2006-01-02T15:04:05Z"), len(statement.Subject))
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
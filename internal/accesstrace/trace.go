@@ -0,0 +1,163 @@
+// Package accesstrace records cache accesses to JSON lines files that
+// cmd/cache-sim replays to compare pruning configurations.
+package accesstrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Operations recorded in a trace
+const (
+	OpGet      = "get"
+	OpPut      = "put"
+	OpContains = "contains"
+)
+
+// flushInterval bounds how long a record stays buffered
+const flushInterval = 5 * time.Second
+
+// Record is one cache access
+type Record struct {
+	Time       time.Time `json:"ts"`
+	Op         string    `json:"op"`
+	Key        string    `json:"key"`
+	Size       int64     `json:"size"`                  // Entry size, or the requested size on a miss
+	Hit        bool      `json:"hit,omitempty"`         // Whether a get or contains found the entry
+	TTLSeconds int64     `json:"ttl_seconds,omitempty"` // TTL a put stored the entry with
+}
+
+// Config configures a Recorder
+type Config struct {
+	File         string
+	MaxSizeBytes int64 // Recording stops once the file reaches this size, 0 for no limit
+}
+
+// Recorder appends records to a trace file. It is safe for concurrent use,
+// and a nil Recorder records nothing.
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	written int64
+	max     int64
+	full    bool
+	logger  *zap.Logger
+	done    chan struct{}
+}
+
+// NewRecorder opens the trace file for appending
+func NewRecorder(cfg Config, logger *zap.Logger) (*Recorder, error) {
+	f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat trace file: %w", err)
+	}
+
+	r := &Recorder{
+		file:    f,
+		buf:     bufio.NewWriter(f),
+		written: info.Size(),
+		max:     cfg.MaxSizeBytes,
+		logger:  logger,
+		done:    make(chan struct{}),
+	}
+	go r.flushLoop()
+	return r, nil
+}
+
+// Record appends a record, dropping it once the size limit is reached
+func (r *Recorder) Record(rec Record) {
+	if r == nil {
+		return
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.full {
+		return
+	}
+	if r.max > 0 && r.written+int64(len(line)) > r.max {
+		r.full = true
+		r.logger.Warn("Access trace reached its size limit, recording stopped", zap.Int64("max_size_bytes", r.max))
+		return
+	}
+	n, err := r.buf.Write(line)
+	r.written += int64(n)
+	if err != nil {
+		r.logger.Error("Failed to write access trace", zap.Error(err))
+	}
+}
+
+// Close flushes buffered records and closes the file
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	close(r.done)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.buf.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+func (r *Recorder) flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			if err := r.buf.Flush(); err != nil {
+				r.logger.Error("Failed to flush access trace", zap.Error(err))
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// Read calls visit for every record of a trace in file order. A truncated
+// last line, as left by a crashed server, ends the trace.
+func Read(rd io.Reader, visit func(Record) error) error {
+	dec := json.NewDecoder(bufio.NewReader(rd))
+	for line := 1; ; line++ {
+		var rec Record
+		err := dec.Decode(&rec)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %w", line, err)
+		}
+		if err := visit(rec); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+)
+
+// Direction identifies which way bytes flow through the cache
+type Direction string
+
+const (
+	// Upload covers bytes received from clients (Put)
+	Upload Direction = "upload"
+	// Download covers bytes sent to clients (Get)
+	Download Direction = "download"
+)
+
+const (
+	scopeInstance = "instance"
+	scopeIdentity = "identity"
+
+	// idleLimiterTTL controls how long an unused limiter is kept around
+	idleLimiterTTL = 10 * time.Minute
+	// sweepThreshold is the limiter count above which idle limiters are swept
+	sweepThreshold = 1024
+)
+
+// Config contains byte-rate limits. A zero rate means unlimited.
+type Config struct {
+	InstanceUploadBytesPerSec   int64
+	InstanceDownloadBytesPerSec int64
+	IdentityUploadBytesPerSec   int64
+	IdentityDownloadBytesPerSec int64
+	BurstBytes                  int
+	InstanceOverrides           map[string]int64 // instance -> bytes/sec for both directions
+}
+
+// Shaper applies token-bucket byte-rate limits per instance and per identity.
+//
+// Every stream reserves tokens in chunk-sized pieces from the shared bucket of
+// its instance and of its identity. Reservations are granted in arrival order,
+// so concurrent streams under the same key are interleaved and share the rate
+// fairly instead of one large transfer starving the rest.
+type Shaper struct {
+	config  Config
+	metrics *metrics.Collector
+
+	mu       sync.Mutex
+	limiters map[string]*limiterEntry
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	scope    string
+	lastUsed time.Time
+}
+
+// NewShaper creates a new bandwidth shaper
+func NewShaper(config Config, metrics *metrics.Collector) *Shaper {
+	if config.BurstBytes <= 0 {
+		config.BurstBytes = 1024 * 1024
+	}
+	return &Shaper{
+		config:   config,
+		metrics:  metrics,
+		limiters: make(map[string]*limiterEntry),
+	}
+}
+
+// Enabled reports whether any limit is configured
+func (s *Shaper) Enabled() bool {
+	if s == nil {
+		return false
+	}
+	c := s.config
+	return c.InstanceUploadBytesPerSec > 0 || c.InstanceDownloadBytesPerSec > 0 ||
+		c.IdentityUploadBytesPerSec > 0 || c.IdentityDownloadBytesPerSec > 0 ||
+		len(c.InstanceOverrides) > 0
+}
+
+// Wait blocks until n bytes may be transferred in the given direction for the
+// instance and identity, or until the context is cancelled. The identity must
+// be one callers cannot choose freely, or a client escapes its limit by
+// sending a new one per request.
+func (s *Shaper) Wait(ctx context.Context, dir Direction, instance, identity string, n int) error {
+	if !s.Enabled() || n <= 0 {
+		return nil
+	}
+
+	s.metrics.BandwidthBytes.WithLabelValues(string(dir)).Add(float64(n))
+
+	entries := s.limitersFor(dir, instance, identity)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	for n > 0 {
+		chunk := n
+		if chunk > s.config.BurstBytes {
+			chunk = s.config.BurstBytes
+		}
+		if err := s.reserve(ctx, dir, entries, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+
+	return nil
+}
+
+// reserve takes chunk tokens from every limiter and sleeps for the longest delay
+func (s *Shaper) reserve(ctx context.Context, dir Direction, entries []*limiterEntry, chunk int) error {
+	now := time.Now()
+	reservations := make([]*rate.Reservation, 0, len(entries))
+
+	var delay time.Duration
+	var scope string
+	for _, entry := range entries {
+		r := entry.limiter.ReserveN(now, chunk)
+		if !r.OK() {
+			cancelAll(reservations, now)
+			return fmt.Errorf("chunk of %d bytes exceeds %s burst", chunk, entry.scope)
+		}
+		reservations = append(reservations, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+			scope = entry.scope
+		}
+	}
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		s.metrics.BandwidthThrottledSeconds.WithLabelValues(string(dir), scope).Add(delay.Seconds())
+		return nil
+	case <-ctx.Done():
+		cancelAll(reservations, time.Now())
+		s.metrics.BandwidthThrottledSeconds.WithLabelValues(string(dir), scope).Add(time.Since(now).Seconds())
+		return ctx.Err()
+	}
+}
+
+// limitersFor returns the limiters that apply to a transfer
+func (s *Shaper) limitersFor(dir Direction, instance, identity string) []*limiterEntry {
+	instanceRate, identityRate := s.rates(dir, instance)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var entries []*limiterEntry
+	if instanceRate > 0 {
+		entries = append(entries, s.limiterLocked(scopeInstance, string(dir)+"/instance/"+instance, instanceRate, now))
+	}
+	if identityRate > 0 && identity != "" {
+		entries = append(entries, s.limiterLocked(scopeIdentity, string(dir)+"/identity/"+identity, identityRate, now))
+	}
+
+	return entries
+}
+
+// rates resolves the configured instance and identity rates for a direction
+func (s *Shaper) rates(dir Direction, instance string) (int64, int64) {
+	var instanceRate, identityRate int64
+	if dir == Upload {
+		instanceRate = s.config.InstanceUploadBytesPerSec
+		identityRate = s.config.IdentityUploadBytesPerSec
+	} else {
+		instanceRate = s.config.InstanceDownloadBytesPerSec
+		identityRate = s.config.IdentityDownloadBytesPerSec
+	}
+
+	if override, ok := s.config.InstanceOverrides[instance]; ok {
+		instanceRate = override
+	}
+
+	return instanceRate, identityRate
+}
+
+func (s *Shaper) limiterLocked(scope, key string, bytesPerSec int64, now time.Time) *limiterEntry {
+	entry, ok := s.limiters[key]
+	if !ok {
+		if len(s.limiters) >= sweepThreshold {
+			s.sweepLocked(now)
+		}
+		entry = &limiterEntry{
+			limiter: rate.NewLimiter(rate.Limit(bytesPerSec), s.config.BurstBytes),
+			scope:   scope,
+		}
+		s.limiters[key] = entry
+	}
+	entry.lastUsed = now
+	return entry
+}
+
+// sweepLocked drops limiters that have not been used recently
+func (s *Shaper) sweepLocked(now time.Time) {
+	for key, entry := range s.limiters {
+		if now.Sub(entry.lastUsed) > idleLimiterTTL {
+			delete(s.limiters, key)
+		}
+	}
+}
+
+func cancelAll(reservations []*rate.Reservation, now time.Time) {
+	for _, r := range reservations {
+		r.CancelAt(now)
+	}
+}
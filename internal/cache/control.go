@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// controlPrefix holds coordination state shared by the replicas, such as
+// scheduler leases and job history. It lives outside the cache entries, so it
+// is never pruned.
+const controlPrefix = "control/"
+
+// maxControlObjectSize bounds how much of a control object is read into memory
+const maxControlObjectSize = 1024 * 1024
+
+// ErrConflict is returned when a control object changed since it was read
+var ErrConflict = errors.New("control object was modified concurrently")
+
+// ReadControlObject returns the content of a control object and its
+// generation, or ErrObjectNotExist if there is none
+func (s *Service) ReadControlObject(ctx context.Context, name string) ([]byte, int64, error) {
+	reader, err := s.client.Bucket(s.bucketName).Object(controlPrefix + name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, 0, err
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read control object %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxControlObjectSize))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read control object %s: %w", name, err)
+	}
+	return data, reader.Attrs.Generation, nil
+}
+
+// WriteControlObject replaces a control object if it is still at generation,
+// or creates it if generation is 0 and it does not exist. It returns the new
+// generation, or ErrConflict if another writer got there first.
+func (s *Service) WriteControlObject(ctx context.Context, name string, data []byte, generation int64) (int64, error) {
+	cond := storage.Conditions{GenerationMatch: generation}
+	if generation == 0 {
+		cond = storage.Conditions{DoesNotExist: true}
+	}
+
+	writer := s.client.Bucket(s.bucketName).Object(controlPrefix + name).If(cond).NewWriter(ctx)
+	writer.ContentType = "application/json"
+	if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
+		writer.Close()
+		return 0, fmt.Errorf("failed to write control object %s: %w", name, err)
+	}
+	if err := writer.Close(); err != nil {
+		if isPreconditionFailed(err) {
+			return 0, ErrConflict
+		}
+		return 0, fmt.Errorf("failed to write control object %s: %w", name, err)
+	}
+	return writer.Attrs().Generation, nil
+}
+
+// DeleteControlObject removes a control object if it is still at generation
+func (s *Service) DeleteControlObject(ctx context.Context, name string, generation int64) error {
+	obj := s.client.Bucket(s.bucketName).Object(controlPrefix + name)
+	err := obj.If(storage.Conditions{GenerationMatch: generation}).Delete(ctx)
+	switch {
+	case err == nil, err == storage.ErrObjectNotExist:
+		return nil
+	case isPreconditionFailed(err):
+		return ErrConflict
+	}
+	return fmt.Errorf("failed to delete control object %s: %w", name, err)
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+// FlushDecisions writes the buffered decisions of a pruning run. Decisions
+// live outside the cache entries, so they are never pruned themselves.
+func (s *Service) FlushDecisions(ctx context.Context, log *eviction.DeletionLog) error {
+	bucket := s.client.Bucket(s.bucketName)
+	return log.Flush(func(object string, data []byte) error {
+		writer := bucket.Object(object).NewWriter(ctx)
+		writer.ContentType = "application/x-ndjson"
+		if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to write decisions: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("failed to close decisions writer: %w", err)
+		}
+		return nil
+	})
+}
+
+// Decisions returns the recorded deletions of a cache key by either pruner,
+// most recent first
+func (s *Service) Decisions(ctx context.Context, key string) ([]eviction.Deletion, error) {
+	bucket := s.client.Bucket(s.bucketName)
+
+	var decisions []eviction.Deletion
+	it := bucket.Objects(ctx, &storage.Query{Prefix: eviction.DeletionShard(key)})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list decisions: %w", err)
+		}
+
+		found, err := s.findDecisions(ctx, attrs.Name, key)
+		if err == storage.ErrObjectNotExist {
+			continue // Purged since listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, found...)
+	}
+
+	sort.Slice(decisions, func(i, j int) bool {
+		return decisions[i].DeletedAt.After(decisions[j].DeletedAt)
+	})
+	return decisions, nil
+}
+
+func (s *Service) findDecisions(ctx context.Context, object, key string) ([]eviction.Deletion, error) {
+	reader, err := s.client.Bucket(s.bucketName).Object(object).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	found, err := eviction.FindDeletions(reader, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", object, err)
+	}
+	return found, nil
+}
+
+// PurgeDecisions deletes decisions recorded before the cutoff
+func (s *Service) PurgeDecisions(ctx context.Context, before time.Time) (int, error) {
+	bucket := s.client.Bucket(s.bucketName)
+
+	purged := 0
+	it := bucket.Objects(ctx, &storage.Query{Prefix: eviction.DeletionPrefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return purged, nil
+		}
+		if err != nil {
+			return purged, fmt.Errorf("failed to list decisions: %w", err)
+		}
+
+		flushed, ok := eviction.DeletionObjectTime(attrs.Name)
+		if !ok {
+			flushed = attrs.Updated
+		}
+		if !flushed.Before(before) {
+			continue
+		}
+		if err := bucket.Object(attrs.Name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return purged, fmt.Errorf("failed to delete %s: %w", attrs.Name, err)
+		}
+		purged++
+	}
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"go.uber.org/zap"
+)
+
+// healthPrefix holds the canary objects of the health probes, one per replica
+const healthPrefix = controlPrefix + "health/"
+
+// ProbeBucket checks that the bucket is reachable with the credentials in use
+func (s *Service) ProbeBucket(ctx context.Context) (err error) {
+	ctx, span := s.startSpan(ctx, "bucket_attrs", "")
+	defer func() { endSpan(span, err) }()
+
+	if _, err := s.client.Bucket(s.bucketName).Attrs(ctx); err != nil {
+		return fmt.Errorf("failed to read bucket attributes: %w", err)
+	}
+	return nil
+}
+
+// ProbeCanary writes a fresh token to the canary object of a replica and
+// reads it back, checking that objects can be both stored and served. The
+// object is removed again, so replicas that go away leave nothing behind.
+func (s *Service) ProbeCanary(ctx context.Context, replica string) error {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	want := []byte(hex.EncodeToString(token))
+
+	obj := s.client.Bucket(s.bucketName).Object(healthPrefix + replica)
+	wctx, span := s.startSpan(ctx, "writer", obj.ObjectName())
+	writer := obj.NewWriter(wctx)
+	writer.ContentType = "text/plain"
+	_, err := io.Copy(writer, bytes.NewReader(want))
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("failed to write canary: %w", err)
+	}
+
+	reader, err := s.openReader(ctx, obj)
+	if err != nil {
+		return fmt.Errorf("failed to read canary: %w", err)
+	}
+	defer reader.Close()
+	defer func() {
+		if err := obj.Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			s.logger.Warn("Failed to delete canary", zap.Error(err))
+		}
+	}()
+	got, err := io.ReadAll(io.LimitReader(reader, int64(len(want))+1))
+	if err != nil {
+		return fmt.Errorf("failed to read canary: %w", err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("canary read back different content")
+	}
+	return nil
+}
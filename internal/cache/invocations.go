@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// invocationPrefix holds the invocation reports of every replica. Like the
+// other control objects they live outside the cache entries, so they neither
+// count towards the cache size nor can be read as cache keys, and are
+// purged by their own retention.
+const invocationPrefix = controlPrefix + "invocations/"
+
+// maxInvocationReportSize bounds how much of a stored report is read
+const maxInvocationReportSize = 32 * 1024 * 1024
+
+// InvocationReport is the report one replica stored for an invocation
+type InvocationReport struct {
+	Replica string
+	Data    []byte
+	Updated time.Time
+}
+
+// invocationObject names the report of a replica. Both parts are escaped, so
+// the prefix of one invocation never matches another.
+func invocationObject(invocationID, replica string) string {
+	return invocationPrefix + url.PathEscape(invocationID) + "/" + url.PathEscape(replica)
+}
+
+// WriteInvocationReport stores the report of a replica for an invocation
+func (s *Service) WriteInvocationReport(ctx context.Context, invocationID, replica string, data []byte) error {
+	object := invocationObject(invocationID, replica)
+	writer := s.client.Bucket(s.bucketName).Object(object).NewWriter(ctx)
+	writer.ContentType = "application/json"
+	if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
+		writer.Close()
+		return fmt.Errorf("failed to write %s: %w", object, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", object, err)
+	}
+	return nil
+}
+
+// InvocationReports returns the reports every replica stored for an invocation
+func (s *Service) InvocationReports(ctx context.Context, invocationID string) ([]InvocationReport, error) {
+	bucket := s.client.Bucket(s.bucketName)
+	prefix := invocationPrefix + url.PathEscape(invocationID) + "/"
+
+	var reports []InvocationReport
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return reports, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list invocation reports: %w", err)
+		}
+
+		data, err := s.readInvocationReport(ctx, bucket.Object(attrs.Name))
+		if err == storage.ErrObjectNotExist {
+			continue // Purged since listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		replica, err := url.PathUnescape(strings.TrimPrefix(attrs.Name, prefix))
+		if err != nil {
+			replica = strings.TrimPrefix(attrs.Name, prefix)
+		}
+		reports = append(reports, InvocationReport{Replica: replica, Data: data, Updated: attrs.Updated})
+	}
+}
+
+func (s *Service) readInvocationReport(ctx context.Context, obj *storage.ObjectHandle) ([]byte, error) {
+	reader, err := s.openReader(ctx, obj)
+	if err == storage.ErrObjectNotExist {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", obj.ObjectName(), err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxInvocationReportSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", obj.ObjectName(), err)
+	}
+	return data, nil
+}
+
+// PurgeInvocationReports deletes reports last written before the cutoff
+func (s *Service) PurgeInvocationReports(ctx context.Context, before time.Time) (int, error) {
+	bucket := s.client.Bucket(s.bucketName)
+
+	purged := 0
+	it := bucket.Objects(ctx, &storage.Query{Prefix: invocationPrefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return purged, nil
+		}
+		if err != nil {
+			return purged, fmt.Errorf("failed to list invocation reports: %w", err)
+		}
+
+		if !attrs.Updated.Before(before) {
+			continue
+		}
+		if err := bucket.Object(attrs.Name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return purged, fmt.Errorf("failed to delete %s: %w", attrs.Name, err)
+		}
+		purged++
+	}
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+// maxPinSize bounds how much of a stored pin is read into memory
+const maxPinSize = 64 * 1024 * 1024
+
+// Stat returns the attributes of a cache entry without recording an access
+func (s *Service) Stat(ctx context.Context, key string) (*CacheEntry, error) {
+	_, attrs, err := s.objectAttrs(ctx, key)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, fmt.Errorf("cache miss for key %s: %w", key, err)
+		}
+		s.metrics.CacheErrors.WithLabelValues("get_attrs").Inc()
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+
+	return &CacheEntry{
+		Key:          key,
+		Size:         attrs.Size,
+		LastAccessed: attrs.Updated,
+		ContentType:  attrs.ContentType,
+		Hash:         fmt.Sprintf("%x", attrs.MD5),
+		Metadata:     attrs.Metadata,
+	}, nil
+}
+
+// SavePin stores a pin. Pins live outside the cache entries, so they are
+// never pruned themselves.
+func (s *Service) SavePin(ctx context.Context, pin *eviction.Pin) error {
+	data, err := json.Marshal(pin)
+	if err != nil {
+		return fmt.Errorf("failed to encode pin: %w", err)
+	}
+
+	writer := s.client.Bucket(s.bucketName).Object(eviction.PinObject(pin.ID)).NewWriter(ctx)
+	writer.ContentType = "application/json"
+	if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
+		writer.Close()
+		return fmt.Errorf("failed to write pin: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close pin writer: %w", err)
+	}
+	return nil
+}
+
+// LoadPins returns every stored pin, including expired ones
+func (s *Service) LoadPins(ctx context.Context) ([]*eviction.Pin, error) {
+	bucket := s.client.Bucket(s.bucketName)
+
+	var pins []*eviction.Pin
+	it := bucket.Objects(ctx, &storage.Query{Prefix: eviction.PinPrefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return pins, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pins: %w", err)
+		}
+		if !strings.HasSuffix(attrs.Name, ".json") {
+			continue
+		}
+
+		pin, err := s.readPin(ctx, attrs.Name)
+		if err == storage.ErrObjectNotExist {
+			continue // Removed since listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+}
+
+// LoadPin returns a stored pin, or ErrObjectNotExist if there is none
+func (s *Service) LoadPin(ctx context.Context, id string) (*eviction.Pin, error) {
+	return s.readPin(ctx, eviction.PinObject(id))
+}
+
+func (s *Service) readPin(ctx context.Context, name string) (*eviction.Pin, error) {
+	reader, err := s.client.Bucket(s.bucketName).Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pin %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxPinSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pin %s: %w", name, err)
+	}
+	pin, err := eviction.DecodePin(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return pin, nil
+}
+
+// DeletePin removes a pin, reporting whether it existed
+func (s *Service) DeletePin(ctx context.Context, id string) (bool, error) {
+	err := s.client.Bucket(s.bucketName).Object(eviction.PinObject(id)).Delete(ctx)
+	switch {
+	case err == storage.ErrObjectNotExist:
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to delete pin: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+// sha256Hash matches CAS keys whose content can be verified
+var sha256Hash = regexp.MustCompile(`^[a-fA-F0-9]{64}$`)
+
+// ScrubStats summarizes a scrub
+type ScrubStats struct {
+	Checked      int64 `json:"checked"`
+	CheckedBytes int64 `json:"checked_bytes"`
+	Corrupt      int64 `json:"corrupt"`
+	Deleted      int64 `json:"deleted"`
+}
+
+// Scrub reads every CAS blob with a SHA-256 digest and deletes the ones whose
+// content no longer matches it, so they are uploaded again rather than served.
+// It reads the whole bucket, so it is meant for infrequent background runs.
+func (s *Service) Scrub(ctx context.Context) (ScrubStats, error) {
+	var stats ScrubStats
+	err := s.WalkKeyPrefix(ctx, "", func(entry *CacheEntry) error {
+		if !eviction.IsBlobKey(entry.Key) {
+			return nil
+		}
+		expected := entry.Key[strings.LastIndex(entry.Key, "/")+1:]
+		if !sha256Hash.MatchString(expected) {
+			return nil
+		}
+
+		actual, err := s.contentHash(ctx, entry.Key)
+		if IsNotFound(err) {
+			return nil // Pruned since listing
+		}
+		if err != nil {
+			return err
+		}
+		stats.Checked++
+		stats.CheckedBytes += entry.Size
+		if strings.EqualFold(actual, expected) {
+			return nil
+		}
+
+		stats.Corrupt++
+		s.metrics.CacheErrors.WithLabelValues("scrub_mismatch").Inc()
+		s.logger.Warn("Scrub found corrupt cache entry",
+			zap.String("key", entry.Key),
+			zap.String("actual_hash", actual),
+		)
+		if err := s.Delete(ctx, entry.Key); err != nil {
+			s.logger.Error("Failed to delete corrupt cache entry", zap.String("key", entry.Key), zap.Error(err))
+			return nil
+		}
+		stats.Deleted++
+		return nil
+	})
+	return stats, err
+}
+
+// contentHash returns the hex SHA-256 of a cache entry without recording an
+// access
+func (s *Service) contentHash(ctx context.Context, key string) (string, error) {
+	reader, err := s.Peek(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -3,24 +3,40 @@ package cache
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"google.golang.org/api/iterator"
 
 	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
 )
 
+// objectPrefix is where cache entries are stored in the bucket
+const objectPrefix = "cache/"
+
+// ErrDigestMismatch is returned when uploaded content does not hash to the expected digest
+var ErrDigestMismatch = errors.New("digest mismatch")
+
 // Service provides cache operations with Cloud Storage backend
 type Service struct {
 	client     *storage.Client
 	bucketName string
 	logger     *zap.Logger
 	metrics    *metrics.Collector
+
+	resurrectTrashed bool
+
+	// Bytes added minus bytes removed by this replica since the last usage flush
+	pendingUsage atomic.Int64
 }
 
 // CacheEntry represents a cached build artifact
@@ -30,6 +46,20 @@ type CacheEntry struct {
 	LastAccessed time.Time
 	ContentType  string
 	Hash         string
+	Metadata     map[string]string
+}
+
+// IsNotFound reports whether err is a cache miss
+func IsNotFound(err error) bool {
+	return errors.Is(err, storage.ErrObjectNotExist)
+}
+
+// PutOptions carries optional attributes for a cache write
+type PutOptions struct {
+	ContentType  string
+	ExpectedHash string            // Hex SHA-256; the write is aborted when the content differs
+	Metadata     map[string]string // Extra object metadata stored with the entry
+	TTL          time.Duration     // The entry reads as a miss this long after the write, 0 for no expiry
 }
 
 // NewService creates a new cache service
@@ -42,21 +72,30 @@ func NewService(client *storage.Client, bucketName string, logger *zap.Logger, m
 	}
 }
 
+// SetResurrectTrashed makes Get restore entries soft-deleted by pruning
+// instead of reporting a miss, at the cost of a trash lookup per miss
+func (s *Service) SetResurrectTrashed(enabled bool) {
+	s.resurrectTrashed = enabled
+}
+
 // Get retrieves a cache entry from Cloud Storage
 func (s *Service) Get(ctx context.Context, key string) (io.ReadCloser, *CacheEntry, error) {
 	start := time.Now()
 	defer func() {
-		s.metrics.CacheOperationDuration.WithLabelValues("get").Observe(time.Since(start).Seconds())
+		metrics.ObserveWithTrace(ctx, s.metrics.CacheOperationDuration.WithLabelValues("get"), time.Since(start).Seconds())
 	}()
 
-	// Sanitize key
-	objectName := s.sanitizeKey(key)
-	
-	bucket := s.client.Bucket(s.bucketName)
-	obj := bucket.Object(objectName)
-
 	// Get object attributes
-	attrs, err := obj.Attrs(ctx)
+	objectName, attrs, err := s.objectAttrs(ctx, key)
+	obj := s.client.Bucket(s.bucketName).Object(objectName)
+	if err == storage.ErrObjectNotExist && s.resurrectTrashed {
+		if restored, resurrectErr := s.resurrect(ctx, objectName); resurrectErr == nil {
+			attrs, err = restored, nil
+			s.metrics.CacheHits.WithLabelValues("resurrected").Inc()
+		} else if resurrectErr != storage.ErrObjectNotExist {
+			s.logger.Warn("Failed to resurrect trashed entry", zap.String("key", key), zap.Error(resurrectErr))
+		}
+	}
 	if err != nil {
 		if err == storage.ErrObjectNotExist {
 			s.metrics.CacheHits.WithLabelValues("miss").Inc()
@@ -66,17 +105,29 @@ func (s *Service) Get(ctx context.Context, key string) (io.ReadCloser, *CacheEnt
 		return nil, nil, fmt.Errorf("failed to get object attributes: %w", err)
 	}
 
-	// Update last accessed time
-	if _, err := obj.Update(ctx, storage.ObjectAttrsToUpdate{
+	// Expired entries stay in the bucket until the pruner deletes them
+	if expiresAt := eviction.ExpiresAt(attrs.Metadata); !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+		s.metrics.CacheHits.WithLabelValues("expired").Inc()
+		return nil, nil, fmt.Errorf("cache miss for key %s, expired at %s: %w", key, expiresAt.Format(time.RFC3339), storage.ErrObjectNotExist)
+	}
+
+	// Update last accessed time and the approximate read count used by
+	// frequency-aware eviction strategies
+	accessCount, _ := strconv.ParseInt(attrs.Metadata["access_count"], 10, 64)
+	updateCtx, span := s.startSpan(ctx, "update", objectName)
+	_, err = obj.Update(updateCtx, storage.ObjectAttrsToUpdate{
 		Metadata: map[string]string{
 			"last_accessed": time.Now().Format(time.RFC3339),
+			"access_count":  strconv.FormatInt(accessCount+1, 10),
 		},
-	}); err != nil {
+	})
+	endSpan(span, err)
+	if err != nil {
 		s.logger.Warn("Failed to update last accessed time", zap.Error(err))
 	}
 
 	// Open reader
-	reader, err := obj.NewReader(ctx)
+	reader, err := s.openReader(ctx, obj)
 	if err != nil {
 		s.metrics.CacheErrors.WithLabelValues("read").Inc()
 		return nil, nil, fmt.Errorf("failed to create reader: %w", err)
@@ -87,7 +138,8 @@ func (s *Service) Get(ctx context.Context, key string) (io.ReadCloser, *CacheEnt
 		Size:         attrs.Size,
 		LastAccessed: attrs.Updated,
 		ContentType:  attrs.ContentType,
-		Hash:         attrs.MD5,
+		Hash:         fmt.Sprintf("%x", attrs.MD5),
+		Metadata:     attrs.Metadata,
 	}
 
 	s.metrics.CacheHits.WithLabelValues("hit").Inc()
@@ -101,11 +153,36 @@ func (s *Service) Get(ctx context.Context, key string) (io.ReadCloser, *CacheEnt
 	return reader, entry, nil
 }
 
+// Peek opens a cache entry without recording an access, for background
+// readers such as the pruning service
+func (s *Service) Peek(ctx context.Context, key string) (io.ReadCloser, error) {
+	bucket := s.client.Bucket(s.bucketName)
+	reader, err := s.openReader(ctx, bucket.Object(s.sanitizeKey(key)))
+	if err == storage.ErrObjectNotExist {
+		if legacyName, _, legacyErr := s.legacyObject(ctx, key); legacyErr == nil {
+			reader, err = s.openReader(ctx, bucket.Object(legacyName))
+		}
+	}
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, fmt.Errorf("cache miss for key %s: %w", key, err)
+		}
+		s.metrics.CacheErrors.WithLabelValues("read").Inc()
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	return reader, nil
+}
+
 // Put stores a cache entry in Cloud Storage
 func (s *Service) Put(ctx context.Context, key string, data io.Reader, contentType string) error {
+	return s.PutWithOptions(ctx, key, data, PutOptions{ContentType: contentType})
+}
+
+// PutWithOptions stores a cache entry, optionally verifying its content hash
+func (s *Service) PutWithOptions(ctx context.Context, key string, data io.Reader, opts PutOptions) error {
 	start := time.Now()
 	defer func() {
-		s.metrics.CacheOperationDuration.WithLabelValues("put").Observe(time.Since(start).Seconds())
+		metrics.ObserveWithTrace(ctx, s.metrics.CacheOperationDuration.WithLabelValues("put"), time.Since(start).Seconds())
 	}()
 
 	// Sanitize key
@@ -114,38 +191,64 @@ func (s *Service) Put(ctx context.Context, key string, data io.Reader, contentTy
 	bucket := s.client.Bucket(s.bucketName)
 	obj := bucket.Object(objectName)
 
+	// Cancelling the writer context aborts the upload without creating the object
+	writeCtx, span := s.startSpan(ctx, "writer", objectName)
+	writeCtx, cancel := context.WithCancel(writeCtx)
+	defer cancel()
+
 	// Create writer with metadata
-	writer := obj.NewWriter(ctx)
-	writer.ContentType = contentType
+	writer := obj.NewWriter(writeCtx)
+	writer.ContentType = opts.ContentType
 	writer.Metadata = map[string]string{
 		"cache_key":      key,
 		"last_accessed":  time.Now().Format(time.RFC3339),
 		"stored_at":      time.Now().Format(time.RFC3339),
 	}
+	for k, v := range opts.Metadata {
+		writer.Metadata[k] = v
+	}
+	if opts.TTL > 0 {
+		writer.Metadata[eviction.ExpiresAtKey] = time.Now().Add(opts.TTL).Format(time.RFC3339)
+	}
 
 	// Copy data and calculate hash
 	hash := sha256.New()
 	tee := io.TeeReader(data, hash)
 	
 	size, err := io.Copy(writer, tee)
+	span.SetAttributes(attribute.Int64("gcs.bytes_written", size))
 	if err != nil {
+		cancel()
 		writer.Close()
+		endSpan(span, err)
 		s.metrics.CacheErrors.WithLabelValues("write").Inc()
 		return fmt.Errorf("failed to write data: %w", err)
 	}
 
-	if err := writer.Close(); err != nil {
+	actualHash := fmt.Sprintf("%x", hash.Sum(nil))
+	if opts.ExpectedHash != "" && !strings.EqualFold(opts.ExpectedHash, actualHash) {
+		cancel()
+		writer.Close()
+		endSpan(span, ErrDigestMismatch)
+		s.metrics.CacheErrors.WithLabelValues("digest_mismatch").Inc()
+		return fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, opts.ExpectedHash, actualHash)
+	}
+
+	err = writer.Close()
+	endSpan(span, err)
+	if err != nil {
 		s.metrics.CacheErrors.WithLabelValues("close").Inc()
 		return fmt.Errorf("failed to close writer: %w", err)
 	}
 
 	s.metrics.CacheWrites.Inc()
 	s.metrics.CacheSize.Add(float64(size))
+	s.pendingUsage.Add(size)
 	
 	s.logger.Debug("Cache write", 
 		zap.String("key", key),
 		zap.Int64("size", size),
-		zap.String("hash", fmt.Sprintf("%x", hash.Sum(nil))),
+		zap.String("hash", actualHash),
 	)
 
 	return nil
@@ -155,21 +258,20 @@ func (s *Service) Put(ctx context.Context, key string, data io.Reader, contentTy
 func (s *Service) Delete(ctx context.Context, key string) error {
 	start := time.Now()
 	defer func() {
-		s.metrics.CacheOperationDuration.WithLabelValues("delete").Observe(time.Since(start).Seconds())
+		metrics.ObserveWithTrace(ctx, s.metrics.CacheOperationDuration.WithLabelValues("delete"), time.Since(start).Seconds())
 	}()
 
-	objectName := s.sanitizeKey(key)
-	
-	bucket := s.client.Bucket(s.bucketName)
-	obj := bucket.Object(objectName)
-
 	// Get size before deletion for metrics
-	attrs, err := obj.Attrs(ctx)
+	objectName, attrs, err := s.objectAttrs(ctx, key)
 	if err != nil && err != storage.ErrObjectNotExist {
 		s.logger.Warn("Failed to get object attributes before deletion", zap.Error(err))
 	}
+	obj := s.client.Bucket(s.bucketName).Object(objectName)
 
-	if err := obj.Delete(ctx); err != nil {
+	deleteCtx, span := s.startSpan(ctx, "delete", objectName)
+	err = obj.Delete(deleteCtx)
+	endSpan(span, err)
+	if err != nil {
 		if err == storage.ErrObjectNotExist {
 			return nil // Already deleted
 		}
@@ -179,6 +281,7 @@ func (s *Service) Delete(ctx context.Context, key string) error {
 
 	if attrs != nil {
 		s.metrics.CacheSize.Sub(float64(attrs.Size))
+		s.pendingUsage.Add(-attrs.Size)
 	}
 	
 	s.metrics.CacheDeletions.Inc()
@@ -188,21 +291,54 @@ func (s *Service) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
-// List returns cache entries for pruning analysis
+// List returns cache entries whose object name starts with prefix
 func (s *Service) List(ctx context.Context, prefix string) ([]*CacheEntry, error) {
-	bucket := s.client.Bucket(s.bucketName)
-	query := &storage.Query{Prefix: prefix}
-	
 	var entries []*CacheEntry
-	it := bucket.Objects(ctx, query)
-	
+	err := s.Walk(ctx, prefix, func(entry *CacheEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Walk streams cache entries whose object name starts with prefix to fn
+// without holding the listing in memory. An error from fn stops the walk.
+func (s *Service) Walk(ctx context.Context, prefix string, fn func(*CacheEntry) error) error {
+	return s.walk(ctx, &storage.Query{Prefix: prefix}, "", fn)
+}
+
+// WalkFrom streams cache entries listed after the entry of afterKey to fn,
+// or every entry if afterKey is empty, so a long walk can be done in parts
+func (s *Service) WalkFrom(ctx context.Context, afterKey string, fn func(*CacheEntry) error) error {
+	query := &storage.Query{Prefix: objectPrefix}
+	skip := ""
+	if afterKey != "" {
+		skip = s.sanitizeKey(afterKey)
+		query.StartOffset = skip
+	}
+	return s.walk(ctx, query, skip, fn)
+}
+
+// walk lists query, skipping the object named skip
+func (s *Service) walk(ctx context.Context, query *storage.Query, skip string, fn func(*CacheEntry) error) error {
+	if err := query.SetAttrSelection([]string{"Name", "Size", "Updated", "ContentType", "MD5", "Metadata"}); err != nil {
+		return err
+	}
+
+	it := s.client.Bucket(s.bucketName).Objects(ctx, query)
 	for {
 		attrs, err := it.Next()
 		if err == iterator.Done {
-			break
+			return nil
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to list objects: %w", err)
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+		if attrs.Name == skip {
+			continue
 		}
 
 		// Parse last accessed time
@@ -219,22 +355,62 @@ func (s *Service) List(ctx context.Context, prefix string) ([]*CacheEntry, error
 			LastAccessed: lastAccessed,
 			ContentType:  attrs.ContentType,
 			Hash:         fmt.Sprintf("%x", attrs.MD5),
+			Metadata:     attrs.Metadata,
 		}
 
 		if entry.Key == "" {
 			entry.Key = attrs.Name
 		}
 
-		entries = append(entries, entry)
+		if err := fn(entry); err != nil {
+			return err
+		}
 	}
+}
 
+// ListKeyPrefix returns cache entries whose cache key starts with keyPrefix
+func (s *Service) ListKeyPrefix(ctx context.Context, keyPrefix string) ([]*CacheEntry, error) {
+	var entries []*CacheEntry
+	err := s.WalkKeyPrefix(ctx, keyPrefix, func(entry *CacheEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return entries, nil
 }
 
-// GetTotalSize returns the total size of all cached objects
+// WalkKeyPrefix streams cache entries whose cache key starts with keyPrefix
+// to fn. Trashed entries are not included. Entries still stored under their
+// legacy object name are listed too.
+func (s *Service) WalkKeyPrefix(ctx context.Context, keyPrefix string, fn func(*CacheEntry) error) error {
+	keep := func(entry *CacheEntry) error {
+		if !strings.HasPrefix(entry.Key, keyPrefix) {
+			return nil // Another key sharing the legacy name prefix
+		}
+		return fn(entry)
+	}
+
+	prefix := s.sanitizeKey(keyPrefix)
+	legacy := objectPrefix + eviction.LegacyEscapeKey(keyPrefix)
+	switch {
+	case strings.HasPrefix(legacy, prefix):
+		return s.Walk(ctx, prefix, keep)
+	case strings.HasPrefix(prefix, legacy):
+		return s.Walk(ctx, legacy, keep)
+	}
+	if err := s.Walk(ctx, prefix, keep); err != nil {
+		return err
+	}
+	return s.Walk(ctx, legacy, keep)
+}
+
+// GetTotalSize returns the total size of all cached objects, not counting
+// trashed ones
 func (s *Service) GetTotalSize(ctx context.Context) (int64, error) {
 	bucket := s.client.Bucket(s.bucketName)
-	it := bucket.Objects(ctx, nil)
+	it := bucket.Objects(ctx, &storage.Query{Prefix: objectPrefix})
 	
 	var totalSize int64
 	for {
@@ -251,16 +427,48 @@ func (s *Service) GetTotalSize(ctx context.Context) (int64, error) {
 	return totalSize, nil
 }
 
-// sanitizeKey ensures the key is valid for Cloud Storage object names
+// sanitizeKey returns the object a key is stored in. Distinct keys never
+// share an object, see eviction.EscapeKey.
 func (s *Service) sanitizeKey(key string) string {
-	// Replace invalid characters and ensure it doesn't start with '.'
-	sanitized := strings.ReplaceAll(key, "/", "_")
-	sanitized = strings.ReplaceAll(sanitized, "\\", "_")
-	sanitized = strings.ReplaceAll(sanitized, ":", "_")
-	
-	if strings.HasPrefix(sanitized, ".") {
-		sanitized = "cache_" + sanitized
+	return objectPrefix + eviction.EscapeKey(key)
+}
+
+// objectAttrs returns the object holding key and its attributes, falling back
+// to the legacy object name. On a miss the current object name is returned.
+func (s *Service) objectAttrs(ctx context.Context, key string) (string, *storage.ObjectAttrs, error) {
+	objectName := s.sanitizeKey(key)
+	attrsCtx, span := s.startSpan(ctx, "attrs", objectName)
+	attrs, err := s.client.Bucket(s.bucketName).Object(objectName).Attrs(attrsCtx)
+	endSpan(span, err)
+	if err != storage.ErrObjectNotExist {
+		return objectName, attrs, err
 	}
-	
-	return fmt.Sprintf("cache/%s", sanitized)
+
+	legacyName, legacyAttrs, legacyErr := s.legacyObject(ctx, key)
+	if legacyErr == storage.ErrObjectNotExist {
+		return objectName, nil, err
+	}
+	return legacyName, legacyAttrs, legacyErr
+}
+
+// legacyObject returns the object key was stored in before object names were
+// escaped. Several keys shared such names, so the object only counts if it
+// recorded key; otherwise, or if the name did not change, it reports
+// ErrObjectNotExist.
+func (s *Service) legacyObject(ctx context.Context, key string) (string, *storage.ObjectAttrs, error) {
+	objectName := objectPrefix + eviction.LegacyEscapeKey(key)
+	if objectName == s.sanitizeKey(key) {
+		return "", nil, storage.ErrObjectNotExist
+	}
+
+	attrsCtx, span := s.startSpan(ctx, "attrs", objectName)
+	attrs, err := s.client.Bucket(s.bucketName).Object(objectName).Attrs(attrsCtx)
+	endSpan(span, err)
+	if err != nil {
+		return "", nil, err
+	}
+	if attrs.Metadata["cache_key"] != key {
+		return "", nil, storage.ErrObjectNotExist
+	}
+	return objectName, attrs, nil
 }
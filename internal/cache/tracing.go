@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates a span per storage call, as a child of the RPC span in the
+// context. It is a no-op until a tracer provider is installed.
+var tracer = otel.Tracer("github.com/ruslanbaba/distributed-build-cache/internal/cache")
+
+// startSpan starts the span of a storage call on an object
+func (s *Service) startSpan(ctx context.Context, op, object string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "storage."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gcs.bucket", s.bucketName),
+			attribute.String("gcs.object", object),
+		),
+	)
+}
+
+// endSpan ends a storage span, marking it failed unless err is a miss
+func endSpan(span trace.Span, err error) {
+	if err != nil && !IsNotFound(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedReader ends the span of a read when the reader is closed, so the
+// span covers streaming the content rather than only opening it
+type tracedReader struct {
+	io.ReadCloser
+	span trace.Span
+	read int64
+	err  error
+}
+
+func (r *tracedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *tracedReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.err == nil {
+		r.err = err
+	}
+	r.span.SetAttributes(attribute.Int64("gcs.bytes_read", r.read))
+	endSpan(r.span, r.err)
+	return err
+}
+
+// openReader opens an object in a reader span that ends when it is closed
+func (s *Service) openReader(ctx context.Context, obj *storage.ObjectHandle) (io.ReadCloser, error) {
+	ctx, span := s.startSpan(ctx, "reader", obj.ObjectName())
+	reader, err := obj.NewReader(ctx)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedReader{ReadCloser: reader, span: span}, nil
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+)
+
+// Soft-deleted entries are moved under trashPrefix with the object name they
+// had under objectPrefix, and stay there until the trash retention passes. The
+// pruning service uses the same layout, so either can restore the other's
+// trash.
+const (
+	trashPrefix  = "trash/"
+	trashedAtKey = "trashed_at" // RFC3339 time the entry was trashed
+	trashRunKey  = "trash_run"  // ID of the pruning run that trashed it
+)
+
+// Trash soft-deletes a cache entry, moving it to the trash where it can be
+// restored until PurgeTrash removes it
+func (s *Service) Trash(ctx context.Context, key, runID string) error {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveWithTrace(ctx, s.metrics.CacheOperationDuration.WithLabelValues("trash"), time.Since(start).Seconds())
+	}()
+
+	bucket := s.client.Bucket(s.bucketName)
+	objectName, attrs, err := s.objectAttrs(ctx, key)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil // Already deleted
+		}
+		s.metrics.CacheErrors.WithLabelValues("trash").Inc()
+		return fmt.Errorf("failed to get object attributes: %w", err)
+	}
+
+	// The generation precondition leaves entries rewritten since they were
+	// selected alone
+	src := bucket.Object(objectName).If(storage.Conditions{GenerationMatch: attrs.Generation})
+	copier := bucket.Object(trashName(objectName)).CopierFrom(src)
+	copier.ContentType = attrs.ContentType
+	copier.Metadata = make(map[string]string, len(attrs.Metadata)+2)
+	for k, v := range attrs.Metadata {
+		copier.Metadata[k] = v
+	}
+	copier.Metadata[trashedAtKey] = time.Now().Format(time.RFC3339)
+	copier.Metadata[trashRunKey] = runID
+
+	copyCtx, span := s.startSpan(ctx, "copy", objectName)
+	_, err = copier.Run(copyCtx)
+	endSpan(span, err)
+	if err != nil {
+		s.metrics.CacheErrors.WithLabelValues("trash").Inc()
+		return fmt.Errorf("failed to copy object to trash: %w", err)
+	}
+	deleteCtx, span := s.startSpan(ctx, "delete", objectName)
+	err = src.Delete(deleteCtx)
+	endSpan(span, err)
+	if err != nil && err != storage.ErrObjectNotExist {
+		s.metrics.CacheErrors.WithLabelValues("trash").Inc()
+		return fmt.Errorf("failed to delete trashed object: %w", err)
+	}
+
+	s.metrics.CacheSize.Sub(float64(attrs.Size))
+	s.metrics.CacheDeletions.Inc()
+	s.pendingUsage.Add(-attrs.Size)
+
+	s.logger.Debug("Cache trash", zap.String("key", key), zap.String("run", runID))
+
+	return nil
+}
+
+// PurgeTrash permanently deletes entries trashed before cutoff and returns how
+// many were deleted and their size
+func (s *Service) PurgeTrash(ctx context.Context, cutoff time.Time) (int, int64, error) {
+	bucket := s.client.Bucket(s.bucketName)
+
+	query := &storage.Query{Prefix: trashPrefix}
+	if err := query.SetAttrSelection([]string{"Name", "Size", "Updated", "Generation", "Metadata"}); err != nil {
+		return 0, 0, err
+	}
+
+	var purged int
+	var purgedSize int64
+	it := bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return purged, purgedSize, nil
+		}
+		if err != nil {
+			return purged, purgedSize, fmt.Errorf("failed to list trash: %w", err)
+		}
+
+		if !trashedAt(attrs).Before(cutoff) {
+			continue
+		}
+
+		err = bucket.Object(attrs.Name).If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+		if err != nil && err != storage.ErrObjectNotExist {
+			s.logger.Warn("Failed to purge trashed object", zap.String("object", attrs.Name), zap.Error(err))
+			continue
+		}
+		purged++
+		purgedSize += attrs.Size
+	}
+}
+
+// resurrect restores a trashed entry to objectName, returning the restored
+// object's attributes or ErrObjectNotExist if it is not in the trash
+func (s *Service) resurrect(ctx context.Context, objectName string) (*storage.ObjectAttrs, error) {
+	bucket := s.client.Bucket(s.bucketName)
+	trashed := bucket.Object(trashName(objectName))
+
+	attrsCtx, span := s.startSpan(ctx, "attrs", trashed.ObjectName())
+	attrs, err := trashed.Attrs(attrsCtx)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never overwrite an entry written since the miss
+	src := trashed.If(storage.Conditions{GenerationMatch: attrs.Generation})
+	copier := bucket.Object(objectName).If(storage.Conditions{DoesNotExist: true}).CopierFrom(src)
+	copier.ContentType = attrs.ContentType
+	copier.Metadata = restoredMetadata(attrs.Metadata)
+
+	copyCtx, span := s.startSpan(ctx, "copy", objectName)
+	restored, err := copier.Run(copyCtx)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore trashed object: %w", err)
+	}
+	s.pendingUsage.Add(restored.Size)
+	deleteCtx, span := s.startSpan(ctx, "delete", trashed.ObjectName())
+	err = src.Delete(deleteCtx)
+	endSpan(span, err)
+	if err != nil && err != storage.ErrObjectNotExist {
+		s.logger.Warn("Failed to remove restored object from trash", zap.String("object", attrs.Name), zap.Error(err))
+	}
+
+	s.logger.Info("Resurrected trashed cache entry",
+		zap.String("object", objectName),
+		zap.String("run", attrs.Metadata[trashRunKey]),
+	)
+
+	return restored, nil
+}
+
+// trashName is the trash object holding the entry stored at objectName
+func trashName(objectName string) string {
+	return trashPrefix + strings.TrimPrefix(objectName, objectPrefix)
+}
+
+// trashedAt reads when an object was trashed, falling back to its update time
+func trashedAt(attrs *storage.ObjectAttrs) time.Time {
+	if t, err := time.Parse(time.RFC3339, attrs.Metadata[trashedAtKey]); err == nil {
+		return t
+	}
+	return attrs.Updated
+}
+
+// restoredMetadata drops the trash bookkeeping and marks the entry as just
+// used, so the next pruning run does not select it again straight away
+func restoredMetadata(metadata map[string]string) map[string]string {
+	restored := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if k != trashedAtKey && k != trashRunKey {
+			restored[k] = v
+		}
+	}
+	restored["last_accessed"] = time.Now().Format(time.RFC3339)
+	return restored
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// usageObject is the control object holding the running size of the cache
+const usageObject = "usage.json"
+
+// maxUsageAttempts bounds retries when replicas flush at the same time
+const maxUsageAttempts = 5
+
+// Usage is the running size of the cache shared by the replicas. Each replica
+// adds the bytes it wrote and removed since its last flush; a full listing
+// replaces the total, correcting drift from overwrites and lost flushes.
+type Usage struct {
+	Bytes        int64     `json:"bytes"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	ReconciledAt time.Time `json:"reconciled_at"` // Last full listing
+}
+
+// ReadUsage returns the shared usage, or ErrObjectNotExist if no full listing
+// has set it yet
+func (s *Service) ReadUsage(ctx context.Context) (*Usage, int64, error) {
+	data, generation, err := s.ReadControlObject(ctx, usageObject)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var usage Usage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse usage: %w", err)
+	}
+	return &usage, generation, nil
+}
+
+// FlushUsage adds the size changes made by this replica since the last flush
+// to the shared usage and returns it. Changes are kept until the usage has
+// been set by a full listing.
+func (s *Service) FlushUsage(ctx context.Context) (*Usage, error) {
+	for attempt := 0; attempt < maxUsageAttempts; attempt++ {
+		usage, generation, err := s.ReadUsage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		delta := s.pendingUsage.Load()
+		if delta == 0 {
+			return usage, nil
+		}
+		usage.Bytes += delta
+		usage.UpdatedAt = time.Now()
+
+		_, err = s.writeUsage(ctx, usage, generation)
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.pendingUsage.Add(-delta)
+		return usage, nil
+	}
+	return nil, fmt.Errorf("failed to flush usage: %w", ErrConflict)
+}
+
+// ResetUsage sets the shared usage to the size found by a full listing.
+// Changes not yet flushed by this replica are dropped, as the listing saw
+// them; other replicas' pending changes are still added on their next flush.
+func (s *Service) ResetUsage(ctx context.Context, bytes int64) error {
+	for attempt := 0; attempt < maxUsageAttempts; attempt++ {
+		_, generation, err := s.ReadUsage(ctx)
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+
+		now := time.Now()
+		_, err = s.writeUsage(ctx, &Usage{Bytes: bytes, UpdatedAt: now, ReconciledAt: now}, generation)
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.pendingUsage.Store(0)
+		return nil
+	}
+	return fmt.Errorf("failed to reset usage: %w", ErrConflict)
+}
+
+func (s *Service) writeUsage(ctx context.Context, usage *Usage, generation int64) (int64, error) {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return 0, err
+	}
+	return s.WriteControlObject(ctx, usageObject, data, generation)
+}
+
+// SyncUsage flushes this replica's size changes every interval until ctx is
+// done. Every replica runs it; the flushes are conditional writes, so an
+// interval of tens of seconds keeps contention on the object low.
+func (s *Service) SyncUsage(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Hand over what this replica changed before it goes away
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if _, err := s.FlushUsage(flushCtx); err != nil && !IsNotFound(err) {
+				s.logger.Warn("Failed to flush cache usage", zap.Error(err))
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if _, err := s.FlushUsage(ctx); err != nil && !IsNotFound(err) {
+				s.logger.Warn("Failed to flush cache usage", zap.Error(err))
+			}
+		}
+	}
+}
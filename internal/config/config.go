@@ -9,11 +9,26 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Server   ServerConfig   `envconfig:"SERVER"`
-	Storage  StorageConfig  `envconfig:"STORAGE"`
-	Pruning  PruningConfig  `envconfig:"PRUNING"`
-	Metrics  MetricsConfig  `envconfig:"METRICS"`
-	Security SecurityConfig `envconfig:"SECURITY"`
+	Server      ServerConfig      `envconfig:"SERVER"`
+	Storage     StorageConfig     `envconfig:"STORAGE"`
+	Pruning     PruningConfig     `envconfig:"PRUNING"`
+	Metrics     MetricsConfig     `envconfig:"METRICS"`
+	Admin       AdminConfig       `envconfig:"ADMIN"`
+	Security    SecurityConfig    `envconfig:"SECURITY"`
+	Bandwidth   BandwidthConfig   `envconfig:"BANDWIDTH"`
+	Audit       AuditConfig       `envconfig:"AUDIT"`
+	Threat      ThreatConfig      `envconfig:"THREAT"`
+	Validation  ValidationConfig  `envconfig:"VALIDATION"`
+	Trust       TrustConfig       `envconfig:"TRUST"`
+	Provenance  ProvenanceConfig  `envconfig:"PROVENANCE"`
+	Determinism DeterminismConfig `envconfig:"DETERMINISM"`
+	Secrets     SecretsConfig     `envconfig:"SECRETS"`
+	Trace       TraceConfig       `envconfig:"TRACE"`
+	Scheduler   SchedulerConfig   `envconfig:"SCHEDULER"`
+	Tracing     TracingConfig     `envconfig:"TRACING"`
+	Invocations InvocationsConfig `envconfig:"INVOCATIONS"`
+	Health      HealthConfig      `envconfig:"HEALTH"`
+	SLO         SLOConfig         `envconfig:"SLO"`
 }
 
 // ServerConfig contains gRPC server configuration
@@ -30,10 +45,27 @@ type StorageConfig struct {
 
 // PruningConfig contains cache pruning configuration
 type PruningConfig struct {
-	MaxCacheSizeGB int           `envconfig:"MAX_CACHE_SIZE_GB" default:"1000"`
-	IntervalHours  time.Duration `envconfig:"INTERVAL_HOURS" default:"24"`
-	RetentionDays  int           `envconfig:"RETENTION_DAYS" default:"30"`
-	EnablePruning  bool          `envconfig:"ENABLE_PRUNING" default:"true"`
+	MaxCacheSizeGB      int               `envconfig:"MAX_CACHE_SIZE_GB" default:"1000"`
+	IntervalHours       time.Duration     `envconfig:"INTERVAL_HOURS" default:"24"`
+	RetentionDays       int               `envconfig:"RETENTION_DAYS" default:"30"`
+	EnablePruning       bool              `envconfig:"ENABLE_PRUNING" default:"true"`
+	DryRun              bool              `envconfig:"DRY_RUN" default:"false"`
+	ReportFile          string            `envconfig:"REPORT_FILE"`
+	Strategy            string            `envconfig:"STRATEGY" default:"retention_score"`     // lru, lfu, gdsf, retention_score
+	InstanceStrategies  map[string]string `envconfig:"INSTANCE_STRATEGIES"`                    // e.g. ci:lfu,dev:lru
+	ReferenceAware      bool              `envconfig:"REFERENCE_AWARE" default:"true"`         // Keep AC entries and CAS blobs consistent
+	OrphanGrace         time.Duration     `envconfig:"ORPHAN_GRACE" default:"24h"`             // Unreferenced blobs idle longer are pruned first
+	ProtectWindow       time.Duration     `envconfig:"PROTECT_WINDOW" default:"72h"`           // Blobs of AC entries used within this window are kept
+	ReportMaxCandidates int               `envconfig:"REPORT_MAX_CANDIDATES" default:"100000"` // Candidates listed in the plan report
+	SoftDelete          bool              `envconfig:"SOFT_DELETE" default:"false"`            // Move pruned entries to the trash instead of deleting them
+	TrashRetention      time.Duration     `envconfig:"TRASH_RETENTION" default:"72h"`          // Trashed entries older than this are purged
+	ResurrectTrashed    bool              `envconfig:"RESURRECT_TRASHED" default:"false"`      // Restore trashed entries on a cache miss
+	HighWatermark       float64           `envconfig:"HIGH_WATERMARK" default:"1.0"`           // Fraction of the max size that triggers eviction
+	LowWatermark        float64           `envconfig:"LOW_WATERMARK" default:"0.8"`            // Fraction of the max size eviction frees down to
+	WatermarkInterval   time.Duration     `envconfig:"WATERMARK_INTERVAL" default:"1m"`        // How often the running size is checked, 0 for periodic pruning only
+	UsageSyncInterval   time.Duration     `envconfig:"USAGE_SYNC_INTERVAL" default:"30s"`      // How often replicas publish their size changes
+	ScanFraction        float64           `envconfig:"SCAN_FRACTION" default:"0.1"`            // Share of the cache an incremental eviction lists
+	DecisionRetention   time.Duration     `envconfig:"DECISION_RETENTION" default:"720h"`      // How long deletions stay queryable with why-missing
 }
 
 // MetricsConfig contains metrics server configuration
@@ -41,6 +73,13 @@ type MetricsConfig struct {
 	Port int `envconfig:"PORT" default:"9090"`
 }
 
+// AdminConfig contains the admin server configuration. Admin routes are
+// served on their own port, and only when a token is configured.
+type AdminConfig struct {
+	Port      int    `envconfig:"PORT" default:"9091"`
+	TokenFile string `envconfig:"TOKEN_FILE"` // File holding the bearer token admin requests must present
+}
+
 // SecurityConfig contains security-related configuration
 type SecurityConfig struct {
 	EnableTLS       bool   `envconfig:"ENABLE_TLS" default:"true"`
@@ -50,6 +89,153 @@ type SecurityConfig struct {
 	AllowedProjects string `envconfig:"ALLOWED_PROJECTS"`
 }
 
+// BandwidthConfig contains per-tenant byte-rate limits for Get and Put streams.
+// A zero rate disables the corresponding limit.
+type BandwidthConfig struct {
+	InstanceUploadBytesPerSec   int64            `envconfig:"INSTANCE_UPLOAD_BYTES_PER_SEC" default:"0"`
+	InstanceDownloadBytesPerSec int64            `envconfig:"INSTANCE_DOWNLOAD_BYTES_PER_SEC" default:"0"`
+	IdentityUploadBytesPerSec   int64            `envconfig:"IDENTITY_UPLOAD_BYTES_PER_SEC" default:"0"`
+	IdentityDownloadBytesPerSec int64            `envconfig:"IDENTITY_DOWNLOAD_BYTES_PER_SEC" default:"0"`
+	BurstBytes                  int              `envconfig:"BURST_BYTES" default:"1048576"`
+	InstanceOverrides           map[string]int64 `envconfig:"INSTANCE_OVERRIDES"` // instance:bytes_per_sec,...
+}
+
+// AuditConfig contains tamper-evident audit log configuration
+type AuditConfig struct {
+	Enabled        bool          `envconfig:"ENABLED" default:"false"`
+	Dir            string        `envconfig:"DIR" default:"/var/log/build-cache/audit"`
+	MaxSizeMB      int64         `envconfig:"MAX_SIZE_MB" default:"100"`
+	RotateInterval time.Duration `envconfig:"ROTATE_INTERVAL" default:"24h"`
+	SyncWrites     bool          `envconfig:"SYNC_WRITES" default:"true"`
+}
+
+// ThreatConfig contains abusive-client detection and quarantine configuration.
+// With the default weights, saturated request and miss counts together score
+// 1-(1-0.5)(1-0.6) = 0.8, which does not exceed the block score: busy clients
+// are never quarantined for volume alone, only once digest mismatches or auth
+// failures add to it.
+type ThreatConfig struct {
+	Enabled              bool          `envconfig:"ENABLED" default:"false"`
+	Window               time.Duration `envconfig:"WINDOW" default:"1m"`
+	RequestThreshold     int           `envconfig:"REQUEST_THRESHOLD" default:"6000"`
+	MissThreshold        int           `envconfig:"MISS_THRESHOLD" default:"3000"`
+	MismatchThreshold    int           `envconfig:"MISMATCH_THRESHOLD" default:"5"`
+	AuthFailureThreshold int           `envconfig:"AUTH_FAILURE_THRESHOLD" default:"20"`
+	RequestWeight        float64       `envconfig:"REQUEST_WEIGHT" default:"0.5"`
+	MissWeight           float64       `envconfig:"MISS_WEIGHT" default:"0.6"`
+	MismatchWeight       float64       `envconfig:"MISMATCH_WEIGHT" default:"0.9"`
+	AuthFailureWeight    float64       `envconfig:"AUTH_FAILURE_WEIGHT" default:"0.9"`
+	BlockScore           float64       `envconfig:"BLOCK_SCORE" default:"0.8"`
+	QuarantineTTL        time.Duration `envconfig:"QUARANTINE_TTL" default:"1h"`
+	QuarantineFile       string        `envconfig:"QUARANTINE_FILE" default:"/var/lib/build-cache/quarantine.json"`
+}
+
+// ValidationConfig contains request validation rules. Empty patterns fall
+// back to the defaults of security.DefaultValidationRules.
+type ValidationConfig struct {
+	Enabled               bool     `envconfig:"ENABLED" default:"true"`
+	HashPattern           string   `envconfig:"HASH_PATTERN"`
+	InstanceNamePattern   string   `envconfig:"INSTANCE_NAME_PATTERN"`
+	MaxInstanceNameLength int      `envconfig:"MAX_INSTANCE_NAME_LENGTH" default:"256"`
+	AllowEmptyInstance    bool     `envconfig:"ALLOW_EMPTY_INSTANCE" default:"true"`
+	ContentTypePattern    string   `envconfig:"CONTENT_TYPE_PATTERN"`
+	AllowedContentTypes   []string `envconfig:"ALLOWED_CONTENT_TYPES"`
+	MaxDigestsPerRequest  int      `envconfig:"MAX_DIGESTS_PER_REQUEST" default:"10000"`
+	MaxBlobSizeBytes      int64    `envconfig:"MAX_BLOB_SIZE_BYTES" default:"0"`
+}
+
+// TrustConfig controls which writers may populate the shared action cache.
+// When enforced, other writers with a verified identity only populate a
+// private per-identity overlay, and unverified ones cannot write at all.
+type TrustConfig struct {
+	Enforce         bool     `envconfig:"ENFORCE" default:"false"`
+	TrustedWriters  []string `envconfig:"TRUSTED_WRITERS"`
+	RequireVerified bool     `envconfig:"REQUIRE_VERIFIED" default:"true"`
+}
+
+// ProvenanceConfig controls signing of stored action results
+type ProvenanceConfig struct {
+	Enabled   bool   `envconfig:"ENABLED" default:"false"`
+	KeyFile   string `envconfig:"KEY_FILE" default:"/etc/build-cache/provenance.key"`
+	BuilderID string `envconfig:"BUILDER_ID" default:"distributed-build-cache"`
+}
+
+// DeterminismConfig controls detection of nondeterministic actions
+type DeterminismConfig struct {
+	Enabled     bool `envconfig:"ENABLED" default:"false"`
+	HistorySize int  `envconfig:"HISTORY_SIZE" default:"5"`
+}
+
+// SecretsConfig controls secret scanning of action logs and small blobs
+type SecretsConfig struct {
+	Enabled          bool              `envconfig:"ENABLED" default:"false"`
+	Policy           string            `envconfig:"POLICY" default:"redact"` // audit, redact, reject
+	RulePolicies     map[string]string `envconfig:"RULE_POLICIES"`           // e.g. private_key:reject
+	PatternsFile     string            `envconfig:"PATTERNS_FILE"`           // custom name=regex rules
+	MaxBlobScanBytes int64             `envconfig:"MAX_BLOB_SCAN_BYTES" default:"1048576"`
+}
+
+// TraceConfig controls recording of cache accesses for cmd/cache-sim
+type TraceConfig struct {
+	Enabled   bool   `envconfig:"ENABLED" default:"false"`
+	File      string `envconfig:"FILE" default:"/var/lib/build-cache/access-trace.jsonl"`
+	MaxSizeMB int64  `envconfig:"MAX_SIZE_MB" default:"1024"` // Recording stops at this size, 0 for no limit
+}
+
+// SchedulerConfig controls the leader-elected background jobs. Pruning runs
+// every Pruning.IntervalHours; a zero interval disables the other jobs.
+type SchedulerConfig struct {
+	Identity       string        `envconfig:"IDENTITY"` // Defaults to the hostname, i.e. the pod name
+	LeaseName      string        `envconfig:"LEASE_NAME" default:"background-jobs"`
+	LeaseTTL       time.Duration `envconfig:"LEASE_TTL" default:"30s"`
+	HistorySize    int           `envconfig:"HISTORY_SIZE" default:"20"`
+	ScrubInterval  time.Duration `envconfig:"SCRUB_INTERVAL" default:"0"`  // Verify CAS blob content against digests
+	ReportInterval time.Duration `envconfig:"REPORT_INTERVAL" default:"0"` // Write the pruning plan report
+}
+
+// TracingConfig controls distributed tracing of RPCs and storage calls,
+// exported over OTLP/gRPC to a collector
+type TracingConfig struct {
+	Enabled            bool               `envconfig:"ENABLED" default:"false"`
+	Endpoint           string             `envconfig:"ENDPOINT" default:"localhost:4317"`
+	Insecure           bool               `envconfig:"INSECURE" default:"false"`
+	SampleRatio        float64            `envconfig:"SAMPLE_RATIO" default:"0.1"`
+	MethodSampleRatios map[string]float64 `envconfig:"METHOD_SAMPLE_RATIOS"` // e.g. Get:0.01,Put:0.5
+}
+
+// InvocationsConfig controls counting of hits and misses per Bazel invocation
+type InvocationsConfig struct {
+	Enabled        bool          `envconfig:"ENABLED" default:"false"`
+	FlushInterval  time.Duration `envconfig:"FLUSH_INTERVAL" default:"30s"`
+	IdleTimeout    time.Duration `envconfig:"IDLE_TIMEOUT" default:"1h"` // Kept in memory this long after the last call
+	Retention      time.Duration `envconfig:"RETENTION" default:"168h"`
+	PurgeInterval  time.Duration `envconfig:"PURGE_INTERVAL" default:"1h"` // How often reports past the retention are deleted
+	MaxInvocations int           `envconfig:"MAX_INVOCATIONS" default:"10000"`
+}
+
+// HealthConfig controls the backend probes behind gRPC health, /healthz and /readyz
+type HealthConfig struct {
+	Interval         time.Duration `envconfig:"INTERVAL" default:"10s"`
+	Timeout          time.Duration `envconfig:"TIMEOUT" default:"5s"`
+	FailureThreshold int           `envconfig:"FAILURE_THRESHOLD" default:"3"` // Consecutive failures before NOT_SERVING
+	Canary           bool          `envconfig:"CANARY" default:"true"`         // Also write and read back a canary object
+}
+
+// SLOConfig sets the objectives tracked from the gRPC layer; an objective of
+// 0 stops tracking that SLO
+type SLOConfig struct {
+	Enabled                bool          `envconfig:"ENABLED" default:"true"`
+	GetAvailability        float64       `envconfig:"GET_AVAILABILITY" default:"0.999"`
+	GetLatency             float64       `envconfig:"GET_LATENCY" default:"0.99"`               // Share of Gets within the threshold, 0.99 for p99
+	GetLatencyThreshold    time.Duration `envconfig:"GET_LATENCY_THRESHOLD" default:"500ms"`    // Time to first byte
+	ACFreshness            float64       `envconfig:"AC_FRESHNESS" default:"0.999"`             // Share of AC hits whose outputs are stored
+	ACFreshnessSampleRatio float64       `envconfig:"AC_FRESHNESS_SAMPLE_RATIO" default:"0.01"` // AC hits whose outputs are looked up
+	EvaluateInterval       time.Duration `envconfig:"EVALUATE_INTERVAL" default:"30s"`
+	FastBurnThreshold      float64       `envconfig:"FAST_BURN_THRESHOLD" default:"14.4"`
+	WebhookURL             string        `envconfig:"WEBHOOK_URL"` // Posted a JSON alert on fast burn
+	AlertCooldown          time.Duration `envconfig:"ALERT_COOLDOWN" default:"1h"`
+}
+
 // Load reads configuration from environment variables
 func Load() (*Config, error) {
 	var cfg Config
@@ -83,6 +269,15 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid metrics port: %d", c.Metrics.Port)
 	}
 
+	if c.Admin.TokenFile != "" {
+		if c.Admin.Port <= 0 || c.Admin.Port > 65535 {
+			return fmt.Errorf("invalid admin port: %d", c.Admin.Port)
+		}
+		if c.Admin.Port == c.Metrics.Port || c.Admin.Port == c.Server.Port {
+			return fmt.Errorf("admin port %d must differ from the server and metrics ports", c.Admin.Port)
+		}
+	}
+
 	if c.Pruning.MaxCacheSizeGB <= 0 {
 		return fmt.Errorf("max cache size must be positive")
 	}
@@ -91,5 +286,120 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("retention days must be positive")
 	}
 
+	if c.Pruning.ReferenceAware && (c.Pruning.OrphanGrace < 0 || c.Pruning.ProtectWindow < 0) {
+		return fmt.Errorf("orphan grace and protect window must not be negative")
+	}
+
+	if c.Pruning.TrashRetention < 0 {
+		return fmt.Errorf("trash retention must not be negative")
+	}
+
+	if c.Pruning.LowWatermark <= 0 || c.Pruning.LowWatermark >= c.Pruning.HighWatermark {
+		return fmt.Errorf("pruning watermarks must satisfy 0 < low < high")
+	}
+
+	if c.Pruning.WatermarkInterval < 0 {
+		return fmt.Errorf("watermark interval must not be negative")
+	}
+
+	if c.Pruning.WatermarkInterval > 0 && c.Pruning.UsageSyncInterval <= 0 {
+		return fmt.Errorf("usage sync interval must be positive when watermarks are checked")
+	}
+
+	if c.Pruning.ScanFraction <= 0 || c.Pruning.ScanFraction > 1 {
+		return fmt.Errorf("pruning scan fraction must be in (0, 1]")
+	}
+
+	if c.Pruning.DecisionRetention < 0 {
+		return fmt.Errorf("decision retention must not be negative")
+	}
+
+	if c.Audit.Enabled && c.Audit.Dir == "" {
+		return fmt.Errorf("audit directory is required when audit logging is enabled")
+	}
+
+	if c.Threat.BlockScore <= 0 || c.Threat.BlockScore > 1 {
+		return fmt.Errorf("threat block score must be in (0, 1]")
+	}
+
+	if c.Trust.Enforce && len(c.Trust.TrustedWriters) == 0 {
+		return fmt.Errorf("at least one trusted writer is required when trust is enforced")
+	}
+
+	if c.Provenance.Enabled && c.Provenance.KeyFile == "" {
+		return fmt.Errorf("provenance key file is required when signing is enabled")
+	}
+
+	if c.Determinism.Enabled && c.Determinism.HistorySize < 2 {
+		return fmt.Errorf("determinism history size must be at least 2")
+	}
+
+	if c.Trace.Enabled && c.Trace.File == "" {
+		return fmt.Errorf("trace file is required when access tracing is enabled")
+	}
+
+	if c.Scheduler.LeaseTTL < 3*time.Second {
+		return fmt.Errorf("scheduler lease TTL must be at least 3s")
+	}
+
+	if c.Scheduler.HistorySize <= 0 {
+		return fmt.Errorf("scheduler history size must be positive")
+	}
+
+	if c.Tracing.Enabled && c.Tracing.Endpoint == "" {
+		return fmt.Errorf("tracing endpoint is required when tracing is enabled")
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample ratio must be in [0, 1]")
+	}
+
+	for method, ratio := range c.Tracing.MethodSampleRatios {
+		if ratio < 0 || ratio > 1 {
+			return fmt.Errorf("tracing sample ratio of %s must be in [0, 1]", method)
+		}
+	}
+
+	if c.Invocations.Enabled && (c.Invocations.FlushInterval <= 0 || c.Invocations.IdleTimeout <= 0 || c.Invocations.Retention <= 0 || c.Invocations.PurgeInterval <= 0) {
+		return fmt.Errorf("invocation flush interval, idle timeout and retention must be positive")
+	}
+
+	if c.Invocations.Enabled && c.Invocations.MaxInvocations <= 0 {
+		return fmt.Errorf("max invocations must be positive")
+	}
+
+	if c.Health.Interval <= 0 || c.Health.Timeout <= 0 {
+		return fmt.Errorf("health interval and timeout must be positive")
+	}
+
+	if c.Health.FailureThreshold <= 0 {
+		return fmt.Errorf("health failure threshold must be positive")
+	}
+
+	if c.SLO.Enabled {
+		for name, objective := range map[string]float64{
+			"get availability": c.SLO.GetAvailability,
+			"get latency":      c.SLO.GetLatency,
+			"AC freshness":     c.SLO.ACFreshness,
+		} {
+			if objective < 0 || objective >= 1 {
+				return fmt.Errorf("%s objective must be in [0, 1)", name)
+			}
+		}
+		if c.SLO.GetLatencyThreshold <= 0 || c.SLO.EvaluateInterval <= 0 {
+			return fmt.Errorf("SLO latency threshold and evaluate interval must be positive")
+		}
+		if c.SLO.ACFreshnessSampleRatio < 0 || c.SLO.ACFreshnessSampleRatio > 1 {
+			return fmt.Errorf("AC freshness sample ratio must be in [0, 1]")
+		}
+		if c.SLO.FastBurnThreshold <= 1 {
+			return fmt.Errorf("fast burn threshold must be above 1")
+		}
+	}
+
+	if c.Bandwidth.BurstBytes < 64*1024 {
+		return fmt.Errorf("bandwidth burst must be at least one 64KB stream chunk")
+	}
+
 	return nil
 }
@@ -0,0 +1,265 @@
+package determinism
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+)
+
+const (
+	historyContentType = "application/json"
+	// maxRecordSize bounds how much of a history or finding object is read
+	maxRecordSize = 4 * 1024 * 1024
+	// lockStripes serializes read-modify-write of history objects per action
+	lockStripes = 64
+)
+
+// Config configures the nondeterminism detector
+type Config struct {
+	HistorySize int // Number of ActionResult versions kept per action
+}
+
+// Version is one recorded ActionResult for an action digest
+type Version struct {
+	Writer    string            `json:"writer"`
+	Timestamp time.Time         `json:"timestamp"`
+	ExitCode  int32             `json:"exit_code"`
+	Outputs   map[string]string `json:"outputs"` // output path -> digest hash
+}
+
+// History holds the most recent versions written for an action
+type History struct {
+	ActionDigest string    `json:"action_digest"`
+	Versions     []Version `json:"versions"`
+}
+
+// Finding describes an action whose outputs were observed to differ
+type Finding struct {
+	InstanceName   string    `json:"instance_name"`
+	ActionDigest   string    `json:"action_digest"`
+	ActionSize     int64     `json:"action_size"`
+	DivergentPaths []string  `json:"divergent_paths"`
+	Writers        []string  `json:"writers"`
+	Occurrences    int       `json:"occurrences"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+}
+
+// Detector flags actions whose successive ActionResults carry different outputs.
+//
+// History and findings are stored as cache objects so every replica sees the
+// same view. Updates are serialized per action within a replica; concurrent
+// writes of the same action on different replicas may drop a version.
+type Detector struct {
+	cache   *cache.Service
+	config  Config
+	logger  *zap.Logger
+	metrics *metrics.Collector
+
+	locks [lockStripes]sync.Mutex
+}
+
+// NewDetector creates a new nondeterminism detector
+func NewDetector(cache *cache.Service, config Config, logger *zap.Logger, metrics *metrics.Collector) *Detector {
+	if config.HistorySize < 2 {
+		config.HistorySize = 2
+	}
+
+	return &Detector{
+		cache:   cache,
+		config:  config,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+// Record appends a version to the action's history and returns the finding if
+// its outputs diverge from any version still in the history
+func (d *Detector) Record(ctx context.Context, instance, actionHash string, actionSize int64, version Version) (*Finding, error) {
+	lock := &d.locks[stripe(instance+"/"+actionHash)]
+	lock.Lock()
+	defer lock.Unlock()
+
+	history := &History{ActionDigest: actionHash}
+	if err := d.load(ctx, historyKey(instance, actionHash), history); err != nil && !cache.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to load action history: %w", err)
+	}
+
+	divergent := divergentPaths(history.Versions, version)
+
+	history.Versions = append(history.Versions, version)
+	if len(history.Versions) > d.config.HistorySize {
+		history.Versions = history.Versions[len(history.Versions)-d.config.HistorySize:]
+	}
+	if err := d.store(ctx, historyKey(instance, actionHash), history); err != nil {
+		return nil, fmt.Errorf("failed to store action history: %w", err)
+	}
+
+	if len(divergent) == 0 {
+		return nil, nil
+	}
+
+	finding := &Finding{}
+	err := d.load(ctx, findingKey(instance, actionHash), finding)
+	switch {
+	case cache.IsNotFound(err):
+		finding = &Finding{
+			InstanceName: instance,
+			ActionDigest: actionHash,
+			ActionSize:   actionSize,
+			FirstSeen:    version.Timestamp,
+		}
+		d.metrics.NondeterministicWrites.WithLabelValues("new").Inc()
+	case err != nil:
+		return nil, fmt.Errorf("failed to load finding: %w", err)
+	default:
+		d.metrics.NondeterministicWrites.WithLabelValues("repeat").Inc()
+	}
+
+	finding.Occurrences++
+	finding.LastSeen = version.Timestamp
+	finding.DivergentPaths = union(finding.DivergentPaths, divergent)
+	writers := make([]string, 0, len(history.Versions))
+	for _, v := range history.Versions {
+		writers = append(writers, v.Writer)
+	}
+	finding.Writers = union(finding.Writers, writers)
+
+	if err := d.store(ctx, findingKey(instance, actionHash), finding); err != nil {
+		return nil, fmt.Errorf("failed to store finding: %w", err)
+	}
+
+	d.logger.Warn("Nondeterministic action detected",
+		zap.String("instance", instance),
+		zap.String("action", actionHash),
+		zap.Strings("divergent_paths", divergent),
+		zap.Strings("writers", finding.Writers),
+		zap.Int("occurrences", finding.Occurrences),
+	)
+
+	return finding, nil
+}
+
+// Findings returns recorded findings for an instance, most recent first
+func (d *Detector) Findings(ctx context.Context, instance string, limit int) ([]*Finding, error) {
+	entries, err := d.cache.ListKeyPrefix(ctx, findingPrefix(instance))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list findings: %w", err)
+	}
+
+	findings := make([]*Finding, 0, len(entries))
+	for _, entry := range entries {
+		finding := &Finding{}
+		if err := d.load(ctx, entry.Key, finding); err != nil {
+			if cache.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to load finding %s: %w", entry.Key, err)
+		}
+		findings = append(findings, finding)
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].LastSeen.After(findings[j].LastSeen)
+	})
+	if limit > 0 && len(findings) > limit {
+		findings = findings[:limit]
+	}
+
+	return findings, nil
+}
+
+// load reads a record with Peek, so history lookups neither write access
+// metadata nor count as cache hits
+func (d *Detector) load(ctx context.Context, key string, v interface{}) error {
+	reader, err := d.cache.Peek(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxRecordSize))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (d *Detector) store(ctx context.Context, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return d.cache.Put(ctx, key, bytes.NewReader(data), historyContentType)
+}
+
+// divergentPaths lists output paths whose digest in version differs from any
+// earlier version, including outputs that appear in only one of them
+func divergentPaths(previous []Version, version Version) []string {
+	seen := make(map[string]bool)
+	var paths []string
+
+	for _, prev := range previous {
+		for path, hash := range version.Outputs {
+			if other, ok := prev.Outputs[path]; (!ok || other != hash) && !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+		for path := range prev.Outputs {
+			if _, ok := version.Outputs[path]; !ok && !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+func union(a, b []string) []string {
+	set := make(map[string]bool, len(a)+len(b))
+	for _, s := range a {
+		set[s] = true
+	}
+	for _, s := range b {
+		set[s] = true
+	}
+
+	out := make([]string, 0, len(set))
+	for s := range set {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func stripe(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % lockStripes
+}
+
+func historyKey(instance, actionHash string) string {
+	return fmt.Sprintf("%s/ac_history/%s", instance, actionHash)
+}
+
+func findingKey(instance, actionHash string) string {
+	return findingPrefix(instance) + actionHash
+}
+
+func findingPrefix(instance string) string {
+	return fmt.Sprintf("%s/nondeterminism/", instance)
+}
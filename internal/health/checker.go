@@ -0,0 +1,259 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	grpchealth "google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+)
+
+// Probe checks one backend dependency of the server
+type Probe struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Config configures the health checker
+type Config struct {
+	Interval         time.Duration
+	Timeout          time.Duration // Per probe
+	FailureThreshold int           // Consecutive failures before a probe is unhealthy
+
+	// Services maps gRPC service names to the probes they depend on; ""
+	// stands for the server as a whole
+	Services map[string][]string
+}
+
+// ProbeStatus is the latest outcome of a probe
+type ProbeStatus struct {
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastCheck           time.Time `json:"last_check,omitempty"`
+	LastSuccess         time.Time `json:"last_success,omitempty"`
+	LatencyMS           float64   `json:"latency_ms"`
+}
+
+// Status is the health of the server, as reported by /healthz and /readyz
+type Status struct {
+	Ready    bool                    `json:"ready"`
+	Draining bool                    `json:"draining,omitempty"`
+	Probes   map[string]*ProbeStatus `json:"probes"`
+	Services map[string]string       `json:"services"` // gRPC serving status by service
+}
+
+// Checker probes the backends periodically and publishes per-service
+// serving statuses to the gRPC health server.
+//
+// A probe is healthy from its first success until FailureThreshold
+// consecutive failures, so a single slow request does not take a replica
+// out of rotation. Until every probe has passed once the server is not ready.
+type Checker struct {
+	config  Config
+	probes  []Probe
+	server  *grpchealth.Server
+	logger  *zap.Logger
+	metrics *metrics.Collector
+
+	mu       sync.Mutex
+	statuses map[string]*ProbeStatus
+	draining bool
+}
+
+// NewChecker creates a health checker publishing to server. Every service
+// starts as NOT_SERVING until its probes pass.
+func NewChecker(config Config, server *grpchealth.Server, logger *zap.Logger, metrics *metrics.Collector, probes ...Probe) *Checker {
+	c := &Checker{
+		config:   config,
+		probes:   probes,
+		server:   server,
+		logger:   logger,
+		metrics:  metrics,
+		statuses: make(map[string]*ProbeStatus, len(probes)),
+	}
+	for _, probe := range probes {
+		c.statuses[probe.Name] = &ProbeStatus{}
+		metrics.HealthProbeUp.WithLabelValues(probe.Name).Set(0)
+	}
+	for service := range config.Services {
+		server.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	}
+	return c
+}
+
+// Run probes immediately, then every Interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain reports the server as not ready from now on, so it is taken out of
+// rotation before it stops serving
+func (c *Checker) Drain() {
+	c.mu.Lock()
+	c.draining = true
+	c.mu.Unlock()
+	c.server.Shutdown()
+}
+
+// check runs the probes concurrently and publishes the resulting statuses
+func (c *Checker) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, probe := range c.probes {
+		wg.Add(1)
+		go func(probe Probe) {
+			defer wg.Done()
+			c.run(ctx, probe)
+		}(probe)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+	c.publish()
+}
+
+func (c *Checker) run(ctx context.Context, probe Probe) {
+	probeCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	start := time.Now()
+	err := probe.Check(probeCtx)
+	latency := time.Since(start)
+	cancel()
+	if ctx.Err() != nil {
+		return // Shutting down, not a backend failure
+	}
+
+	c.metrics.HealthProbeDuration.WithLabelValues(probe.Name).Observe(latency.Seconds())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := c.statuses[probe.Name]
+	status.LastCheck = start
+	status.LatencyMS = float64(latency.Microseconds()) / 1000
+
+	if err == nil {
+		if !status.Healthy {
+			c.logger.Info("Health probe passing", zap.String("probe", probe.Name))
+		}
+		status.Healthy = true
+		status.ConsecutiveFailures = 0
+		status.LastError = ""
+		status.LastSuccess = start
+		c.metrics.HealthProbeUp.WithLabelValues(probe.Name).Set(1)
+		return
+	}
+
+	c.metrics.HealthProbeFailures.WithLabelValues(probe.Name).Inc()
+	status.ConsecutiveFailures++
+	status.LastError = err.Error()
+	if status.Healthy && status.ConsecutiveFailures >= c.config.FailureThreshold {
+		status.Healthy = false
+		c.metrics.HealthProbeUp.WithLabelValues(probe.Name).Set(0)
+		c.logger.Error("Health probe failing",
+			zap.String("probe", probe.Name),
+			zap.Int("consecutive_failures", status.ConsecutiveFailures),
+			zap.Error(err),
+		)
+	} else {
+		c.logger.Warn("Health probe failed",
+			zap.String("probe", probe.Name),
+			zap.Int("consecutive_failures", status.ConsecutiveFailures),
+			zap.Error(err),
+		)
+	}
+}
+
+// publish sets each service to SERVING when all of its probes are healthy
+func (c *Checker) publish() {
+	status := c.Status()
+	if status.Draining {
+		return
+	}
+	for service, serving := range status.Services {
+		if serving == grpc_health_v1.HealthCheckResponse_SERVING.String() {
+			c.server.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_SERVING)
+		} else {
+			c.server.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+		}
+	}
+}
+
+// Status returns a snapshot of the probe and service statuses
+func (c *Checker) Status() *Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := &Status{
+		Ready:    !c.draining,
+		Draining: c.draining,
+		Probes:   make(map[string]*ProbeStatus, len(c.statuses)),
+		Services: make(map[string]string, len(c.config.Services)),
+	}
+	for name, probe := range c.statuses {
+		copied := *probe
+		status.Probes[name] = &copied
+		if !probe.Healthy {
+			status.Ready = false
+		}
+	}
+	for service, probes := range c.config.Services {
+		serving := !c.draining
+		for _, name := range probes {
+			if probe, ok := c.statuses[name]; !ok || !probe.Healthy {
+				serving = false
+			}
+		}
+		if serving {
+			status.Services[service] = grpc_health_v1.HealthCheckResponse_SERVING.String()
+		} else {
+			status.Services[service] = grpc_health_v1.HealthCheckResponse_NOT_SERVING.String()
+		}
+	}
+	return status
+}
+
+// Liveness serves /healthz. It succeeds while the process can answer:
+// restarting it does not fix an unreachable bucket, so backend failures
+// affect readiness alone. The probe details are included either way.
+func (c *Checker) Liveness() http.Handler {
+	return c.handler(func(*Status) bool { return true })
+}
+
+// Readiness serves /readyz, failing while any probe is unhealthy
+func (c *Checker) Readiness() http.Handler {
+	return c.handler(func(s *Status) bool { return s.Ready })
+}
+
+func (c *Checker) handler(ok func(*Status) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		status := c.Status()
+		w.Header().Set("Content-Type", "application/json")
+		if !ok(status) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
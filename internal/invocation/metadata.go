@@ -0,0 +1,107 @@
+package invocation
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MetadataHeader carries the serialized RequestMetadata Bazel attaches to
+// every remote cache call. gRPC decodes the base64 of -bin headers.
+const MetadataHeader = "build.bazel.remote.execution.v2.requestmetadata-bin"
+
+// Metadata is the REAPI RequestMetadata of a call: which build, action and
+// target it was made for
+type Metadata struct {
+	ToolName                string
+	ToolVersion             string
+	ActionID                string
+	InvocationID            string // tool_invocation_id, one per bazel command
+	CorrelatedInvocationsID string // Groups the invocations of one CI run
+	Mnemonic                string // e.g. GoCompile, CppLink
+	TargetID                string // e.g. //pkg/foo:bar
+	ConfigurationID         string
+}
+
+// FromContext parses the RequestMetadata of an incoming call, if it was sent
+func FromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return Metadata{}, false
+	}
+	values := md.Get(MetadataHeader)
+	if len(values) == 0 {
+		return Metadata{}, false
+	}
+	m, err := Parse([]byte(values[0]))
+	if err != nil {
+		return Metadata{}, false
+	}
+	return m, true
+}
+
+// Parse decodes a serialized build.bazel.remote.execution.v2.RequestMetadata
+func Parse(data []byte) (Metadata, error) {
+	var m Metadata
+	err := parseFields(data, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1: // tool_details
+			return parseFields(value, func(num protowire.Number, value []byte) error {
+				switch num {
+				case 1:
+					m.ToolName = string(value)
+				case 2:
+					m.ToolVersion = string(value)
+				}
+				return nil
+			})
+		case 2:
+			m.ActionID = string(value)
+		case 3:
+			m.InvocationID = string(value)
+		case 4:
+			m.CorrelatedInvocationsID = string(value)
+		case 5:
+			m.Mnemonic = string(value)
+		case 6:
+			m.TargetID = string(value)
+		case 7:
+			m.ConfigurationID = string(value)
+		}
+		return nil
+	})
+	return m, err
+}
+
+// parseFields calls fn with each length-delimited field of a message,
+// skipping fields of other wire types
+func parseFields(data []byte, fn func(protowire.Number, []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("invalid request metadata: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return fmt.Errorf("invalid request metadata: %w", protowire.ParseError(n))
+			}
+			data = data[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return fmt.Errorf("invalid request metadata: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package invocation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+)
+
+const (
+	// maxBreakdown bounds the targets and mnemonics counted per invocation;
+	// the rest are counted under otherBreakdown
+	maxBreakdown   = 100000
+	otherBreakdown = "(other)"
+)
+
+// ErrNotFound is returned for invocations no replica has seen
+var ErrNotFound = errors.New("invocation not found")
+
+// Kind is the kind of cache lookup counted
+type Kind int
+
+const (
+	KindAction Kind = iota // Action cache lookups
+	KindBlob               // CAS downloads
+)
+
+// Config configures the invocation tracker
+type Config struct {
+	Replica        string        // Name of this replica in stored reports
+	FlushInterval  time.Duration // How often reports are written to the bucket
+	IdleTimeout    time.Duration // Reports are dropped from memory this long after the last call
+	Retention      time.Duration // Stored reports read as missing after this long, and are purged
+	MaxInvocations int           // Invocations tracked in memory at once; others are not counted
+}
+
+// Counts are the hits and misses of one invocation, target or mnemonic
+type Counts struct {
+	ActionHits   int64 `json:"action_hits"`
+	ActionMisses int64 `json:"action_misses"`
+	BlobHits     int64 `json:"blob_hits"`
+	BlobMisses   int64 `json:"blob_misses"`
+}
+
+func (c *Counts) add(kind Kind, hit bool) {
+	switch {
+	case kind == KindAction && hit:
+		c.ActionHits++
+	case kind == KindAction:
+		c.ActionMisses++
+	case hit:
+		c.BlobHits++
+	default:
+		c.BlobMisses++
+	}
+}
+
+func (c *Counts) merge(o *Counts) {
+	c.ActionHits += o.ActionHits
+	c.ActionMisses += o.ActionMisses
+	c.BlobHits += o.BlobHits
+	c.BlobMisses += o.BlobMisses
+}
+
+// Report is the cache behaviour of one build invocation
+type Report struct {
+	InvocationID            string             `json:"invocation_id"`
+	CorrelatedInvocationsID string             `json:"correlated_invocations_id,omitempty"`
+	ToolName                string             `json:"tool_name,omitempty"`
+	ToolVersion             string             `json:"tool_version,omitempty"`
+	FirstSeen               time.Time          `json:"first_seen"`
+	LastSeen                time.Time          `json:"last_seen"`
+	Totals                  Counts             `json:"totals"`
+	Targets                 map[string]*Counts `json:"targets"`
+	Mnemonics               map[string]*Counts `json:"mnemonics"`
+}
+
+func newReport(md Metadata, now time.Time) *Report {
+	return &Report{
+		InvocationID:            md.InvocationID,
+		CorrelatedInvocationsID: md.CorrelatedInvocationsID,
+		ToolName:                md.ToolName,
+		ToolVersion:             md.ToolVersion,
+		FirstSeen:               now,
+		LastSeen:                now,
+		Targets:                 make(map[string]*Counts),
+		Mnemonics:               make(map[string]*Counts),
+	}
+}
+
+// merge adds the counts another replica saw for the same invocation
+func (r *Report) merge(o *Report) {
+	if r.FirstSeen.IsZero() || o.FirstSeen.Before(r.FirstSeen) {
+		r.FirstSeen = o.FirstSeen
+	}
+	if o.LastSeen.After(r.LastSeen) {
+		r.LastSeen = o.LastSeen
+	}
+	r.Totals.merge(&o.Totals)
+	mergeBreakdown(r.Targets, o.Targets)
+	mergeBreakdown(r.Mnemonics, o.Mnemonics)
+}
+
+func mergeBreakdown(into, from map[string]*Counts) {
+	for name, counts := range from {
+		c, ok := into[name]
+		if !ok {
+			c = &Counts{}
+			into[name] = c
+		}
+		c.merge(counts)
+	}
+}
+
+// count adds a lookup to a target or mnemonic
+func count(breakdown map[string]*Counts, name string, kind Kind, hit bool) {
+	if name == "" {
+		return
+	}
+	c, ok := breakdown[name]
+	if !ok {
+		if len(breakdown) >= maxBreakdown {
+			name = otherBreakdown
+		}
+		if c, ok = breakdown[name]; !ok {
+			c = &Counts{}
+			breakdown[name] = c
+		}
+	}
+	c.add(kind, hit)
+}
+
+type tracked struct {
+	report *Report
+	dirty  bool // Counted since the last flush
+}
+
+// Tracker counts cache hits and misses per build invocation from the
+// RequestMetadata Bazel sends.
+//
+// Calls of one invocation are spread over the replicas, so each replica keeps
+// its own counts and periodically stores them as one object per invocation
+// and replica; a report merges the objects of every replica.
+type Tracker struct {
+	cache  *cache.Service
+	config Config
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	active  map[string]*tracked
+	dropped int64
+}
+
+// NewTracker creates a new invocation tracker
+func NewTracker(cache *cache.Service, config Config, logger *zap.Logger) *Tracker {
+	return &Tracker{
+		cache:  cache,
+		config: config,
+		logger: logger,
+		active: make(map[string]*tracked),
+	}
+}
+
+// Record counts a lookup towards the invocation, target and mnemonic of the
+// call in ctx. Calls without RequestMetadata are not counted.
+func (t *Tracker) Record(ctx context.Context, kind Kind, hit bool) {
+	if t == nil {
+		return
+	}
+	md, ok := FromContext(ctx)
+	if !ok || md.InvocationID == "" {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tr, ok := t.active[md.InvocationID]
+	if !ok {
+		if len(t.active) >= t.config.MaxInvocations {
+			t.dropped++
+			return
+		}
+		tr = &tracked{report: newReport(md, now)}
+		t.active[md.InvocationID] = tr
+	}
+
+	r := tr.report
+	r.LastSeen = now
+	r.Totals.add(kind, hit)
+	count(r.Targets, md.TargetID, kind, hit)
+	count(r.Mnemonics, md.Mnemonic, kind, hit)
+	tr.dirty = true
+}
+
+// Report merges the counts of an invocation from every replica
+func (t *Tracker) Report(ctx context.Context, invocationID string) (*Report, error) {
+	t.mu.Lock()
+	local, tracking := t.active[invocationID]
+	t.mu.Unlock()
+
+	merged := &Report{
+		InvocationID: invocationID,
+		Targets:      make(map[string]*Counts),
+		Mnemonics:    make(map[string]*Counts),
+	}
+	found := false
+
+	reports, err := t.cache.InvocationReports(ctx, invocationID)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-t.config.Retention)
+	for _, stored := range reports {
+		// Counts in memory are newer than the ones this replica stored
+		if tracking && stored.Replica == t.config.Replica {
+			continue
+		}
+		// Expired, but not purged yet
+		if stored.Updated.Before(cutoff) {
+			continue
+		}
+		var report Report
+		if err := json.Unmarshal(stored.Data, &report); err != nil {
+			return nil, fmt.Errorf("failed to parse invocation report of %s: %w", stored.Replica, err)
+		}
+		merged.fillDetails(&report)
+		merged.merge(&report)
+		found = true
+	}
+
+	if tracking {
+		t.mu.Lock()
+		merged.fillDetails(local.report)
+		merged.merge(local.report)
+		t.mu.Unlock()
+		found = true
+	}
+
+	if !found {
+		return nil, ErrNotFound
+	}
+	return merged, nil
+}
+
+// fillDetails copies the tool and correlation details of a partial report
+func (r *Report) fillDetails(o *Report) {
+	if r.CorrelatedInvocationsID == "" {
+		r.CorrelatedInvocationsID = o.CorrelatedInvocationsID
+	}
+	if r.ToolName == "" {
+		r.ToolName, r.ToolVersion = o.ToolName, o.ToolVersion
+	}
+}
+
+// Run stores the counts of this replica every FlushInterval until ctx is
+// done, then flushes once more
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			t.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			t.flush(ctx)
+		}
+	}
+}
+
+// flush stores the invocations counted since the last flush and forgets the
+// ones that have gone idle
+func (t *Tracker) flush(ctx context.Context) {
+	now := time.Now()
+	pending := make(map[string][]byte)
+
+	t.mu.Lock()
+	for id, tr := range t.active {
+		if tr.dirty {
+			data, err := json.Marshal(tr.report)
+			if err != nil {
+				t.logger.Error("Failed to encode invocation report", zap.String("invocation", id), zap.Error(err))
+				continue
+			}
+			pending[id] = data
+			tr.dirty = false
+		} else if now.Sub(tr.report.LastSeen) > t.config.IdleTimeout {
+			delete(t.active, id)
+		}
+	}
+	dropped := t.dropped
+	t.dropped = 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		t.logger.Warn("Invocations not tracked, too many active",
+			zap.Int64("dropped_calls", dropped),
+			zap.Int("max_invocations", t.config.MaxInvocations),
+		)
+	}
+
+	for id, data := range pending {
+		if err := t.cache.WriteInvocationReport(ctx, id, t.config.Replica, data); err != nil {
+			t.logger.Warn("Failed to store invocation report", zap.String("invocation", id), zap.Error(err))
+			t.mu.Lock()
+			if tr, ok := t.active[id]; ok {
+				tr.dirty = true
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// Purge deletes the stored reports older than the retention. It lists every
+// report, so it runs as a scheduler job on one replica.
+func (t *Tracker) Purge(ctx context.Context) error {
+	purged, err := t.cache.PurgeInvocationReports(ctx, time.Now().Add(-t.config.Retention))
+	if purged > 0 {
+		t.logger.Info("Purged invocation reports", zap.Int("purged", purged))
+	}
+	return err
+}
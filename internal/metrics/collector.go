@@ -26,6 +26,31 @@ type Collector struct {
 	RequestDuration     *prometheus.HistogramVec
 	GRPCRequestsTotal   *prometheus.CounterVec
 	GRPCRequestDuration *prometheus.HistogramVec
+
+	// Bandwidth shaping metrics
+	BandwidthBytes            *prometheus.CounterVec
+	BandwidthThrottledSeconds *prometheus.CounterVec
+
+	// Threat detection metrics
+	ThreatSignals      *prometheus.CounterVec
+	BlockedRequests    *prometheus.CounterVec
+	QuarantinedClients prometheus.Gauge
+
+	// Action cache determinism metrics
+	NondeterministicWrites *prometheus.CounterVec
+
+	// Secret scanning metrics
+	SecretFindings *prometheus.CounterVec
+
+	// Background job scheduler metrics
+	SchedulerLeader      prometheus.Gauge
+	SchedulerJobRuns     *prometheus.CounterVec
+	SchedulerJobDuration *prometheus.HistogramVec
+
+	// Backend health probe metrics
+	HealthProbeUp       *prometheus.GaugeVec
+	HealthProbeDuration *prometheus.HistogramVec
+	HealthProbeFailures *prometheus.CounterVec
 }
 
 // NewCollector creates a new metrics collector
@@ -137,6 +162,107 @@ func NewCollector() *Collector {
 			},
 			[]string{"method"},
 		),
+
+		// Bandwidth shaping metrics
+		BandwidthBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "bandwidth_bytes_total",
+				Help: "Total bytes passed through the bandwidth shaper",
+			},
+			[]string{"direction"}, // upload, download
+		),
+		BandwidthThrottledSeconds: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "bandwidth_throttled_seconds_total",
+				Help: "Total time streams spent waiting on byte-rate limits",
+			},
+			[]string{"direction", "scope"}, // scope: instance, identity
+		),
+
+		// Threat detection metrics
+		ThreatSignals: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "threat_signals_total",
+				Help: "Total number of risk signals observed per type",
+			},
+			[]string{"signal"}, // request, miss, digest_mismatch, auth_failure
+		),
+		BlockedRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "blocked_requests_total",
+				Help: "Total number of requests blocked by threat detection",
+			},
+			[]string{"reason"}, // quarantined, risk_score
+		),
+		QuarantinedClients: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "quarantined_clients",
+				Help: "Number of clients currently in quarantine",
+			},
+		),
+
+		// Action cache determinism metrics
+		NondeterministicWrites: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "nondeterministic_action_writes_total",
+				Help: "Total number of action result writes whose outputs diverged from earlier versions",
+			},
+			[]string{"finding"}, // new, repeat
+		),
+
+		// Secret scanning metrics
+		SecretFindings: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "secret_findings_total",
+				Help: "Total number of secrets found in uploaded action logs and blobs",
+			},
+			[]string{"rule", "action"}, // action: audited, redacted, rejected
+		),
+
+		// Background job scheduler metrics
+		SchedulerLeader: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "scheduler_leader",
+				Help: "Whether this replica holds the scheduler lease and runs background jobs",
+			},
+		),
+		SchedulerJobRuns: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "scheduler_job_runs_total",
+				Help: "Total number of background job runs",
+			},
+			[]string{"job", "outcome"}, // outcome: success, error, interrupted
+		),
+		SchedulerJobDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "scheduler_job_duration_seconds",
+				Help:    "Duration of background job runs",
+				Buckets: prometheus.ExponentialBuckets(1, 2, 16), // 1s to ~9h
+			},
+			[]string{"job"},
+		),
+		HealthProbeUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "health_probe_up",
+				Help: "Whether a backend health probe is passing (1) or failing (0)",
+			},
+			[]string{"probe"},
+		),
+		HealthProbeDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "health_probe_duration_seconds",
+				Help:    "Duration of backend health probes",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"probe"},
+		),
+		HealthProbeFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "health_probe_failures_total",
+				Help: "Total number of failed backend health probes",
+			},
+			[]string{"probe"},
+		),
 	}
 }
 
@@ -157,6 +283,19 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	c.RequestDuration.Describe(ch)
 	c.GRPCRequestsTotal.Describe(ch)
 	c.GRPCRequestDuration.Describe(ch)
+	c.BandwidthBytes.Describe(ch)
+	c.BandwidthThrottledSeconds.Describe(ch)
+	c.ThreatSignals.Describe(ch)
+	c.BlockedRequests.Describe(ch)
+	c.QuarantinedClients.Describe(ch)
+	c.NondeterministicWrites.Describe(ch)
+	c.SecretFindings.Describe(ch)
+	c.SchedulerLeader.Describe(ch)
+	c.SchedulerJobRuns.Describe(ch)
+	c.SchedulerJobDuration.Describe(ch)
+	c.HealthProbeUp.Describe(ch)
+	c.HealthProbeDuration.Describe(ch)
+	c.HealthProbeFailures.Describe(ch)
 }
 
 // Collect implements prometheus.Collector
@@ -176,4 +315,17 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	c.RequestDuration.Collect(ch)
 	c.GRPCRequestsTotal.Collect(ch)
 	c.GRPCRequestDuration.Collect(ch)
+	c.BandwidthBytes.Collect(ch)
+	c.BandwidthThrottledSeconds.Collect(ch)
+	c.ThreatSignals.Collect(ch)
+	c.BlockedRequests.Collect(ch)
+	c.QuarantinedClients.Collect(ch)
+	c.NondeterministicWrites.Collect(ch)
+	c.SecretFindings.Collect(ch)
+	c.SchedulerLeader.Collect(ch)
+	c.SchedulerJobRuns.Collect(ch)
+	c.SchedulerJobDuration.Collect(ch)
+	c.HealthProbeUp.Collect(ch)
+	c.HealthProbeDuration.Collect(ch)
+	c.HealthProbeFailures.Collect(ch)
 }
@@ -0,0 +1,20 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ObserveWithTrace records a latency observation with the sampled trace in
+// ctx as its exemplar, linking a slow bucket to a trace of a request in it.
+// Exemplars are only exposed in the OpenMetrics format.
+func ObserveWithTrace(ctx context.Context, observer prometheus.Observer, value float64) {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if exemplar, ok := observer.(prometheus.ExemplarObserver); ok && spanCtx.IsSampled() {
+		exemplar.ObserveWithExemplar(value, prometheus.Labels{"trace_id": spanCtx.TraceID().String()})
+		return
+	}
+	observer.Observe(value)
+}
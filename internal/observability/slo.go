@@ -0,0 +1,259 @@
+package observability
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// SLOs tracked from the gRPC layer
+const (
+	SLOGetAvailability = "get_availability" // Gets not failed by the server
+	SLOGetLatency      = "get_latency"      // Gets sending their first byte within the threshold
+	SLOACFreshness     = "ac_freshness"     // Action cache hits whose outputs are all still stored
+)
+
+// historyMinutes is how far back events are kept, the longest burn window
+const historyMinutes = 6 * 60
+
+// burnWindows are exported as slo_burn_rate. They pair up as in the SRE
+// workbook's multi-window alerts, which need the long and the short window
+// burning: 1h with 5m for fast burn, notified here, and 6h with 30m for slow
+// burn, left to alerting rules.
+var burnWindows = []struct {
+	label  string
+	window time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+}
+
+// minAlertEvents keeps a handful of failures on an idle replica from alerting
+const minAlertEvents = 100
+
+// SLOConfig configures the tracked SLOs and fast burn alerting
+type SLOConfig struct {
+	Objectives        map[string]float64 // Target good ratio by SLO, e.g. 0.999; SLOs without one are not tracked
+	LatencyThreshold  time.Duration      // Time to first byte of a good Get
+	EvaluateInterval  time.Duration
+	FastBurnThreshold float64 // Burn rate that alerts, 14.4 spends 2% of a 30 day budget in an hour
+	WebhookURL        string  // Notified on fast burn, no notifications if empty
+	AlertCooldown     time.Duration
+	Replica           string // Reported in notifications
+}
+
+// bucket counts the events of one minute
+type bucket struct {
+	minute    int64
+	good, bad int64
+}
+
+// sloWindow keeps per-minute event counts of one SLO
+type sloWindow struct {
+	objective float64
+	buckets   [historyMinutes]bucket
+	alerted   time.Time
+}
+
+func (w *sloWindow) record(now time.Time, good bool) {
+	minute := now.Unix() / 60
+	b := &w.buckets[minute%historyMinutes]
+	if b.minute != minute {
+		*b = bucket{minute: minute}
+	}
+	if good {
+		b.good++
+	} else {
+		b.bad++
+	}
+}
+
+// counts sums the events of the last window
+func (w *sloWindow) counts(now time.Time, window time.Duration) (good, bad int64) {
+	minute := now.Unix() / 60
+	oldest := minute - int64(window/time.Minute) + 1
+	for i := range w.buckets {
+		if b := &w.buckets[i]; b.minute >= oldest && b.minute <= minute {
+			good += b.good
+			bad += b.bad
+		}
+	}
+	return good, bad
+}
+
+// burnRate is how many times faster than sustainable the error budget is
+// spent over the window; 1 spends exactly the budget over the SLO period
+func (w *sloWindow) burnRate(now time.Time, window time.Duration) (float64, int64) {
+	good, bad := w.counts(now, window)
+	total := good + bad
+	if total == 0 {
+		return 0, 0
+	}
+	return (float64(bad) / float64(total)) / (1 - w.objective), total
+}
+
+// sloTracker computes burn rates from the events recorded by the gRPC layer.
+// Each replica sees only the requests it served; the slo_events_total
+// counters aggregate across replicas for dashboards.
+type sloTracker struct {
+	config   SLOConfig
+	logger   *zap.Logger
+	notifier *webhookNotifier
+
+	mu      sync.Mutex
+	windows map[string]*sloWindow
+
+	events    *prometheus.CounterVec
+	objective *prometheus.GaugeVec
+	burnRate  *prometheus.GaugeVec
+	alerts    *prometheus.CounterVec
+}
+
+func newSLOTracker(config SLOConfig, logger *zap.Logger) *sloTracker {
+	t := &sloTracker{
+		config:  config,
+		logger:  logger,
+		windows: make(map[string]*sloWindow, len(config.Objectives)),
+		events: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "slo_events_total",
+				Help: "Total number of events counted towards an SLO",
+			},
+			[]string{"slo", "result"}, // good, bad
+		),
+		objective: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "slo_objective_ratio",
+				Help: "Target ratio of good events of an SLO",
+			},
+			[]string{"slo"},
+		),
+		burnRate: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "slo_burn_rate",
+				Help: "Error budget burn rate of an SLO over a window, as seen by this replica",
+			},
+			[]string{"slo", "window"},
+		),
+		alerts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "slo_alerts_total",
+				Help: "Total number of fast burn notifications sent",
+			},
+			[]string{"slo", "outcome"}, // sent, failed
+		),
+	}
+	if config.WebhookURL != "" {
+		t.notifier = newWebhookNotifier(config.WebhookURL)
+	}
+	for name, objective := range config.Objectives {
+		t.windows[name] = &sloWindow{objective: objective}
+		t.objective.WithLabelValues(name).Set(objective)
+	}
+	return t
+}
+
+func (t *sloTracker) record(slo string, good bool) {
+	t.mu.Lock()
+	w, ok := t.windows[slo]
+	if ok {
+		w.record(time.Now(), good)
+	}
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if good {
+		t.events.WithLabelValues(slo, "good").Inc()
+	} else {
+		t.events.WithLabelValues(slo, "bad").Inc()
+	}
+}
+
+// run evaluates the burn rates every EvaluateInterval until ctx is done
+func (t *sloTracker) run(ctx context.Context) {
+	ticker := time.NewTicker(t.config.EvaluateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.evaluate(ctx, time.Now())
+		}
+	}
+}
+
+func (t *sloTracker) evaluate(ctx context.Context, now time.Time) {
+	var fire []Alert
+
+	t.mu.Lock()
+	for name, w := range t.windows {
+		for _, bw := range burnWindows {
+			rate, _ := w.burnRate(now, bw.window)
+			t.burnRate.WithLabelValues(name, bw.label).Set(rate)
+		}
+
+		long, events := w.burnRate(now, time.Hour)
+		short, _ := w.burnRate(now, 5*time.Minute)
+		if long < t.config.FastBurnThreshold || short < t.config.FastBurnThreshold || events < minAlertEvents {
+			continue
+		}
+		if now.Sub(w.alerted) < t.config.AlertCooldown {
+			continue
+		}
+		w.alerted = now
+		fire = append(fire, Alert{
+			SLO:            name,
+			Objective:      w.objective,
+			BurnRate1h:     long,
+			BurnRate5m:     short,
+			Threshold:      t.config.FastBurnThreshold,
+			Replica:        t.config.Replica,
+			FiredAt:        now,
+			EventsLastHour: events,
+		})
+	}
+	t.mu.Unlock()
+
+	for _, alert := range fire {
+		t.logger.Error("SLO burning error budget fast",
+			zap.String("slo", alert.SLO),
+			zap.Float64("objective", alert.Objective),
+			zap.Float64("burn_rate_1h", alert.BurnRate1h),
+			zap.Float64("burn_rate_5m", alert.BurnRate5m),
+		)
+		if t.notifier == nil {
+			continue
+		}
+		if err := t.notifier.notify(ctx, alert); err != nil {
+			t.logger.Error("Failed to send SLO alert", zap.String("slo", alert.SLO), zap.Error(err))
+			t.alerts.WithLabelValues(alert.SLO, "failed").Inc()
+			continue
+		}
+		t.alerts.WithLabelValues(alert.SLO, "sent").Inc()
+	}
+}
+
+// Describe implements prometheus.Collector
+func (t *sloTracker) Describe(ch chan<- *prometheus.Desc) {
+	t.events.Describe(ch)
+	t.objective.Describe(ch)
+	t.burnRate.Describe(ch)
+	t.alerts.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (t *sloTracker) Collect(ch chan<- prometheus.Metric) {
+	t.events.Collect(ch)
+	t.objective.Collect(ch)
+	t.burnRate.Collect(ch)
+	t.alerts.Collect(ch)
+}
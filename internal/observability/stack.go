@@ -5,122 +5,123 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/jaeger"
-	"go.opentelemetry.io/otel/exporters/prometheus"
-	"go.opentelemetry.io/otel/metric"
-	"go.opentelemetry.io/otel/sdk/metric"
-	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
 )
 
-// ObservabilityStack provides comprehensive monitoring and tracing
+// Config configures the observability stack
+type Config struct {
+	ServiceName string
+	Version     string
+	Tracing     *TracingConfig // Nil disables trace export
+	SLOs        *SLOConfig     // Nil disables SLO tracking
+}
+
+// ObservabilityStack provides tracing and SLO tracking
 type ObservabilityStack struct {
-	tracer           trace.Tracer
-	meter            metric.Meter
-	logger           *zap.Logger
-	
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	logger   *zap.Logger
+	slos     *sloTracker
+
+	latencyThreshold time.Duration
+
 	// Custom metrics
-	cacheLatency     metric.Float64Histogram
-	cacheHitRate     metric.Float64Gauge
-	storageUsage     metric.Int64Gauge
-	costMetrics      metric.Float64Gauge
-	slaMetrics       metric.Float64Gauge
-	
-	// Business metrics
-	buildAcceleration metric.Float64Gauge
-	developerSatisfaction metric.Float64Gauge
-	costSavings      metric.Float64Gauge
+	cacheLatency *prometheus.HistogramVec
 }
 
-// NewObservabilityStack initializes comprehensive monitoring
-func NewObservabilityStack(serviceName string, version string) (*ObservabilityStack, error) {
-	// Initialize Jaeger tracer
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint("http://jaeger:14268/api/traces")))
-	if err != nil {
-		return nil, err
-	}
-
-	tp := trace.NewTracerProvider(
-		trace.WithBatcher(exp),
-		trace.WithResource(
-			resource.NewWithAttributes(
-				semconv.SchemaURL,
-				semconv.ServiceNameKey.String(serviceName),
-				semconv.ServiceVersionKey.String(version),
-			),
+// NewObservabilityStack installs the tracer provider exporting to the
+// configured OTLP endpoint and sets up the SLOs
+func NewObservabilityStack(ctx context.Context, cfg Config, logger *zap.Logger) (*ObservabilityStack, error) {
+	o := &ObservabilityStack{
+		tracer: otel.Tracer(cfg.ServiceName),
+		logger: logger,
+		cacheLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "cache_operation_latency_seconds",
+				Help:    "Cache operation latency in seconds",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"operation", "success"},
 		),
-	)
-	otel.SetTracerProvider(tp)
-
-	// Initialize Prometheus metrics
-	promExporter, err := prometheus.New()
-	if err != nil {
-		return nil, err
 	}
 
-	mp := metric.NewMeterProvider(metric.WithReader(promExporter))
-	otel.SetMeterProvider(mp)
-
-	tracer := otel.Tracer(serviceName)
-	meter := otel.Meter(serviceName)
-
-	// Initialize custom metrics
-	cacheLatency, _ := meter.Float64Histogram(
-		"cache_operation_latency_seconds",
-		metric.WithDescription("Cache operation latency in seconds"),
-		metric.WithUnit("s"),
-	)
-
-	cacheHitRate, _ := meter.Float64Gauge(
-		"cache_hit_rate_percentage",
-		metric.WithDescription("Cache hit rate percentage"),
-	)
+	if cfg.Tracing != nil {
+		provider, err := newTracerProvider(ctx, cfg.ServiceName, cfg.Version, *cfg.Tracing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tracer provider: %w", err)
+		}
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator())
+		o.provider = provider
+		o.tracer = provider.Tracer(cfg.ServiceName)
+
+		logger.Info("Tracing enabled",
+			zap.String("endpoint", cfg.Tracing.Endpoint),
+			zap.Float64("sample_ratio", cfg.Tracing.SampleRatio),
+			zap.Any("method_sample_ratios", cfg.Tracing.MethodSampleRatios),
+		)
+	}
 
-	storageUsage, _ := meter.Int64Gauge(
-		"storage_usage_bytes",
-		metric.WithDescription("Current storage usage in bytes"),
-	)
+	if cfg.SLOs != nil {
+		o.slos = newSLOTracker(*cfg.SLOs, logger)
+		o.latencyThreshold = cfg.SLOs.LatencyThreshold
 
-	costMetrics, _ := meter.Float64Gauge(
-		"monthly_cost_usd",
-		metric.WithDescription("Monthly cost in USD"),
-	)
+		logger.Info("SLO tracking enabled",
+			zap.Any("objectives", cfg.SLOs.Objectives),
+			zap.Duration("latency_threshold", cfg.SLOs.LatencyThreshold),
+			zap.Bool("webhook", cfg.SLOs.WebhookURL != ""),
+		)
+	}
 
-	slaMetrics, _ := meter.Float64Gauge(
-		"sla_compliance_percentage",
-		metric.WithDescription("SLA compliance percentage"),
-	)
+	return o, nil
+}
 
-	buildAcceleration, _ := meter.Float64Gauge(
-		"build_acceleration_percentage",
-		metric.WithDescription("Build time reduction percentage"),
-	)
+// Run evaluates the SLO burn rates until ctx is done
+func (o *ObservabilityStack) Run(ctx context.Context) {
+	if o.slos == nil {
+		<-ctx.Done()
+		return
+	}
+	o.slos.run(ctx)
+}
 
-	developerSatisfaction, _ := meter.Float64Gauge(
-		"developer_satisfaction_score",
-		metric.WithDescription("Developer satisfaction score (1-10)"),
-	)
+// Shutdown exports the spans still buffered and stops the exporter
+func (o *ObservabilityStack) Shutdown(ctx context.Context) error {
+	if o.provider == nil {
+		return nil
+	}
+	return o.provider.Shutdown(ctx)
+}
 
-	costSavings, _ := meter.Float64Gauge(
-		"monthly_cost_savings_usd",
-		metric.WithDescription("Monthly cost savings in USD"),
-	)
+// RecordGet counts a Get towards the availability and latency SLOs. Gets
+// failed by the server spend the availability budget; the others count
+// towards latency by their time to first byte, which unlike the full
+// duration does not grow with the blob size.
+func (o *ObservabilityStack) RecordGet(serverError bool, firstByte time.Duration) {
+	if o == nil || o.slos == nil {
+		return
+	}
+	o.slos.record(SLOGetAvailability, !serverError)
+	if !serverError {
+		o.slos.record(SLOGetLatency, firstByte <= o.latencyThreshold)
+	}
+}
 
-	return &ObservabilityStack{
-		tracer:               tracer,
-		meter:                meter,
-		cacheLatency:         cacheLatency,
-		cacheHitRate:         cacheHitRate,
-		storageUsage:         storageUsage,
-		costMetrics:          costMetrics,
-		slaMetrics:           slaMetrics,
-		buildAcceleration:    buildAcceleration,
-		developerSatisfaction: developerSatisfaction,
-		costSavings:          costSavings,
-	}, nil
+// RecordActionHit counts an action cache hit towards the freshness SLO;
+// a hit is stale when outputs it references are no longer stored
+func (o *ObservabilityStack) RecordActionHit(fresh bool) {
+	if o == nil || o.slos == nil {
+		return
+	}
+	o.slos.record(SLOACFreshness, fresh)
 }
 
 // TraceOperation wraps operations with distributed tracing
@@ -133,12 +134,11 @@ func (o *ObservabilityStack) TraceOperation(ctx context.Context, operationName s
 	duration := time.Since(start)
 
 	// Record metrics
-	o.cacheLatency.Record(ctx, duration.Seconds(),
-		metric.WithAttributes(
-			attribute.String("operation", operationName),
-			attribute.Bool("success", err == nil),
-		),
-	)
+	success := "true"
+	if err != nil {
+		success = "false"
+	}
+	metrics.ObserveWithTrace(ctx, o.cacheLatency.WithLabelValues(operationName, success), duration.Seconds())
 
 	// Add span attributes
 	span.SetAttributes(
@@ -155,22 +155,18 @@ func (o *ObservabilityStack) TraceOperation(ctx context.Context, operationName s
 	return err
 }
 
-// RecordBusinessMetrics records high-level business metrics
-func (o *ObservabilityStack) RecordBusinessMetrics(ctx context.Context, metrics BusinessMetrics) {
-	o.buildAcceleration.Record(ctx, metrics.BuildAccelerationPercent)
-	o.developerSatisfaction.Record(ctx, metrics.DeveloperSatisfactionScore)
-	o.costSavings.Record(ctx, metrics.MonthlyCostSavings)
-	o.cacheHitRate.Record(ctx, metrics.CacheHitRatePercent)
-	o.storageUsage.Record(ctx, metrics.StorageUsageBytes)
-	o.slaMetrics.Record(ctx, metrics.SLACompliancePercent)
+// Describe implements prometheus.Collector
+func (o *ObservabilityStack) Describe(ch chan<- *prometheus.Desc) {
+	o.cacheLatency.Describe(ch)
+	if o.slos != nil {
+		o.slos.Describe(ch)
+	}
 }
 
-// BusinessMetrics represents high-level business metrics
-type BusinessMetrics struct {
-	BuildAccelerationPercent    float64
-	DeveloperSatisfactionScore  float64
-	MonthlyCostSavings         float64
-	CacheHitRatePercent        float64
-	StorageUsageBytes          int64
-	SLACompliancePercent       float64
+// Collect implements prometheus.Collector
+func (o *ObservabilityStack) Collect(ch chan<- prometheus.Metric) {
+	o.cacheLatency.Collect(ch)
+	if o.slos != nil {
+		o.slos.Collect(ch)
+	}
 }
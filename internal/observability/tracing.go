@@ -0,0 +1,86 @@
+package observability
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// TracingConfig configures the OTLP export and sampling of traces
+type TracingConfig struct {
+	Endpoint string // OTLP/gRPC collector, host:port
+	Insecure bool   // Plaintext connection to the collector
+
+	// SampleRatio is the fraction of traces sampled for RPCs without a
+	// ratio of their own in MethodSampleRatios, keyed by method name, e.g. Get
+	SampleRatio        float64
+	MethodSampleRatios map[string]float64
+}
+
+func newTracerProvider(ctx context.Context, serviceName, version string, cfg TracingConfig) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(newMethodSampler(cfg.SampleRatio, cfg.MethodSampleRatios))),
+	), nil
+}
+
+// propagator reads and writes W3C trace context and baggage, so a client's
+// sampling decision carries over to the server spans
+func propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// methodSampler samples root spans by RPC method. The gRPC server spans are
+// named after the full method, e.g. buildcache.BuildCacheService/Get, so
+// frequent cheap calls can be sampled less than rare expensive ones.
+type methodSampler struct {
+	fallback sdktrace.Sampler
+	methods  map[string]sdktrace.Sampler
+}
+
+func newMethodSampler(ratio float64, methodRatios map[string]float64) sdktrace.Sampler {
+	s := &methodSampler{
+		fallback: sdktrace.TraceIDRatioBased(ratio),
+		methods:  make(map[string]sdktrace.Sampler, len(methodRatios)),
+	}
+	for method, r := range methodRatios {
+		s.methods[method] = sdktrace.TraceIDRatioBased(r)
+	}
+	return s
+}
+
+func (s *methodSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	method := p.Name[strings.LastIndex(p.Name, "/")+1:]
+	if sampler, ok := s.methods[method]; ok {
+		return sampler.ShouldSample(p)
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s *methodSampler) Description() string {
+	return "MethodSampler{" + s.fallback.Description() + "}"
+}
@@ -0,0 +1,61 @@
+package observability
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Alert is the payload posted to the webhook when an SLO burns fast
+type Alert struct {
+	SLO            string    `json:"slo"`
+	Objective      float64   `json:"objective"`
+	BurnRate1h     float64   `json:"burn_rate_1h"`
+	BurnRate5m     float64   `json:"burn_rate_5m"`
+	Threshold      float64   `json:"threshold"`
+	EventsLastHour int64     `json:"events_last_hour"`
+	Replica        string    `json:"replica"`
+	FiredAt        time.Time `json:"fired_at"`
+	Summary        string    `json:"summary"`
+}
+
+// webhookNotifier posts alerts as JSON to a URL
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *webhookNotifier) notify(ctx context.Context, alert Alert) error {
+	alert.Summary = fmt.Sprintf("SLO %s (objective %g) is burning its error budget %.1fx over 1h and %.1fx over 5m on %s",
+		alert.SLO, alert.Objective, alert.BurnRate1h, alert.BurnRate5m, alert.Replica)
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
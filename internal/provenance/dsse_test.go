@@ -0,0 +1,167 @@
+package provenance
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSigner writes a fresh key pair and loads both halves
+func newTestSigner(t *testing.T) (*Signer, ed25519.PublicKey) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "signing.key")
+	if err := GenerateKeyPair(path); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(path, "build-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPublicKey(path + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, key
+}
+
+var testActionInfo = ActionInfo{
+	InstanceName:       "ci",
+	ActionDigest:       strings.Repeat("a", 64),
+	ActionResultDigest: strings.Repeat("b", 64),
+	Writer:             "ci-runner",
+	WriterVerified:     true,
+	Outputs: []Output{
+		{Path: "bin/tool", SHA256: strings.Repeat("c", 64)},
+		{Path: "gen", SHA256: strings.Repeat("d", 64), Tree: true},
+	},
+	Timestamp: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer, key := newTestSigner(t)
+	_, otherKey := newTestSigner(t)
+
+	// resign replaces the payload and signs it properly, as a signer
+	// producing a well-formed envelope for a foreign statement would
+	resign := func(t *testing.T, e *Envelope, statement map[string]interface{}) {
+		payload, err := json.Marshal(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Payload = base64.StdEncoding.EncodeToString(payload)
+		e.Signatures[0].Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(signer.key, pae(e.PayloadType, payload)))
+	}
+
+	tests := []struct {
+		name    string
+		key     ed25519.PublicKey
+		mutate  func(t *testing.T, e *Envelope)
+		wantErr string
+	}{
+		{
+			name: "valid",
+			key:  key,
+		},
+		{
+			name:    "other key",
+			key:     otherKey,
+			wantErr: "no valid signature",
+		},
+		{
+			name: "tampered payload",
+			key:  key,
+			mutate: func(t *testing.T, e *Envelope) {
+				payload, _ := base64.StdEncoding.DecodeString(e.Payload)
+				forged := strings.Replace(string(payload), `"id":"ci-runner"`, `"id":"attacker"`, 1)
+				if forged == string(payload) {
+					t.Fatal("payload does not name the writer")
+				}
+				e.Payload = base64.StdEncoding.EncodeToString([]byte(forged))
+			},
+			wantErr: "no valid signature",
+		},
+		{
+			name: "payload type changed",
+			key:  key,
+			mutate: func(t *testing.T, e *Envelope) {
+				e.PayloadType = "application/json"
+			},
+			wantErr: "unexpected payload type",
+		},
+		{
+			name: "signature of another key id is skipped",
+			key:  key,
+			mutate: func(t *testing.T, e *Envelope) {
+				e.Signatures[0].KeyID = "0000000000000000"
+			},
+			wantErr: "no valid signature",
+		},
+		{
+			name: "valid signature after a malformed one",
+			key:  key,
+			mutate: func(t *testing.T, e *Envelope) {
+				e.Signatures = append([]Signature{{KeyID: e.Signatures[0].KeyID, Sig: "%%%"}}, e.Signatures...)
+			},
+		},
+		{
+			name: "signed statement of another type",
+			key:  key,
+			mutate: func(t *testing.T, e *Envelope) {
+				resign(t, e, map[string]interface{}{"_type": "https://in-toto.io/Statement/v0.1", "predicateType": PredicateType})
+			},
+			wantErr: "unexpected statement type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope, err := signer.Sign(testActionInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.mutate != nil {
+				tt.mutate(t, envelope)
+			}
+
+			statement, err := Verify(envelope, tt.key)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Verify() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+
+			want := NewStatement(testActionInfo, "build-cache-test")
+			if !reflect.DeepEqual(statement, want) {
+				t.Errorf("Verify() statement = %+v, want %+v", statement, want)
+			}
+		})
+	}
+}
+
+func TestStatementSubjects(t *testing.T) {
+	statement := NewStatement(testActionInfo, "build-cache-test")
+
+	want := []Subject{
+		{Name: "bin/tool", Digest: map[string]string{"sha256": strings.Repeat("c", 64)}},
+		{Name: "gen", Digest: map[string]string{"sha256": strings.Repeat("d", 64)}, MediaType: TreeMediaType},
+	}
+	if !reflect.DeepEqual(statement.Subject, want) {
+		t.Fatalf("subjects = %+v, want %+v", statement.Subject, want)
+	}
+	if statement.Subject[0].IsTree() || !statement.Subject[1].IsTree() {
+		t.Error("IsTree() does not tell files from output directories")
+	}
+	if builder := statement.Predicate.RunDetails.Builder; builder.ID != "ci-runner" || !builder.Verified {
+		t.Errorf("builder = %+v, want verified ci-runner", builder)
+	}
+}
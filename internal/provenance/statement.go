@@ -0,0 +1,138 @@
+package provenance
+
+import (
+	"time"
+)
+
+const (
+	// StatementType identifies in-toto v1 statements
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateType identifies the action result provenance predicate
+	PredicateType = "https://slsa.dev/provenance/v1"
+	// BuildType describes how the subjects were produced
+	BuildType = "https://github.com/ruslanbaba/distributed-build-cache/action-result/v1"
+	// PayloadType is the DSSE payload type of in-toto statements
+	PayloadType = "application/vnd.in-toto+json"
+	// TreeMediaType marks subjects that are output directories, digested by
+	// their Tree blob in the CAS rather than by file contents
+	TreeMediaType = "application/vnd.build-cache.tree"
+)
+
+// Statement is an in-toto v1 statement whose subjects are the outputs of an action
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Subject is an artifact covered by the statement
+type Subject struct {
+	Name      string            `json:"name"`
+	Digest    map[string]string `json:"digest"`
+	MediaType string            `json:"mediaType,omitempty"` // TreeMediaType for output directories
+}
+
+// IsTree reports whether the subject is an output directory
+func (s Subject) IsTree() bool {
+	return s.MediaType == TreeMediaType
+}
+
+// Provenance is a SLSA v1 style predicate describing who stored an action result
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition identifies the action and the stored ActionResult
+type BuildDefinition struct {
+	BuildType          string             `json:"buildType"`
+	ExternalParameters ExternalParameters `json:"externalParameters"`
+}
+
+// ExternalParameters are the inputs the signer attests to
+type ExternalParameters struct {
+	InstanceName       string `json:"instanceName"`
+	ActionDigest       string `json:"actionDigest"`
+	ActionResultDigest string `json:"actionResultDigest"`
+	ExitCode           int32  `json:"exitCode"`
+}
+
+// RunDetails records the writer and when the result was signed
+type RunDetails struct {
+	Builder  Builder  `json:"builder"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// Builder is the identity that uploaded the action result
+type Builder struct {
+	ID       string `json:"id"`
+	Verified bool   `json:"verified"`
+	Signer   string `json:"signer"`
+}
+
+// Metadata carries the signing timestamp
+type Metadata struct {
+	FinishedOn time.Time `json:"finishedOn"`
+}
+
+// Output is a file or directory produced by the action
+type Output struct {
+	Path   string
+	SHA256 string // File contents, or the Tree blob of a directory
+	Tree   bool
+}
+
+// ActionInfo describes an action result to attest
+type ActionInfo struct {
+	InstanceName       string
+	ActionDigest       string
+	ActionResultDigest string
+	ExitCode           int32
+	Writer             string
+	WriterVerified     bool
+	Outputs            []Output
+	Timestamp          time.Time
+}
+
+// NewStatement builds the statement for an action result
+func NewStatement(info ActionInfo, builderID string) *Statement {
+	subjects := make([]Subject, 0, len(info.Outputs))
+	for _, output := range info.Outputs {
+		subject := Subject{
+			Name:   output.Path,
+			Digest: map[string]string{"sha256": output.SHA256},
+		}
+		if output.Tree {
+			subject.MediaType = TreeMediaType
+		}
+		subjects = append(subjects, subject)
+	}
+
+	return &Statement{
+		Type:          StatementType,
+		Subject:       subjects,
+		PredicateType: PredicateType,
+		Predicate: Provenance{
+			BuildDefinition: BuildDefinition{
+				BuildType: BuildType,
+				ExternalParameters: ExternalParameters{
+					InstanceName:       info.InstanceName,
+					ActionDigest:       info.ActionDigest,
+					ActionResultDigest: info.ActionResultDigest,
+					ExitCode:           info.ExitCode,
+				},
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{
+					ID:       info.Writer,
+					Verified: info.WriterVerified,
+					Signer:   builderID,
+				},
+				Metadata: Metadata{
+					FinishedOn: info.Timestamp.UTC(),
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,93 @@
+package pruning
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+// prunerName identifies this service's deletions in the decision log
+const prunerName = "cache-server"
+
+// removal deletes the entries selected by one pruning run, or trashes them in
+// soft-delete mode, and records each deletion in the decision log
+type removal struct {
+	s         *Service
+	runID     string
+	trash     bool
+	decisions *eviction.DeletionLog
+}
+
+func (s *Service) newRemoval(startedAt time.Time) *removal {
+	id := runID(startedAt)
+	return &removal{
+		s:         s,
+		runID:     id,
+		trash:     s.config.SoftDelete,
+		decisions: eviction.NewDeletionLog(prunerName, id),
+	}
+}
+
+// trashRun is the trash run holding the removed entries, empty if they are deleted
+func (r *removal) trashRun() string {
+	if !r.trash {
+		return ""
+	}
+	return r.runID
+}
+
+// remove deletes a selected entry, reporting whether it is gone
+func (r *removal) remove(ctx context.Context, entry *cache.CacheEntry, reason string) bool {
+	var err error
+	if r.trash {
+		err = r.s.cache.Trash(ctx, entry.Key, r.runID)
+	} else {
+		err = r.s.cache.Delete(ctx, entry.Key)
+	}
+	if err != nil {
+		r.s.logger.Error("Failed to delete cache entry",
+			zap.String("key", entry.Key),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	full := r.decisions.Add(eviction.Deletion{
+		Key:          entry.Key,
+		Size:         entry.Size,
+		LastAccessed: entry.LastAccessed,
+		Reason:       reason,
+		DeletedAt:    time.Now(),
+		Trashed:      r.trash,
+	})
+	if full {
+		r.flush(ctx)
+	}
+	return true
+}
+
+// flush writes the buffered decisions. A failure loses them but does not
+// stop pruning.
+func (r *removal) flush(ctx context.Context) {
+	if err := r.s.cache.FlushDecisions(ctx, r.decisions); err != nil {
+		r.s.logger.Warn("Failed to record pruning decisions", zap.String("run", r.runID), zap.Error(err))
+	}
+}
+
+// purgeDecisions deletes decisions older than the decision retention
+func (s *Service) purgeDecisions(ctx context.Context) {
+	if s.config.DecisionRetention <= 0 {
+		return
+	}
+	purged, err := s.cache.PurgeDecisions(ctx, time.Now().Add(-s.config.DecisionRetention))
+	if err != nil {
+		s.logger.Error("Failed to purge pruning decisions", zap.Error(err))
+	}
+	if purged > 0 {
+		s.logger.Info("Purged pruning decision logs", zap.Int("purged_objects", purged))
+	}
+}
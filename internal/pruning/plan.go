@@ -0,0 +1,284 @@
+package pruning
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+)
+
+// Reason explains why an entry was selected for deletion, as reported by the
+// eviction strategy (e.g. retention, large_and_stale, lru)
+type Reason string
+
+// Plan describes the entries a pruning cycle deletes, or would delete in
+// dry-run mode. Only the first candidates are listed, the summaries cover all
+// of them.
+type Plan struct {
+	GeneratedAt time.Time               `json:"generated_at"`
+	DryRun      bool                    `json:"dry_run"`
+	TotalBytes  int64                   `json:"total_bytes"`
+	MaxBytes    int64                   `json:"max_bytes"`
+	TargetBytes int64                   `json:"target_bytes"`
+	Candidates  []PlanCandidate         `json:"candidates"`
+	Truncated   int                     `json:"truncated,omitempty"` // Candidates left out of the list
+	Instances   map[string]*PlanSummary `json:"instances"`
+	Reasons     map[Reason]*PlanSummary `json:"reasons"`
+	Diff        *PlanDiff               `json:"diff,omitempty"`
+	RunID       string                  `json:"run_id,omitempty"` // Trash run holding the soft-deleted entries
+
+	limit int // Maximum listed candidates, 0 for no limit
+}
+
+// PlanCandidate is an entry selected for deletion
+type PlanCandidate struct {
+	Key          string    `json:"key"`
+	Instance     string    `json:"instance"`
+	Size         int64     `json:"size"`
+	LastAccessed time.Time `json:"last_accessed"`
+	Reason       Reason    `json:"reason"`
+}
+
+// PlanSummary aggregates candidates
+type PlanSummary struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// PlanDiff compares a plan with the previous one
+type PlanDiff struct {
+	PreviousGeneratedAt time.Time `json:"previous_generated_at"`
+	Added               []string  `json:"added"`   // Candidates not in the previous plan
+	Removed             []string  `json:"removed"` // Previous candidates no longer selected
+	Unchanged           int       `json:"unchanged"`
+	BytesDelta          int64     `json:"bytes_delta"`
+}
+
+// newPlan returns an empty plan listing at most limit candidates
+func newPlan(totalBytes, maxBytes, targetBytes int64, dryRun bool, limit int) *Plan {
+	return &Plan{
+		GeneratedAt: time.Now().UTC(),
+		DryRun:      dryRun,
+		TotalBytes:  totalBytes,
+		MaxBytes:    maxBytes,
+		TargetBytes: targetBytes,
+		Instances:   make(map[string]*PlanSummary),
+		Reasons:     make(map[Reason]*PlanSummary),
+		limit:       limit,
+	}
+}
+
+// add records a selected candidate
+func (p *Plan) add(c PlanCandidate) {
+	if p.limit > 0 && len(p.Candidates) >= p.limit {
+		p.Truncated++
+	} else {
+		p.Candidates = append(p.Candidates, c)
+	}
+	addToSummary(p.Instances, c.Instance, c.Size)
+	addToSummary(p.Reasons, c.Reason, c.Size)
+}
+
+func addToSummary[K comparable](m map[K]*PlanSummary, key K, size int64) {
+	summary, ok := m[key]
+	if !ok {
+		summary = &PlanSummary{}
+		m[key] = summary
+	}
+	summary.Entries++
+	summary.Bytes += size
+}
+
+// candidateFor describes a cache entry selected for deletion
+func candidateFor(entry *cache.CacheEntry, reason Reason) PlanCandidate {
+	return PlanCandidate{
+		Key:          entry.Key,
+		Instance:     instanceOf(entry.Key),
+		Size:         entry.Size,
+		LastAccessed: entry.LastAccessed,
+		Reason:       reason,
+	}
+}
+
+// instanceOf returns the instance name a cache key belongs to
+func instanceOf(key string) string {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// Bytes returns the total size of all candidates
+func (p *Plan) Bytes() int64 {
+	var total int64
+	for _, summary := range p.Reasons {
+		total += summary.Bytes
+	}
+	return total
+}
+
+// Entries returns the number of candidates, listed or not
+func (p *Plan) Entries() int {
+	return len(p.Candidates) + p.Truncated
+}
+
+// DiffAgainst records the changes relative to a previous plan
+func (p *Plan) DiffAgainst(previous *Plan) {
+	if previous == nil {
+		return
+	}
+
+	before := make(map[string]bool, len(previous.Candidates))
+	for _, c := range previous.Candidates {
+		before[c.Key] = true
+	}
+
+	diff := &PlanDiff{
+		PreviousGeneratedAt: previous.GeneratedAt,
+		BytesDelta:          p.Bytes() - previous.Bytes(),
+	}
+	for _, c := range p.Candidates {
+		if before[c.Key] {
+			diff.Unchanged++
+			delete(before, c.Key)
+			continue
+		}
+		diff.Added = append(diff.Added, c.Key)
+	}
+	for key := range before {
+		diff.Removed = append(diff.Removed, key)
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+
+	p.Diff = diff
+}
+
+// WriteJSON writes the plan as indented JSON
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// WriteText writes a human-readable report
+func (p *Plan) WriteText(w io.Writer) error {
+	mode := "deleted"
+	switch {
+	case p.DryRun:
+		mode = "would be deleted (dry run)"
+	case p.RunID != "":
+		mode = "moved to trash as run " + p.RunID
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Pruning plan generated %s\n", p.GeneratedAt.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Cache size %s, limit %s, target %s\n", formatBytes(p.TotalBytes), formatBytes(p.MaxBytes), formatBytes(p.TargetBytes))
+	fmt.Fprintf(tw, "%d entries totalling %s %s\n\n", p.Entries(), formatBytes(p.Bytes()), mode)
+
+	fmt.Fprintln(tw, "REASON\tENTRIES\tBYTES")
+	for _, reason := range sortedKeys(p.Reasons) {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", reason, p.Reasons[reason].Entries, formatBytes(p.Reasons[reason].Bytes))
+	}
+
+	fmt.Fprintln(tw, "\nINSTANCE\tENTRIES\tBYTES")
+	for _, instance := range sortedKeys(p.Instances) {
+		name := instance
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", name, p.Instances[instance].Entries, formatBytes(p.Instances[instance].Bytes))
+	}
+
+	if p.Diff != nil {
+		fmt.Fprintf(tw, "\nChanges since %s: %d added, %d removed, %d unchanged, %+d bytes\n",
+			p.Diff.PreviousGeneratedAt.Format(time.RFC3339), len(p.Diff.Added), len(p.Diff.Removed), p.Diff.Unchanged, p.Diff.BytesDelta)
+		for _, key := range p.Diff.Added {
+			fmt.Fprintf(tw, "+ %s\n", key)
+		}
+		for _, key := range p.Diff.Removed {
+			fmt.Fprintf(tw, "- %s\n", key)
+		}
+	}
+
+	fmt.Fprintln(tw, "\nKEY\tINSTANCE\tSIZE\tLAST ACCESSED\tREASON")
+	for _, c := range p.Candidates {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Key, c.Instance, formatBytes(c.Size), c.LastAccessed.Format(time.RFC3339), c.Reason)
+	}
+	if p.Truncated > 0 {
+		fmt.Fprintf(tw, "... and %d more\n", p.Truncated)
+	}
+
+	return tw.Flush()
+}
+
+// WriteFiles writes the JSON report to path and the text report next to it
+func (p *Plan) WriteFiles(path string) error {
+	if err := writeFileAtomic(path, p.WriteJSON); err != nil {
+		return err
+	}
+	return writeFileAtomic(strings.TrimSuffix(path, filepath.Ext(path))+".txt", p.WriteText)
+}
+
+// LoadPlan reads a JSON report written by WriteFiles. A missing file is not an error.
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read previous plan: %w", err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse previous plan: %w", err)
+	}
+	return &plan, nil
+}
+
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".plan-*")
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit && b > -unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit || n <= -unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
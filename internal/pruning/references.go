@@ -0,0 +1,68 @@
+package pruning
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.uber.org/zap"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+// maxReferenceRead bounds how much of an action result or tree is read when
+// collecting references
+const maxReferenceRead = 64 * 1024 * 1024
+
+// readReferences reads an action result, and the output directory trees it
+// points at, to find the CAS blobs it references
+func (s *Service) readReferences(ctx context.Context, key string) (eviction.References, error) {
+	var ref eviction.References
+
+	data, err := s.peek(ctx, key)
+	if err != nil {
+		return ref, err
+	}
+
+	files, trees, err := eviction.ActionResultDigests(data)
+	if err != nil {
+		return ref, fmt.Errorf("failed to parse action result: %w", err)
+	}
+
+	for _, hash := range files {
+		ref.Blobs = append(ref.Blobs, eviction.BlobKey(key, hash))
+	}
+	for _, hash := range trees {
+		treeKey := eviction.BlobKey(key, hash)
+		ref.Blobs = append(ref.Blobs, treeKey)
+
+		tree, err := s.peek(ctx, treeKey)
+		if err != nil {
+			if !cache.IsNotFound(err) {
+				s.logger.Warn("Failed to read output tree", zap.String("key", treeKey), zap.Error(err))
+			}
+			continue
+		}
+		children, err := eviction.TreeDigests(tree)
+		if err != nil {
+			s.logger.Warn("Failed to parse output tree", zap.String("key", treeKey), zap.Error(err))
+			continue
+		}
+		for _, child := range children {
+			ref.Blobs = append(ref.Blobs, eviction.BlobKey(key, child))
+		}
+	}
+
+	return ref, nil
+}
+
+func (s *Service) peek(ctx context.Context, key string) ([]byte, error) {
+	reader, err := s.cache.Peek(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(io.LimitReader(reader, maxReferenceRead))
+}
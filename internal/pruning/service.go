@@ -2,13 +2,16 @@ package pruning
 
 import (
 	"context"
-	"sort"
+	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
 	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
 )
 
 // Service handles intelligent cache pruning to optimize storage costs
@@ -17,26 +20,65 @@ type Service struct {
 	logger  *zap.Logger
 	metrics *metrics.Collector
 	config  Config
+
+	policy   *eviction.Policy
+	mu       sync.Mutex
+	lastPlan *Plan
+	running  sync.Mutex // Held by a pruning cycle or an incremental eviction
+	snapshot *snapshot  // From the last full walk, for incremental eviction
+	cursor   string     // Key the last incremental eviction stopped at
 }
 
 // Config contains pruning configuration
 type Config struct {
-	MaxCacheSize    int64         // Maximum cache size in bytes
-	PruningInterval time.Duration // How often to run pruning
-	RetentionDays   int           // Minimum retention period in days
+	MaxCacheSize        int64                     // Maximum cache size in bytes
+	PruningInterval     time.Duration             // How often to run pruning
+	RetentionDays       int                       // Minimum retention period in days
+	DryRun              bool                      // Report what would be deleted without deleting
+	ReportFile          string                    // Optional JSON plan report, a .txt report is written next to it
+	Eviction            *eviction.Policy          // Per-instance eviction strategies, defaults to retention_score
+	ReferenceAware      bool                      // Keep action cache entries consistent with the CAS blobs they reference
+	References          eviction.ReferenceOptions // Orphan grace period and protect window
+	MaxReportCandidates int                       // Candidates listed in the plan, 0 for all
+	SoftDelete          bool                      // Move pruned entries to the trash instead of deleting them
+	TrashRetention      time.Duration             // How long trashed entries can be restored before they are purged
+	HighWatermark       float64                   // Fraction of MaxCacheSize above which entries are evicted
+	LowWatermark        float64                   // Fraction of MaxCacheSize eviction frees down to
+	WatermarkInterval   time.Duration             // How often CheckUsage runs, 0 to disable
+	ScanFraction        float64                   // Share of the cache an incremental eviction expects to list
+	DecisionRetention   time.Duration             // How long deletions stay in the decision log, 0 to keep them
 }
 
 // NewService creates a new pruning service
 func NewService(cache *cache.Service, logger *zap.Logger, metrics *metrics.Collector, config Config) *Service {
+	policy := config.Eviction
+	if policy == nil {
+		policy = &eviction.Policy{
+			Default: eviction.RetentionScore{Retention: time.Duration(config.RetentionDays) * 24 * time.Hour},
+		}
+	}
+
+	if config.HighWatermark <= 0 {
+		config.HighWatermark = 1
+	}
+	if config.LowWatermark <= 0 {
+		config.LowWatermark = 0.8
+	}
+	if config.ScanFraction <= 0 {
+		config.ScanFraction = 0.1
+	}
+
 	return &Service{
 		cache:   cache,
 		logger:  logger,
 		metrics: metrics,
 		config:  config,
+		policy:  policy,
 	}
 }
 
-// Start begins the pruning service
+// Start runs pruning on its own ticker. Replicas sharing a bucket should
+// schedule RunPruning with internal/scheduler instead, so only one prunes.
 func (s *Service) Start(ctx context.Context) {
 	ticker := time.NewTicker(s.config.PruningInterval)
 	defer ticker.Stop()
@@ -68,6 +110,12 @@ func (s *Service) Start(ctx context.Context) {
 
 // RunPruning executes the cache pruning algorithm
 func (s *Service) RunPruning(ctx context.Context) error {
+	s.running.Lock()
+	defer s.running.Unlock()
+	return s.runPruning(ctx)
+}
+
+func (s *Service) runPruning(ctx context.Context) error {
 	start := time.Now()
 	defer func() {
 		s.metrics.PruningDuration.Observe(time.Since(start).Seconds())
@@ -86,143 +134,297 @@ func (s *Service) RunPruning(ctx context.Context) error {
 		zap.Int64("max_size_mb", s.config.MaxCacheSize/(1024*1024)),
 	)
 
-	// Check if pruning is needed
-	if totalSize <= s.config.MaxCacheSize {
-		s.logger.Info("Cache size within limits, no pruning needed")
+	plan, err := s.prune(ctx, totalSize, !s.config.DryRun)
+	if err != nil {
+		return err
+	}
+
+	if !s.config.DryRun {
+		s.purgeTrash(ctx)
+		s.purgeDecisions(ctx)
+	}
+
+	s.reconcileUsage(ctx, totalSize, plan)
+
+	if s.config.DryRun {
+		s.logger.Info("Dry run, no entries deleted",
+			zap.Int("candidates", plan.Entries()),
+			zap.Int64("candidate_size_mb", plan.Bytes()/(1024*1024)),
+		)
+		s.recordPlan(plan)
 		return nil
 	}
 
-	// Calculate target size (80% of max to provide buffer)
-	targetSize := int64(float64(s.config.MaxCacheSize) * 0.8)
-	bytesToRemove := totalSize - targetSize
+	s.metrics.PrunedEntries.Add(float64(plan.Entries()))
+	s.metrics.PrunedBytes.Add(float64(plan.Bytes()))
 
-	s.logger.Info("Pruning required",
-		zap.Int64("bytes_to_remove_mb", bytesToRemove/(1024*1024)),
-		zap.Int64("target_size_mb", targetSize/(1024*1024)),
+	s.logger.Info("Pruning completed",
+		zap.Int("deleted_count", plan.Entries()),
+		zap.Int64("deleted_size_mb", plan.Bytes()/(1024*1024)),
+		zap.String("trash_run", plan.RunID),
+		zap.Duration("duration", time.Since(start)),
 	)
 
-	// Get all cache entries
-	entries, err := s.cache.List(ctx, "")
+	s.recordPlan(plan)
+	return nil
+}
+
+// PlanPruning computes the entries the next pruning cycle would delete without deleting them
+func (s *Service) PlanPruning(ctx context.Context) (*Plan, error) {
+	totalSize, err := s.cache.GetTotalSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.prune(ctx, totalSize, false)
+}
+
+// Report writes the plan of the next pruning cycle to the report files
+// without deleting anything
+func (s *Service) Report(ctx context.Context) error {
+	plan, err := s.PlanPruning(ctx)
 	if err != nil {
 		return err
 	}
 
-	// Apply pruning strategies
-	toDelete := s.selectEntriesForDeletion(entries, bytesToRemove)
+	s.logger.Info("Pruning plan reported",
+		zap.Int("candidates", plan.Entries()),
+		zap.Int64("candidate_size_mb", plan.Bytes()/(1024*1024)),
+	)
+	s.recordPlan(plan)
+	return nil
+}
+
+// prune selects the entries to delete, deleting each one as it is selected
+// when apply is set
+func (s *Service) prune(ctx context.Context, totalSize int64, apply bool) (*Plan, error) {
+	highSize, targetSize := s.watermarks()
+	plan := newPlan(totalSize, s.config.MaxCacheSize, targetSize, !apply, s.config.MaxReportCandidates)
+	var rm *removal
+	if apply {
+		rm = s.newRemoval(plan.GeneratedAt)
+		plan.RunID = rm.trashRun()
+	}
 
-	// Delete selected entries
-	var deletedCount int
-	var deletedSize int64
-	for _, entry := range toDelete {
-		if err := s.cache.Delete(ctx, entry.Key); err != nil {
-			s.logger.Error("Failed to delete cache entry", 
-				zap.String("key", entry.Key),
-				zap.Error(err),
-			)
-			continue
-		}
-		deletedCount++
-		deletedSize += entry.Size
+	// Within limits only expired entries are removed
+	var bytesToRemove int64
+	if totalSize <= highSize {
+		s.logger.Info("Cache size within limits, removing expired entries only")
+	} else {
+		bytesToRemove = totalSize - targetSize
+
+		s.logger.Info("Pruning required",
+			zap.Int64("bytes_to_remove_mb", bytesToRemove/(1024*1024)),
+			zap.Int64("target_size_mb", targetSize/(1024*1024)),
+		)
 	}
 
-	s.metrics.PrunedEntries.Add(float64(deletedCount))
-	s.metrics.PrunedBytes.Add(float64(deletedSize))
+	err := s.selectEntries(ctx, plan, bytesToRemove, rm)
+	if rm != nil {
+		rm.flush(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	s.logger.Info("Pruning completed",
-		zap.Int("deleted_count", deletedCount),
-		zap.Int64("deleted_size_mb", deletedSize/(1024*1024)),
-		zap.Duration("duration", time.Since(start)),
+	s.logger.Info("Pruning strategy results",
+		zap.Int("candidates_for_deletion", plan.Entries()),
+		zap.Int64("estimated_space_freed_mb", plan.Bytes()/(1024*1024)),
 	)
 
-	return nil
+	return s.withDiff(plan), nil
 }
 
-// selectEntriesForDeletion implements intelligent pruning strategies
-func (s *Service) selectEntriesForDeletion(entries []*cache.CacheEntry, bytesToRemove int64) []*cache.CacheEntry {
-	now := time.Now()
-	retentionCutoff := now.AddDate(0, 0, -s.config.RetentionDays)
-
-	var candidates []*cache.CacheEntry
-	var toDelete []*cache.CacheEntry
-
-	// First pass: Remove entries older than retention period
-	for _, entry := range entries {
-		if entry.LastAccessed.Before(retentionCutoff) {
-			toDelete = append(toDelete, entry)
-			bytesToRemove -= entry.Size
-		} else {
-			candidates = append(candidates, entry)
+// withDiff compares the plan with the last recorded one, loading it from the
+// report file after a restart
+func (s *Service) withDiff(plan *Plan) *Plan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.lastPlan
+	if previous == nil && s.config.ReportFile != "" {
+		loaded, err := LoadPlan(s.config.ReportFile)
+		if err != nil {
+			s.logger.Warn("Failed to load previous pruning plan", zap.Error(err))
 		}
+		previous = loaded
 	}
+	plan.DiffAgainst(previous)
+	return plan
+}
 
-	// If we still need to remove more, apply LRU strategy
-	if bytesToRemove > 0 && len(candidates) > 0 {
-		// Sort by last accessed time (oldest first)
-		sort.Slice(candidates, func(i, j int) bool {
-			return candidates[i].LastAccessed.Before(candidates[j].LastAccessed)
-		})
+// recordPlan keeps the plan for the next diff and writes the report files
+func (s *Service) recordPlan(plan *Plan) {
+	s.mu.Lock()
+	s.lastPlan = plan
+	s.mu.Unlock()
 
-		// Add LRU entries until we reach target
-		for i, entry := range candidates {
-			if bytesToRemove <= 0 {
-				break
-			}
+	if s.config.ReportFile == "" {
+		return
+	}
+	if err := plan.WriteFiles(s.config.ReportFile); err != nil {
+		s.logger.Error("Failed to write pruning report",
+			zap.String("file", s.config.ReportFile),
+			zap.Error(err),
+		)
+	}
+}
 
-			// Apply additional heuristics
-			if s.shouldDelete(entry, now) {
-				toDelete = append(toDelete, entry)
-				bytesToRemove -= entry.Size
-				
-				// Remove from candidates to avoid double processing
-				candidates = append(candidates[:i], candidates[i+1:]...)
+// selectEntries walks the cache without holding the listing in memory,
+// removing each selected entry with rm unless it is nil. The
+// first walk builds score histograms and indexes the blobs action results
+// reference, the second selects the entries above the cutoffs derived from
+// the histograms. A third walk selects the provenance of selected action
+// results and, with reference awareness, action results left pointing at
+// selected blobs. Provenance is only removed with its action result or once
+// expired, never by the strategies. Entries whose TTL passed are selected
+// first, even without size pressure, and entries held by unexpired pins are
+// never selected. The histograms and references are
+// kept for incremental eviction when watermark checks are enabled.
+func (s *Service) selectEntries(ctx context.Context, plan *Plan, bytesToRemove int64, rm *removal) error {
+	now := time.Now()
+	pressure := bytesToRemove > 0
+	estimator := eviction.NewEstimator(now)
+	refs := eviction.NewReferenceIndex()
+
+	// Pins are honored unconditionally, so pruning stops if they cannot be read
+	pins, err := s.cache.LoadPins(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load pins: %w", err)
+	}
+	pinned := eviction.PinnedKeys(pins, now)
+
+	// Without size pressure only expired entries are selected, so the
+	// histograms and references are only needed for incremental eviction
+	if pressure || s.config.WatermarkInterval > 0 {
+		err = s.cache.WalkKeyPrefix(ctx, "", func(entry *cache.CacheEntry) error {
+			if s.config.ReferenceAware && eviction.IsActionResultKey(entry.Key) {
+				ref, err := s.readReferences(ctx, entry.Key)
+				if err != nil {
+					s.logger.Warn("Skipping action result references", zap.String("key", entry.Key), zap.Error(err))
+				} else {
+					ref.LastAccessed = entry.LastAccessed
+					refs.Add(ref, now, s.config.References)
+				}
+			}
+			if !pinned.Contains(entry.Key) && !eviction.IsProvenanceKey(entry.Key) {
+				estimator.Add(s.policy, itemFor(entry))
 			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.snapshot = &snapshot{estimator: estimator, refs: refs}
+		s.mu.Unlock()
+	}
+
+	selector := s.policy.NewSelector(s.policy.Cutoffs(estimator, bytesToRemove))
+	selected := eviction.NewKeySet()
+	take := func(entry *cache.CacheEntry, reason string) {
+		selected.Add(entry.Key)
+		if rm != nil && !rm.remove(ctx, entry, reason) {
+			return
 		}
+		plan.add(candidateFor(entry, Reason(reason)))
+	}
 
-		// If still need more space, delete more LRU entries
-		for _, entry := range candidates {
-			if bytesToRemove <= 0 {
-				break
+	err = s.cache.WalkKeyPrefix(ctx, "", func(entry *cache.CacheEntry) error {
+		if pinned.Contains(entry.Key) {
+			return nil
+		}
+		item := itemFor(entry)
+		if item.Expired(now) {
+			take(entry, eviction.ReasonExpired)
+			return nil
+		}
+		if !pressure || eviction.IsProvenanceKey(entry.Key) {
+			return nil
+		}
+		if s.config.ReferenceAware {
+			protected, orphan := refs.Check(item, now, s.config.References)
+			switch {
+			case protected:
+				return nil
+			case orphan:
+				take(entry, "orphan")
+				return nil
 			}
-			toDelete = append(toDelete, entry)
-			bytesToRemove -= entry.Size
 		}
+		if d, ok := selector.Decide(item); ok {
+			take(entry, d.Reason)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	s.logger.Info("Pruning strategy results",
-		zap.Int("total_entries", len(entries)),
-		zap.Int("candidates_for_deletion", len(toDelete)),
-		zap.Int64("estimated_space_freed_mb", func() int64 {
-			var total int64
-			for _, entry := range toDelete {
-				total += entry.Size
+	return s.cache.WalkKeyPrefix(ctx, "", func(entry *cache.CacheEntry) error {
+		acKey := entry.Key
+		switch {
+		case pinned.Contains(entry.Key):
+			return nil
+		case selected.Contains(entry.Key):
+			// Only listed again in dry-run mode
+			return nil
+		case eviction.IsProvenanceKey(entry.Key):
+			acKey = eviction.ActionResultKey(entry.Key)
+			if selected.Contains(acKey) {
+				take(entry, "dangling_reference")
+				return nil
 			}
-			return total / (1024 * 1024)
-		}()),
-	)
+			if !s.config.ReferenceAware {
+				return nil
+			}
+		case !s.config.ReferenceAware || !eviction.IsActionResultKey(entry.Key):
+			return nil
+		}
+
+		ref, err := s.readReferences(ctx, acKey)
+		if err != nil && !cache.IsNotFound(err) {
+			s.logger.Warn("Skipping action result references", zap.String("key", acKey), zap.Error(err))
+			return nil
+		}
+		if err == nil && !eviction.Dangling(ref, selected) {
+			return nil
+		}
+		take(entry, "dangling_reference")
+		return nil
+	})
+}
+
+// purgeTrash permanently deletes entries trashed longer than the trash
+// retention ago
+func (s *Service) purgeTrash(ctx context.Context) {
+	purged, purgedSize, err := s.cache.PurgeTrash(ctx, time.Now().Add(-s.config.TrashRetention))
+	if err != nil {
+		s.logger.Error("Failed to purge trash", zap.Error(err))
+	}
+	if purged > 0 {
+		s.logger.Info("Purged trashed entries",
+			zap.Int("purged_count", purged),
+			zap.Int64("purged_size_mb", purgedSize/(1024*1024)),
+		)
+	}
+}
 
-	return toDelete
+// runID names a pruning run in the trash, so what it removed can be restored
+// together
+func runID(startedAt time.Time) string {
+	return startedAt.UTC().Format("20060102T150405Z")
 }
 
-// shouldDelete applies additional heuristics for intelligent pruning
-func (s *Service) shouldDelete(entry *cache.CacheEntry, now time.Time) bool {
-	// Age-based scoring
-	age := now.Sub(entry.LastAccessed)
-	
-	// Delete if not accessed in last week
-	if age > 7*24*time.Hour {
-		return true
-	}
-	
-	// Delete large files that haven't been accessed recently
-	if entry.Size > 100*1024*1024 && age > 3*24*time.Hour { // 100MB, 3 days
-		return true
-	}
-	
-	// Keep recently accessed files
-	if age < 24*time.Hour {
-		return false
-	}
-	
-	// Default to LRU behavior
-	return true
+// itemFor describes a cache entry to the eviction strategies
+func itemFor(entry *cache.CacheEntry) eviction.Item {
+	accessCount, _ := strconv.ParseInt(entry.Metadata["access_count"], 10, 64)
+	return eviction.Item{
+		Key:          entry.Key,
+		Instance:     instanceOf(entry.Key),
+		Size:         entry.Size,
+		LastAccessed: entry.LastAccessed,
+		AccessCount:  accessCount,
+		ExpiresAt:    eviction.ExpiresAt(entry.Metadata),
+	}
 }
@@ -0,0 +1,237 @@
+package pruning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+// errFreed stops an incremental walk once enough bytes are freed
+var errFreed = errors.New("enough bytes freed")
+
+// snapshot is what the last full walk learned about the cache. Incremental
+// evictions select with it instead of listing the whole cache again; entries
+// written since then score as recently used and are kept.
+type snapshot struct {
+	estimator *eviction.Estimator
+	refs      *eviction.ReferenceIndex
+}
+
+// watermarks returns the sizes at which eviction starts and where it stops
+func (s *Service) watermarks() (high, low int64) {
+	maxSize := float64(s.config.MaxCacheSize)
+	return int64(maxSize * s.config.HighWatermark), int64(maxSize * s.config.LowWatermark)
+}
+
+// reconcileUsage replaces the running size with the one found by a full
+// pruning cycle, so drift in the Put and Delete accounting does not add up
+func (s *Service) reconcileUsage(ctx context.Context, totalSize int64, plan *Plan) {
+	if s.config.WatermarkInterval <= 0 {
+		return
+	}
+	if !plan.DryRun {
+		totalSize -= plan.Bytes()
+	}
+	if err := s.cache.ResetUsage(ctx, totalSize); err != nil {
+		s.logger.Warn("Failed to reset cache usage", zap.Error(err))
+	}
+}
+
+// CheckUsage evicts incrementally if the running size is above the high
+// watermark. Without a running size or a snapshot yet, a full pruning cycle
+// runs instead, which provides both. The scheduler leader runs it every
+// WatermarkInterval alongside the periodic full pruning.
+func (s *Service) CheckUsage(ctx context.Context) error {
+	// A full pruning cycle in progress frees space and resets the usage
+	if !s.running.TryLock() {
+		return nil
+	}
+	defer s.running.Unlock()
+
+	usage, err := s.cache.FlushUsage(ctx)
+	if cache.IsNotFound(err) {
+		s.logger.Info("Cache usage not known yet, running full pruning")
+		return s.runPruning(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	high, low := s.watermarks()
+	if usage.Bytes <= high {
+		return nil
+	}
+
+	s.mu.Lock()
+	snap := s.snapshot
+	s.mu.Unlock()
+	if snap == nil {
+		s.logger.Info("Cache usage above high watermark, running full pruning",
+			zap.Int64("usage_mb", usage.Bytes/(1024*1024)),
+		)
+		return s.runPruning(ctx)
+	}
+
+	need := usage.Bytes - low
+	s.logger.Info("Cache usage above high watermark, evicting incrementally",
+		zap.Int64("usage_mb", usage.Bytes/(1024*1024)),
+		zap.Int64("bytes_to_remove_mb", need/(1024*1024)),
+	)
+	if s.config.DryRun {
+		s.logger.Info("Dry run, no entries evicted")
+		return nil
+	}
+
+	start := time.Now()
+
+	// An eviction resumes where the last one stopped, wrapping around once
+	s.mu.Lock()
+	passes := 1
+	if s.cursor != "" {
+		passes = 2
+	}
+	s.mu.Unlock()
+
+	var freed, removed int64
+	for pass := 0; pass < passes && freed < need; pass++ {
+		bytes, entries, err := s.evict(ctx, snap, need-freed)
+		freed += bytes
+		removed += entries
+		if err != nil {
+			return err
+		}
+	}
+
+	s.metrics.PrunedEntries.Add(float64(removed))
+	s.metrics.PrunedBytes.Add(float64(freed))
+	s.logger.Info("Incremental eviction completed",
+		zap.Int64("deleted_count", removed),
+		zap.Int64("deleted_size_mb", freed/(1024*1024)),
+		zap.Duration("duration", time.Since(start)),
+	)
+	if freed < need {
+		s.logger.Warn("Incremental eviction freed less than needed, the rest waits for full pruning",
+			zap.Int64("short_mb", (need-freed)/(1024*1024)),
+		)
+	}
+
+	// Publish the deletions now rather than on the next sync
+	if _, err := s.cache.FlushUsage(ctx); err != nil {
+		s.logger.Warn("Failed to flush cache usage", zap.Error(err))
+	}
+	return nil
+}
+
+// evict walks the cache from the cursor until need bytes are freed or the
+// listing ends, in which case the cursor starts over. Candidates are spread
+// evenly over the listing, so the cutoffs select need/ScanFraction bytes of
+// the cache and about a ScanFraction of it is listed. Provenance is removed
+// together with its action result.
+func (s *Service) evict(ctx context.Context, snap *snapshot, need int64) (freed, removed int64, err error) {
+	now := time.Now()
+	pins, err := s.cache.LoadPins(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load pins: %w", err)
+	}
+	pinned := eviction.PinnedKeys(pins, now)
+
+	scope := int64(float64(need) / s.config.ScanFraction)
+	selector := s.policy.NewSelector(s.policy.Cutoffs(snap.estimator, scope))
+	rm := s.newRemoval(now)
+	defer rm.flush(ctx)
+
+	take := func(entry *cache.CacheEntry, reason string) error {
+		if !rm.remove(ctx, entry, reason) {
+			return nil
+		}
+		s.logger.Debug("Evicted cache entry", zap.String("key", entry.Key), zap.String("reason", reason))
+		freed += entry.Size
+		removed++
+		if prov := s.provenanceOf(ctx, entry.Key, pinned); prov != nil && rm.remove(ctx, prov, "dangling_reference") {
+			freed += prov.Size
+			removed++
+		}
+		if freed >= need {
+			return errFreed
+		}
+		return nil
+	}
+
+	s.mu.Lock()
+	cursor := s.cursor
+	s.mu.Unlock()
+
+	err = s.cache.WalkFrom(ctx, cursor, func(entry *cache.CacheEntry) error {
+		cursor = entry.Key
+		if pinned.Contains(entry.Key) {
+			return nil
+		}
+		if reason, ok := s.evictReason(snap, selector, itemFor(entry), now); ok {
+			return take(entry, reason)
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errFreed):
+		err = nil
+	case err == nil:
+		cursor = ""
+	}
+
+	s.mu.Lock()
+	s.cursor = cursor
+	s.mu.Unlock()
+	return freed, removed, err
+}
+
+// evictReason decides whether an incremental eviction removes an item. With
+// reference awareness, blobs an action result pointed at in the snapshot are
+// kept even once expired: only a full pruning cycle reads the action results
+// and removes them together with their blobs, so none is left dangling.
+// Unexpired provenance is never selected on its own.
+func (s *Service) evictReason(snap *snapshot, selector *eviction.Selector, item eviction.Item, now time.Time) (string, bool) {
+	if s.config.ReferenceAware {
+		if snap.refs.Referenced.Contains(item.Key) {
+			return "", false
+		}
+		if _, orphan := snap.refs.Check(item, now, s.config.References); orphan {
+			return "orphan", true
+		}
+	}
+	if item.Expired(now) {
+		return eviction.ReasonExpired, true
+	}
+	if eviction.IsProvenanceKey(item.Key) {
+		return "", false
+	}
+	if d, ok := selector.Decide(item); ok {
+		return d.Reason, true
+	}
+	return "", false
+}
+
+// provenanceOf returns the unpinned provenance stored next to an evicted
+// action result, nil if there is none
+func (s *Service) provenanceOf(ctx context.Context, key string, pinned *eviction.KeySet) *cache.CacheEntry {
+	if !eviction.IsActionResultKey(key) {
+		return nil
+	}
+	provKey := eviction.ProvenanceKey(key)
+	if pinned.Contains(provKey) {
+		return nil
+	}
+	entry, err := s.cache.Stat(ctx, provKey)
+	if err != nil {
+		if !cache.IsNotFound(err) {
+			s.logger.Warn("Failed to look up provenance", zap.String("key", provKey), zap.Error(err))
+		}
+		return nil
+	}
+	return entry
+}
@@ -0,0 +1,104 @@
+package pruning
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+// watermarkPass runs the selection of one incremental eviction over a
+// listing, with the snapshot a full walk of the same listing would leave
+func watermarkPass(t *testing.T, config Config, listing []eviction.Item, acRefs map[string][]string, need int64, now time.Time) []string {
+	t.Helper()
+
+	policy, err := eviction.NewPolicy(eviction.StrategyLRU, nil, eviction.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Eviction = policy
+	config.ScanFraction = 1
+	s := NewService(nil, zap.NewNop(), nil, config)
+
+	snap := &snapshot{estimator: eviction.NewEstimator(now), refs: eviction.NewReferenceIndex()}
+	for _, item := range listing {
+		if blobs, ok := acRefs[item.Key]; ok {
+			snap.refs.Add(eviction.References{LastAccessed: item.LastAccessed, Blobs: blobs}, now, config.References)
+		}
+		if !eviction.IsProvenanceKey(item.Key) {
+			snap.estimator.Add(policy, item)
+		}
+	}
+
+	selector := policy.NewSelector(policy.Cutoffs(snap.estimator, need))
+	var evicted []string
+	for _, item := range listing {
+		if _, ok := s.evictReason(snap, selector, item, now); ok {
+			evicted = append(evicted, item.Key)
+		}
+	}
+	return evicted
+}
+
+func TestWatermarkSelection(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(key string, idleHours int) eviction.Item {
+		return eviction.Item{
+			Key:          key,
+			Instance:     instanceOf(key),
+			Size:         100,
+			LastAccessed: now.Add(-time.Duration(idleHours) * time.Hour),
+		}
+	}
+	expired := entry("ci/expired", 1)
+	expired.ExpiresAt = now.Add(-time.Minute)
+
+	listing := []eviction.Item{
+		entry("ci/action_result/aa", 48),
+		entry("ci/bb", 72),
+		entry("ci/cc", 72),
+		entry("ci/dd", 1),
+		expired,
+		entry("ci/provenance/aa", 96),
+	}
+	acRefs := map[string][]string{"ci/action_result/aa": {"ci/bb", "ci/expired"}}
+	references := eviction.ReferenceOptions{OrphanGrace: 24 * time.Hour, ProtectWindow: time.Hour}
+
+	tests := []struct {
+		name   string
+		config Config
+		need   int64
+		want   []string
+	}{
+		{
+			name:   "referenced blobs wait for full pruning",
+			config: Config{ReferenceAware: true, References: references},
+			need:   1000,
+			want:   []string{"ci/action_result/aa", "ci/cc", "ci/dd"},
+		},
+		{
+			name:   "orphans go first",
+			config: Config{ReferenceAware: true, References: references},
+			need:   100,
+			want:   []string{"ci/cc"},
+		},
+		{
+			name:   "without reference awareness",
+			config: Config{References: references},
+			need:   1000,
+			want:   []string{"ci/action_result/aa", "ci/bb", "ci/cc", "ci/dd", "ci/expired"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := watermarkPass(t, tt.config, listing, acRefs, tt.need, now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evicted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+)
+
+// LeaseRecord is the stored state of the scheduler lease
+type LeaseRecord struct {
+	Holder     string        `json:"holder"`
+	AcquiredAt time.Time     `json:"acquired_at"`
+	RenewedAt  time.Time     `json:"renewed_at"`
+	TTL        time.Duration `json:"ttl"`
+}
+
+// lease tracks the lease as seen by this replica. Expiry is judged by how
+// long a generation has gone unrenewed on the local clock, not by the
+// holder's timestamps, so clock skew between replicas cannot cut a lease
+// short.
+type lease struct {
+	record     LeaseRecord
+	generation int64     // Of the last write when this replica holds the lease
+	observed   int64     // Last generation read
+	observedAt time.Time // When it was first read
+	renewedAt  time.Time // Last successful renewal by this replica
+}
+
+func (s *Scheduler) leaseObject() string {
+	return "leases/" + s.cfg.LeaseName + ".json"
+}
+
+// tryLease acquires or renews the lease, reporting whether this replica
+// holds it. A conflicting write by another replica is not an error.
+func (s *Scheduler) tryLease(ctx context.Context) (bool, error) {
+	now := time.Now()
+	data, generation, err := s.store.ReadControlObject(ctx, s.leaseObject())
+
+	var current LeaseRecord
+	switch {
+	case cache.IsNotFound(err):
+		generation = 0
+	case err != nil:
+		return false, err
+	default:
+		if err := json.Unmarshal(data, &current); err != nil {
+			return false, fmt.Errorf("failed to parse lease: %w", err)
+		}
+		s.mu.Lock()
+		if generation != s.lease.observed {
+			s.lease.observed, s.lease.observedAt = generation, now
+		}
+		expired := now.Sub(s.lease.observedAt) > current.TTL
+		s.lease.record = current
+		if current.Holder != s.cfg.Identity {
+			s.lease.generation = 0
+		}
+		s.mu.Unlock()
+
+		if current.Holder != s.cfg.Identity && !expired {
+			return false, nil
+		}
+	}
+
+	record := LeaseRecord{Holder: s.cfg.Identity, AcquiredAt: now, RenewedAt: now, TTL: s.cfg.LeaseTTL}
+	if current.Holder == s.cfg.Identity && generation != 0 {
+		record.AcquiredAt = current.AcquiredAt
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+
+	newGeneration, err := s.store.WriteControlObject(ctx, s.leaseObject(), encoded, generation)
+	if errors.Is(err, cache.ErrConflict) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.lease = lease{
+		record:     record,
+		generation: newGeneration,
+		observed:   newGeneration,
+		observedAt: now,
+		renewedAt:  now,
+	}
+	s.mu.Unlock()
+	return true, nil
+}
+
+// releaseLease gives up the lease so another replica takes over without
+// waiting for it to expire
+func (s *Scheduler) releaseLease(ctx context.Context) error {
+	s.mu.Lock()
+	generation := s.lease.generation
+	s.mu.Unlock()
+
+	if generation == 0 {
+		return nil
+	}
+	return s.store.DeleteControlObject(ctx, s.leaseObject(), generation)
+}
@@ -0,0 +1,384 @@
+// Package scheduler runs background jobs such as pruning on exactly one
+// replica. Replicas compete for a lease stored with conditional writes; the
+// holder runs the jobs and the others take over when it stops renewing.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+)
+
+// releaseTimeout bounds giving up the lease on shutdown
+const releaseTimeout = 5 * time.Second
+
+// Store persists the lease and job state with conditional writes.
+// cache.Service implements it with GCS generation preconditions.
+type Store interface {
+	ReadControlObject(ctx context.Context, name string) ([]byte, int64, error)
+	WriteControlObject(ctx context.Context, name string, data []byte, generation int64) (int64, error)
+	DeleteControlObject(ctx context.Context, name string, generation int64) error
+}
+
+// Config configures a Scheduler
+type Config struct {
+	Identity    string        // Unique name of this replica, e.g. the pod name
+	LeaseName   string        // Replicas sharing a lease name elect one leader
+	LeaseTTL    time.Duration // Another replica takes over this long after the last renewal
+	HistorySize int           // Runs kept per job
+}
+
+// Job is a periodic background task
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Run records one execution of a job
+type Run struct {
+	Holder      string    `json:"holder"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	Error       string    `json:"error,omitempty"`
+	Interrupted bool      `json:"interrupted,omitempty"` // Stopped by shutdown or lost leadership
+}
+
+// jobState is the stored history of a job. It survives failover, so a new
+// leader keeps the schedule instead of running every job at once.
+type jobState struct {
+	Running *Run  `json:"running,omitempty"`
+	History []Run `json:"history"` // Most recent first
+}
+
+// JobStatus describes a job to the admin API
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Interval string    `json:"interval"`
+	Running  *Run      `json:"running,omitempty"`
+	NextRun  time.Time `json:"next_run"`
+	History  []Run     `json:"history"`
+}
+
+// Status is the scheduler state reported by the admin API
+type Status struct {
+	Identity string       `json:"identity"`
+	Leader   string       `json:"leader"`
+	IsLeader bool         `json:"is_leader"`
+	Lease    LeaseRecord  `json:"lease"`
+	Jobs     []*JobStatus `json:"jobs"`
+}
+
+// Scheduler elects a leader among replicas and runs the registered jobs on it
+type Scheduler struct {
+	store   Store
+	cfg     Config
+	logger  *zap.Logger
+	metrics *metrics.Collector
+	jobs    []Job
+
+	mu     sync.Mutex
+	lease  lease
+	leader bool
+}
+
+// New creates a scheduler. Jobs must be registered before Run.
+func New(store Store, cfg Config, logger *zap.Logger, metrics *metrics.Collector) *Scheduler {
+	return &Scheduler{
+		store:   store,
+		cfg:     cfg,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+// Register adds a job
+func (s *Scheduler) Register(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// IsLeader reports whether this replica runs the jobs
+func (s *Scheduler) IsLeader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader
+}
+
+// Run competes for the lease until ctx is done, running the jobs while this
+// replica holds it. Leadership is given up when a renewal fails for longer
+// than two thirds of the lease TTL, before another replica can take over.
+// On return the jobs have stopped and the lease is released.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.logger.Info("Starting job scheduler",
+		zap.String("identity", s.cfg.Identity),
+		zap.String("lease", s.cfg.LeaseName),
+		zap.Duration("lease_ttl", s.cfg.LeaseTTL),
+		zap.Int("jobs", len(s.jobs)),
+	)
+
+	ticker := time.NewTicker(s.cfg.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	var stopJobs func()
+	stop := func() {
+		if stopJobs != nil {
+			stopJobs()
+			stopJobs = nil
+		}
+	}
+
+	for {
+		held, err := s.tryLease(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Warn("Failed to renew scheduler lease", zap.Error(err))
+			}
+			s.mu.Lock()
+			held = s.leader && time.Since(s.lease.renewedAt) < s.cfg.LeaseTTL*2/3
+			s.mu.Unlock()
+		}
+
+		switch {
+		case held && stopJobs == nil:
+			s.logger.Info("Acquired scheduler lease, starting jobs")
+			s.setLeader(true)
+			stopJobs = s.startJobs(ctx)
+		case !held && stopJobs != nil:
+			s.logger.Warn("Lost scheduler lease, stopping jobs")
+			stop()
+			s.setLeader(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			stop()
+			if s.IsLeader() {
+				releaseCtx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+				if err := s.releaseLease(releaseCtx); err != nil {
+					s.logger.Warn("Failed to release scheduler lease", zap.Error(err))
+				}
+				cancel()
+				s.setLeader(false)
+			}
+			s.logger.Info("Stopped job scheduler")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startJobs runs every job until the returned function is called, which waits
+// for them to stop
+func (s *Scheduler) startJobs(ctx context.Context) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			s.runJob(ctx, job)
+		}(job)
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func (s *Scheduler) setLeader(leader bool) {
+	s.mu.Lock()
+	s.leader = leader
+	if !leader {
+		s.lease.generation = 0
+	}
+	s.mu.Unlock()
+
+	value := 0.0
+	if leader {
+		value = 1
+	}
+	s.metrics.SchedulerLeader.Set(value)
+}
+
+// runJob runs a job on its interval, counted from the start of its last run
+// on any replica, until ctx is cancelled. A run interrupted by a failover is
+// repeated by the new leader.
+func (s *Scheduler) runJob(ctx context.Context, job Job) {
+	for {
+		state, generation, err := s.loadState(ctx, job.Name)
+		if err != nil {
+			s.logger.Error("Failed to load job state", zap.String("job", job.Name), zap.Error(err))
+			if !sleepUntil(ctx, time.Now().Add(s.cfg.LeaseTTL)) {
+				return
+			}
+			continue
+		}
+
+		if !sleepUntil(ctx, nextRun(state, job.Interval)) {
+			return
+		}
+
+		run := Run{Holder: s.cfg.Identity, Started: time.Now()}
+		state.Running = &run
+		if generation, err = s.saveState(ctx, job.Name, state, generation); err != nil {
+			// Another replica wrote the state, so it holds the lease now
+			s.logger.Warn("Failed to record job start", zap.String("job", job.Name), zap.Error(err))
+			if !sleepUntil(ctx, time.Now().Add(s.cfg.LeaseTTL)) {
+				return
+			}
+			continue
+		}
+
+		s.logger.Info("Running job", zap.String("job", job.Name))
+		err = job.Run(ctx)
+		run.Finished = time.Now()
+
+		outcome := "success"
+		switch {
+		case err != nil && ctx.Err() != nil:
+			outcome = "interrupted"
+			run.Error = err.Error()
+			run.Interrupted = true
+		case err != nil:
+			outcome = "error"
+			run.Error = err.Error()
+			s.logger.Error("Job failed", zap.String("job", job.Name), zap.Error(err))
+		}
+		s.metrics.SchedulerJobRuns.WithLabelValues(job.Name, outcome).Inc()
+		s.metrics.SchedulerJobDuration.WithLabelValues(job.Name).Observe(run.Finished.Sub(run.Started).Seconds())
+
+		state.Running = nil
+		state.History = append([]Run{run}, state.History...)
+		if len(state.History) > s.cfg.HistorySize {
+			state.History = state.History[:s.cfg.HistorySize]
+		}
+
+		// Record the run even when leadership was just lost
+		saveCtx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		_, err = s.saveState(saveCtx, job.Name, state, generation)
+		cancel()
+		if err != nil {
+			s.logger.Warn("Failed to record job run", zap.String("job", job.Name), zap.Error(err))
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func jobObject(name string) string {
+	return "jobs/" + name + ".json"
+}
+
+func (s *Scheduler) loadState(ctx context.Context, name string) (*jobState, int64, error) {
+	data, generation, err := s.store.ReadControlObject(ctx, jobObject(name))
+	if cache.IsNotFound(err) {
+		return &jobState{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var state jobState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse job state: %w", err)
+	}
+	return &state, generation, nil
+}
+
+func (s *Scheduler) saveState(ctx context.Context, name string, state *jobState, generation int64) (int64, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	}
+	return s.store.WriteControlObject(ctx, jobObject(name), data, generation)
+}
+
+// nextRun is when a job is due: immediately if it never ran or its last run
+// was interrupted, otherwise an interval after the last run started
+func nextRun(state *jobState, interval time.Duration) time.Time {
+	if state.Running != nil || len(state.History) == 0 || state.History[0].Interrupted {
+		return time.Now()
+	}
+	return state.History[0].Started.Add(interval)
+}
+
+// sleepUntil waits for t, reporting false if ctx is done first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Status returns the lease and the stored job state. Any replica can serve
+// it, since the state is read from the store.
+func (s *Scheduler) Status(ctx context.Context) (*Status, error) {
+	s.mu.Lock()
+	status := &Status{
+		Identity: s.cfg.Identity,
+		IsLeader: s.leader,
+		Lease:    s.lease.record,
+	}
+	s.mu.Unlock()
+
+	data, _, err := s.store.ReadControlObject(ctx, s.leaseObject())
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &status.Lease); err != nil {
+			return nil, fmt.Errorf("failed to parse lease: %w", err)
+		}
+	case !cache.IsNotFound(err):
+		return nil, err
+	}
+	status.Leader = status.Lease.Holder
+
+	for _, job := range s.jobs {
+		state, _, err := s.loadState(ctx, job.Name)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", job.Name, err)
+		}
+		status.Jobs = append(status.Jobs, &JobStatus{
+			Name:     job.Name,
+			Interval: job.Interval.String(),
+			Running:  state.Running,
+			NextRun:  nextRun(state, job.Interval),
+			History:  state.History,
+		})
+	}
+	return status, nil
+}
+
+// ServeHTTP reports the scheduler status on GET
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, err := s.Status(r.Context())
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			s.logger.Error("Failed to read scheduler status", zap.Error(err))
+		}
+		http.Error(w, "failed to read scheduler status", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,447 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	auditFilePrefix = "audit-"
+	auditFileSuffix = ".jsonl"
+	auditFileTime   = "20060102T150405.000000000Z"
+
+	// genesisHash is the previous hash of the very first record in a chain
+	genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+// redactedKeys lists request headers and metadata keys never written to the audit log
+var redactedKeys = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"x-api-key":           true,
+}
+
+// AuditConfig configures the audit log sink
+type AuditConfig struct {
+	Dir            string        // Directory holding the JSONL segments
+	MaxSizeBytes   int64         // Rotate when the active segment exceeds this size
+	RotateInterval time.Duration // Rotate when the active segment is older than this
+	SyncWrites     bool          // fsync after every record
+}
+
+// AuditLogger is an append-only, hash-chained JSONL audit sink.
+//
+// Every record carries the hash of the record before it, including across
+// segment rotation, so editing, reordering or removing a record breaks the
+// chain and is reported by VerifyAuditLog.
+type AuditLogger struct {
+	config AuditConfig
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	openedAt  time.Time
+	seq       uint64
+	prevHash  string
+	lastError error
+}
+
+// AuditRecord is a single line in the audit log
+type AuditRecord struct {
+	Seq       uint64          `json:"seq"`
+	Timestamp time.Time       `json:"timestamp"`
+	Kind      string          `json:"kind"` // request, security
+	Event     json.RawMessage `json:"event"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
+
+// AuditVerification summarizes a successful chain verification
+type AuditVerification struct {
+	Files    int
+	Records  uint64
+	HeadHash string
+}
+
+// NewAuditLogger opens the audit log, resuming the chain from the newest record
+func NewAuditLogger(config AuditConfig, logger *zap.Logger) *AuditLogger {
+	a := &AuditLogger{
+		config:   config,
+		logger:   logger,
+		prevHash: genesisHash,
+	}
+
+	if err := a.open(); err != nil {
+		a.lastError = err
+		logger.Error("Failed to open audit log", zap.String("dir", config.Dir), zap.Error(err))
+	}
+
+	return a
+}
+
+// LogRequest records a request audit event
+func (a *AuditLogger) LogRequest(event AuditEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	event.Headers = redactHeaders(event.Headers)
+	event.Metadata = redactHeaders(event.Metadata)
+	a.append("request", event.Timestamp, event)
+}
+
+// LogSecurityEvent records a security decision or failure
+func (a *AuditLogger) LogSecurityEvent(event SecurityEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	a.append("security", event.Timestamp, event)
+}
+
+// Err returns the last write error, if any
+func (a *AuditLogger) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastError
+}
+
+// Close flushes and closes the active segment
+func (a *AuditLogger) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+func (a *AuditLogger) append(kind string, ts time.Time, event interface{}) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		a.logger.Error("Failed to encode audit event", zap.String("kind", kind), zap.Error(err))
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.rotateIfNeeded(); err != nil {
+		a.lastError = err
+		a.logger.Error("Failed to rotate audit log", zap.Error(err))
+		return
+	}
+	if a.file == nil {
+		a.lastError = fmt.Errorf("audit log is not open")
+		return
+	}
+
+	a.lastError = a.write(kind, ts, payload)
+}
+
+// write appends a record to the active segment, with a.mu held
+func (a *AuditLogger) write(kind string, ts time.Time, payload json.RawMessage) error {
+	record := AuditRecord{
+		Seq:       a.seq + 1,
+		Timestamp: ts.UTC(),
+		Kind:      kind,
+		Event:     payload,
+		PrevHash:  a.prevHash,
+	}
+
+	hash, err := hashAuditRecord(record)
+	if err != nil {
+		a.logger.Error("Failed to hash audit record", zap.Error(err))
+		return err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		a.logger.Error("Failed to encode audit record", zap.Error(err))
+		return err
+	}
+	line = append(line, '\n')
+
+	if _, err := a.file.Write(line); err != nil {
+		a.logger.Error("Failed to write audit record", zap.Error(err))
+		return err
+	}
+	if a.config.SyncWrites {
+		if err := a.file.Sync(); err != nil {
+			a.logger.Error("Failed to sync audit log", zap.Error(err))
+			return err
+		}
+	}
+
+	a.seq = record.Seq
+	a.prevHash = record.Hash
+	a.size += int64(len(line))
+	return nil
+}
+
+// open resumes the chain from the newest record on disk and starts a fresh
+// segment. Segments left empty by a restart before their first record are
+// skipped, so the chain continues from the last segment holding one. Torn
+// records truncated on the way are recorded as the first records of the new
+// segment, so the loss shows in the chain itself.
+func (a *AuditLogger) open() error {
+	if err := os.MkdirAll(a.config.Dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	files, err := auditSegments(a.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	var truncated []SecurityEvent
+	for i := len(files) - 1; i >= 0; i-- {
+		last, dropped, err := a.lastAuditRecord(files[i])
+		if err != nil {
+			return err
+		}
+		if dropped > 0 {
+			truncated = append(truncated, SecurityEvent{
+				Type:      "audit_truncated",
+				Resource:  files[i],
+				Reason:    fmt.Sprintf("dropped %d bytes of a torn record", dropped),
+				Timestamp: time.Now(),
+				Severity:  "warning",
+			})
+		}
+		if last != nil {
+			a.seq = last.Seq
+			a.prevHash = last.Hash
+			break
+		}
+	}
+
+	if err := a.newSegment(); err != nil {
+		return err
+	}
+
+	for _, event := range truncated {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit event: %w", err)
+		}
+		if err := a.write("security", event.Timestamp, payload); err != nil {
+			return fmt.Errorf("failed to record audit truncation: %w", err)
+		}
+	}
+	return nil
+}
+
+func (a *AuditLogger) rotateIfNeeded() error {
+	if a.file == nil {
+		return a.open()
+	}
+
+	tooBig := a.config.MaxSizeBytes > 0 && a.size >= a.config.MaxSizeBytes
+	tooOld := a.config.RotateInterval > 0 && time.Since(a.openedAt) >= a.config.RotateInterval
+	if !tooBig && !tooOld {
+		return nil
+	}
+
+	if err := a.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit segment: %w", err)
+	}
+	a.file = nil
+
+	return a.newSegment()
+}
+
+func (a *AuditLogger) newSegment() error {
+	now := time.Now().UTC()
+	name := filepath.Join(a.config.Dir, auditFilePrefix+now.Format(auditFileTime)+auditFileSuffix)
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_EXCL, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create audit segment: %w", err)
+	}
+
+	a.file = f
+	a.size = 0
+	a.openedAt = now
+
+	a.logger.Info("Opened audit log segment",
+		zap.String("file", name),
+		zap.Uint64("seq", a.seq),
+	)
+
+	return nil
+}
+
+// VerifyAuditLog checks the hash chain across every segment in dir
+func VerifyAuditLog(dir string) (*AuditVerification, error) {
+	files, err := auditSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AuditVerification{HeadHash: genesisHash}
+	for _, file := range files {
+		if err := verifyAuditSegment(file, result); err != nil {
+			return result, err
+		}
+		result.Files++
+	}
+
+	return result, nil
+}
+
+func verifyAuditSegment(path string, state *AuditVerification) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("%s:%d: malformed record: %w", path, line, err)
+		}
+
+		if record.Seq != state.Records+1 {
+			return fmt.Errorf("%s:%d: sequence gap: expected %d, found %d", path, line, state.Records+1, record.Seq)
+		}
+		if record.PrevHash != state.HeadHash {
+			return fmt.Errorf("%s:%d: chain broken: previous hash does not match record %d", path, line, state.Records)
+		}
+
+		expected, err := hashAuditRecord(record)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if expected != record.Hash {
+			return fmt.Errorf("%s:%d: record %d has been modified", path, line, record.Seq)
+		}
+
+		state.Records = record.Seq
+		state.HeadHash = record.Hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// hashAuditRecord computes the chain hash of a record with its hash field cleared
+func hashAuditRecord(record AuditRecord) (string, error) {
+	record.Hash = ""
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode record for hashing: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// auditSegments returns segment paths in chain order
+func auditSegments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, auditFilePrefix) || !strings.HasSuffix(name, auditFileSuffix) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// lastAuditRecord returns the last record of a segment, or nil if it holds
+// none, and how many bytes of a trailing line torn by a crash mid-write were
+// truncated away. Malformed records are skipped with a warning for
+// VerifyAuditLog to report.
+func (a *AuditLogger) lastAuditRecord(path string) (*AuditRecord, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read audit segment: %w", err)
+	}
+
+	dropped := 0
+	if n := len(data); n > 0 && data[n-1] != '\n' {
+		keep := bytes.LastIndexByte(data, '\n') + 1
+		dropped = n - keep
+		a.logger.Warn("Truncating torn audit record",
+			zap.String("file", path),
+			zap.Int("bytes", dropped),
+		)
+		if err := os.Truncate(path, int64(keep)); err != nil {
+			return nil, 0, fmt.Errorf("failed to truncate torn audit record: %w", err)
+		}
+		data = data[:keep]
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		if len(bytes.TrimSpace(lines[i])) == 0 {
+			continue
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal(lines[i], &record); err != nil {
+			a.logger.Warn("Skipping malformed audit record",
+				zap.String("file", path),
+				zap.Int("line", i+1),
+				zap.Error(err),
+			)
+			continue
+		}
+		return &record, dropped, nil
+	}
+
+	return nil, dropped, nil
+}
+
+// redactHeaders drops credentials from headers or gRPC metadata
+func redactHeaders[M ~map[string][]string](in M) M {
+	if in == nil {
+		return nil
+	}
+	out := make(M, len(in))
+	for k, v := range in {
+		if redactedKeys[strings.ToLower(k)] {
+			out[k] = []string{"[redacted]"}
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,258 @@
+package security
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// writeAuditRecords runs one logger lifetime, writing n security events
+func writeAuditRecords(t *testing.T, config AuditConfig, n int) {
+	t.Helper()
+
+	audit := NewAuditLogger(config, zap.NewNop())
+	if err := audit.Err(); err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		audit.LogSecurityEvent(SecurityEvent{Type: "test", Resource: "instance/hash"})
+		if err := audit.Err(); err != nil {
+			t.Fatalf("failed to write audit record: %v", err)
+		}
+	}
+	if err := audit.Close(); err != nil {
+		t.Fatalf("failed to close audit log: %v", err)
+	}
+}
+
+// lastNonEmptySegment returns the newest segment holding records
+func lastNonEmptySegment(t *testing.T, dir string) string {
+	t.Helper()
+
+	files, err := auditSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		if info, err := os.Stat(files[i]); err == nil && info.Size() > 0 {
+			return files[i]
+		}
+	}
+	t.Fatal("no audit segment holds records")
+	return ""
+}
+
+// readAuditRecords parses the records of every segment in chain order
+func readAuditRecords(t *testing.T, dir string) []AuditRecord {
+	t.Helper()
+
+	files, err := auditSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []AuditRecord
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var record AuditRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestAuditLogResumesChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		config AuditConfig
+		runs   []int // Records written per logger lifetime
+		damage func(t *testing.T, dir string)
+		want   uint64
+		// Seq of the record reporting the truncated torn record, 0 for none
+		truncation uint64
+	}{
+		{
+			name: "restart",
+			runs: []int{3, 2},
+			want: 5,
+		},
+		{
+			name: "restarts before the first record",
+			runs: []int{2, 0, 0, 2},
+			want: 4,
+		},
+		{
+			name: "rotation by size",
+			config: AuditConfig{
+				MaxSizeBytes: 1,
+			},
+			runs: []int{4, 3},
+			want: 7,
+		},
+		{
+			name: "torn trailing record",
+			runs: []int{2, 1},
+			damage: func(t *testing.T, dir string) {
+				f, err := os.OpenFile(lastNonEmptySegment(t, dir), os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if _, err := f.WriteString(`{"seq":3,"timestamp":"20`); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:       4,
+			truncation: 3,
+		},
+		{
+			name: "torn record followed by an empty segment",
+			runs: []int{2, 0, 1},
+			damage: func(t *testing.T, dir string) {
+				f, err := os.OpenFile(lastNonEmptySegment(t, dir), os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if _, err := f.WriteString(`{"seq":3`); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:       4,
+			truncation: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := tt.config
+			config.Dir = dir
+
+			for i, n := range tt.runs {
+				writeAuditRecords(t, config, n)
+				if i == 0 && tt.damage != nil {
+					tt.damage(t, dir)
+				}
+			}
+
+			result, err := VerifyAuditLog(dir)
+			if err != nil {
+				t.Fatalf("VerifyAuditLog() error = %v", err)
+			}
+			if result.Records != tt.want {
+				t.Errorf("VerifyAuditLog() records = %d, want %d", result.Records, tt.want)
+			}
+
+			for _, record := range readAuditRecords(t, dir) {
+				var event SecurityEvent
+				if err := json.Unmarshal(record.Event, &event); err != nil {
+					t.Fatal(err)
+				}
+				truncation := record.Kind == "security" && event.Type == "audit_truncated"
+				if truncation != (record.Seq == tt.truncation) {
+					t.Errorf("record %d reports a truncation = %v, want %v", record.Seq, truncation, !truncation)
+				}
+				if truncation && !strings.Contains(event.Reason, "bytes") {
+					t.Errorf("truncation record reason = %q, want the bytes dropped", event.Reason)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyAuditLogDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, records []AuditRecord) []AuditRecord
+		wantErr string
+	}{
+		{
+			name: "edited event",
+			tamper: func(t *testing.T, records []AuditRecord) []AuditRecord {
+				records[1].Event = json.RawMessage(`{"Type":"forged"}`)
+				return records
+			},
+			wantErr: "has been modified",
+		},
+		{
+			name: "removed record",
+			tamper: func(t *testing.T, records []AuditRecord) []AuditRecord {
+				return append(records[:1], records[2:]...)
+			},
+			wantErr: "sequence gap",
+		},
+		{
+			name: "reordered records",
+			tamper: func(t *testing.T, records []AuditRecord) []AuditRecord {
+				records[1], records[2] = records[2], records[1]
+				return records
+			},
+			wantErr: "sequence gap",
+		},
+		{
+			name: "rehashed record with another predecessor",
+			tamper: func(t *testing.T, records []AuditRecord) []AuditRecord {
+				records[2].PrevHash = genesisHash
+				hash, err := hashAuditRecord(records[2])
+				if err != nil {
+					t.Fatal(err)
+				}
+				records[2].Hash = hash
+				return records
+			},
+			wantErr: "chain broken",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeAuditRecords(t, AuditConfig{Dir: dir}, 4)
+
+			path := lastNonEmptySegment(t, dir)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var records []AuditRecord
+			for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+				var record AuditRecord
+				if err := json.Unmarshal(line, &record); err != nil {
+					t.Fatal(err)
+				}
+				records = append(records, record)
+			}
+
+			var out bytes.Buffer
+			for _, record := range tt.tamper(t, records) {
+				line, err := json.Marshal(record)
+				if err != nil {
+					t.Fatal(err)
+				}
+				out.Write(append(line, '\n'))
+			}
+			if err := os.WriteFile(path, out.Bytes(), 0o640); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = VerifyAuditLog(dir)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyAuditLog() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package security
+
+import (
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// AuditEvent represents an audit log event
+type AuditEvent struct {
+	RequestID  string
+	Timestamp  time.Time
+	Method     string
+	URL        string
+	ClientIP   string
+	UserAgent  string
+	Headers    http.Header
+	Metadata   metadata.MD
+	Duration   time.Duration
+	StatusCode int
+	BytesIn    int64
+	BytesOut   int64
+	UserID     string
+	Resource   string
+	Error      string
+}
+
+// SecurityEvent represents a security-related event
+type SecurityEvent struct {
+	Type      string
+	RequestID string
+	UserID    string
+	ClientIP  string
+	Resource  string
+	Action    string
+	Reason    string
+	Error     string
+	Timestamp time.Time
+	Severity  string
+}
+
+// ThreatAnalysis represents threat analysis results
+type ThreatAnalysis struct {
+	RiskScore   float64
+	Threats     []string
+	Indicators  map[string]interface{}
+	Recommended []string
+}
@@ -0,0 +1,205 @@
+package security
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SecretPolicy decides what happens to a write containing a secret
+type SecretPolicy string
+
+const (
+	// SecretPolicyAudit stores the content unchanged and records an audit event
+	SecretPolicyAudit SecretPolicy = "audit"
+	// SecretPolicyRedact replaces matches before the content is stored
+	SecretPolicyRedact SecretPolicy = "redact"
+	// SecretPolicyReject refuses the write
+	SecretPolicyReject SecretPolicy = "reject"
+)
+
+// defaultSecretPatterns are always scanned for
+var defaultSecretPatterns = map[string]string{
+	"aws_access_key_id":     `\b(?:AKIA|ASIA|AGPA|AIDA|AROA)[0-9A-Z]{16}\b`,
+	"aws_secret_access_key": `(?i)aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+]{40}\b`,
+	"gcp_api_key":           `\bAIza[0-9A-Za-z_\-]{35}\b`,
+	"gcp_oauth_token":       `\bya29\.[0-9A-Za-z_\-]{20,}`,
+	"private_key":           `-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`,
+}
+
+// SecretScanConfig configures the secret scanner
+type SecretScanConfig struct {
+	Policy           SecretPolicy      // Default policy for every rule
+	RulePolicies     map[string]string // Per-rule policy overrides, rule name -> policy
+	CustomPatterns   map[string]string // Additional rules, rule name -> regular expression
+	MaxBlobScanBytes int64             // CAS blobs up to this size are scanned on Put
+}
+
+// SecretFinding is a single secret match
+type SecretFinding struct {
+	Rule   string
+	Policy SecretPolicy
+	Offset int
+}
+
+// SecretScanner finds credentials in build logs and small outputs
+type SecretScanner struct {
+	rules            []secretRule
+	maxBlobScanBytes int64
+}
+
+type secretRule struct {
+	name    string
+	pattern *regexp.Regexp
+	policy  SecretPolicy
+}
+
+// NewSecretScanner compiles the built-in and custom rules
+func NewSecretScanner(config SecretScanConfig) (*SecretScanner, error) {
+	if config.Policy == "" {
+		config.Policy = SecretPolicyRedact
+	}
+	if err := config.Policy.validate(); err != nil {
+		return nil, err
+	}
+
+	patterns := make(map[string]string, len(defaultSecretPatterns)+len(config.CustomPatterns))
+	for name, pattern := range defaultSecretPatterns {
+		patterns[name] = pattern
+	}
+	for name, pattern := range config.CustomPatterns {
+		patterns[name] = pattern
+	}
+
+	for name := range config.RulePolicies {
+		if _, ok := patterns[name]; !ok {
+			return nil, fmt.Errorf("policy override for unknown secret rule %q", name)
+		}
+	}
+
+	names := make([]string, 0, len(patterns))
+	for name := range patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	scanner := &SecretScanner{maxBlobScanBytes: config.MaxBlobScanBytes}
+	for _, name := range names {
+		pattern, err := regexp.Compile(patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for secret rule %q: %w", name, err)
+		}
+
+		policy := config.Policy
+		if override, ok := config.RulePolicies[name]; ok {
+			policy = SecretPolicy(override)
+			if err := policy.validate(); err != nil {
+				return nil, fmt.Errorf("secret rule %q: %w", name, err)
+			}
+		}
+
+		scanner.rules = append(scanner.rules, secretRule{
+			name:    name,
+			pattern: pattern,
+			policy:  policy,
+		})
+	}
+
+	return scanner, nil
+}
+
+// MaxBlobScanBytes returns the largest CAS blob scanned on Put
+func (s *SecretScanner) MaxBlobScanBytes() int64 {
+	return s.maxBlobScanBytes
+}
+
+// Scan returns every secret found in data
+func (s *SecretScanner) Scan(data []byte) []SecretFinding {
+	var findings []SecretFinding
+	for _, rule := range s.rules {
+		for _, loc := range rule.pattern.FindAllIndex(data, -1) {
+			findings = append(findings, SecretFinding{
+				Rule:   rule.name,
+				Policy: rule.policy,
+				Offset: loc[0],
+			})
+		}
+	}
+	return findings
+}
+
+// Redact replaces matches of rules with the redact policy
+func (s *SecretScanner) Redact(data []byte) []byte {
+	for _, rule := range s.rules {
+		if rule.policy != SecretPolicyRedact {
+			continue
+		}
+		data = rule.pattern.ReplaceAll(data, []byte("[REDACTED:"+rule.name+"]"))
+	}
+	return data
+}
+
+// StrongestSecretPolicy returns the most restrictive policy among findings
+func StrongestSecretPolicy(findings []SecretFinding) SecretPolicy {
+	strongest := SecretPolicy("")
+	for _, finding := range findings {
+		if finding.Policy.rank() > strongest.rank() {
+			strongest = finding.Policy
+		}
+	}
+	return strongest
+}
+
+// LoadSecretPatterns reads custom rules from a file with one "name=regex" per line
+func LoadSecretPatterns(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open secret patterns: %w", err)
+	}
+	defer f.Close()
+
+	patterns := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, pattern, ok := strings.Cut(text, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%s:%d: expected name=regex", path, line)
+		}
+		patterns[strings.TrimSpace(name)] = strings.TrimSpace(pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read secret patterns: %w", err)
+	}
+
+	return patterns, nil
+}
+
+func (p SecretPolicy) validate() error {
+	switch p {
+	case SecretPolicyAudit, SecretPolicyRedact, SecretPolicyReject:
+		return nil
+	}
+	return fmt.Errorf("unknown secret policy %q", p)
+}
+
+func (p SecretPolicy) rank() int {
+	switch p {
+	case SecretPolicyAudit:
+		return 1
+	case SecretPolicyRedact:
+		return 2
+	case SecretPolicyReject:
+		return 3
+	}
+	return 0
+}
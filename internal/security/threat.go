@@ -0,0 +1,551 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// Signal is an observation about a client that contributes to its risk score
+type Signal int
+
+const (
+	// SignalRequest is recorded for every request
+	SignalRequest Signal = iota
+	// SignalMiss is recorded for every read of an absent entry, not for
+	// existence checks
+	SignalMiss
+	// SignalDigestMismatch is recorded when uploaded content does not match its digest
+	SignalDigestMismatch
+	// SignalAuthFailure is recorded for rejected credentials or permissions
+	SignalAuthFailure
+
+	numSignals
+)
+
+// String returns the metric label for a signal
+func (s Signal) String() string {
+	switch s {
+	case SignalRequest:
+		return "request"
+	case SignalMiss:
+		return "miss"
+	case SignalDigestMismatch:
+		return "digest_mismatch"
+	case SignalAuthFailure:
+		return "auth_failure"
+	default:
+		return "unknown"
+	}
+}
+
+// ThreatConfig configures risk scoring and quarantine
+type ThreatConfig struct {
+	Window time.Duration // Sliding window over which signals are counted
+
+	// Per-window counts at which each signal saturates
+	RequestThreshold     int
+	MissThreshold        int
+	MismatchThreshold    int
+	AuthFailureThreshold int
+
+	// Contribution of a saturated signal to the risk score (0-1)
+	RequestWeight     float64
+	MissWeight        float64
+	MismatchWeight    float64
+	AuthFailureWeight float64
+
+	// Clients scoring above BlockScore are quarantined. A signal weighted at
+	// or below it raises the score but cannot quarantine on its own.
+	BlockScore     float64
+	QuarantineTTL  time.Duration // How long a quarantine lasts
+	QuarantineFile string        // JSON file persisting quarantines across restarts
+}
+
+// ThreatDetector identifies security threats in real-time
+type ThreatDetector struct {
+	config          ThreatConfig
+	anomalyDetector *AnomalyDetector
+	quarantine      *QuarantineManager
+	logger          *zap.Logger
+}
+
+// NewThreatDetector creates a threat detector with its quarantine store
+func NewThreatDetector(config ThreatConfig, logger *zap.Logger) *ThreatDetector {
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+	if config.BlockScore <= 0 {
+		config.BlockScore = 0.8
+	}
+
+	quarantine, err := NewQuarantineManager(config.QuarantineTTL, config.QuarantineFile, logger)
+	if err != nil {
+		logger.Error("Failed to load persisted quarantines", zap.Error(err))
+	}
+
+	return &ThreatDetector{
+		config:          config,
+		anomalyDetector: NewAnomalyDetector(config.Window),
+		quarantine:      quarantine,
+		logger:          logger,
+	}
+}
+
+// Quarantine returns the detector's quarantine store
+func (d *ThreatDetector) Quarantine() *QuarantineManager {
+	return d.quarantine
+}
+
+// BlockScore returns the risk score above which clients are quarantined
+func (d *ThreatDetector) BlockScore() float64 {
+	return d.config.BlockScore
+}
+
+// Observe records a signal for a client and returns its updated analysis
+func (d *ThreatDetector) Observe(client string, signal Signal) ThreatAnalysis {
+	counts := d.anomalyDetector.Record(client, signal, time.Now())
+	return d.score(counts)
+}
+
+// Analyze returns the current analysis for a client without recording anything
+func (d *ThreatDetector) Analyze(client string) ThreatAnalysis {
+	return d.score(d.anomalyDetector.Counts(client, time.Now()))
+}
+
+// AnalyzeRequest scores an HTTP request, keyed by token subject or remote host
+func (d *ThreatDetector) AnalyzeRequest(ctx context.Context, req *http.Request, claims *jwt.RegisteredClaims) ThreatAnalysis {
+	client := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		client = host
+	}
+	if claims != nil && claims.Subject != "" {
+		client = claims.Subject
+	}
+
+	return d.Observe(client, SignalRequest)
+}
+
+// score combines saturated signal ratios into a 0-1 risk score.
+// Signals are treated as independent: score = 1 - Π(1 - weight·ratio).
+func (d *ThreatDetector) score(counts [numSignals]float64) ThreatAnalysis {
+	thresholds := [numSignals]int{
+		SignalRequest:        d.config.RequestThreshold,
+		SignalMiss:           d.config.MissThreshold,
+		SignalDigestMismatch: d.config.MismatchThreshold,
+		SignalAuthFailure:    d.config.AuthFailureThreshold,
+	}
+	weights := [numSignals]float64{
+		SignalRequest:        d.config.RequestWeight,
+		SignalMiss:           d.config.MissWeight,
+		SignalDigestMismatch: d.config.MismatchWeight,
+		SignalAuthFailure:    d.config.AuthFailureWeight,
+	}
+
+	analysis := ThreatAnalysis{Indicators: make(map[string]interface{})}
+	safe := 1.0
+	for sig := Signal(0); sig < numSignals; sig++ {
+		analysis.Indicators[sig.String()] = counts[sig]
+		if thresholds[sig] <= 0 || weights[sig] <= 0 {
+			continue
+		}
+
+		ratio := math.Min(counts[sig]/float64(thresholds[sig]), 1)
+		if ratio >= 1 {
+			analysis.Threats = append(analysis.Threats, sig.String())
+		}
+		safe *= 1 - weights[sig]*ratio
+	}
+	analysis.RiskScore = 1 - safe
+
+	if analysis.RiskScore > d.config.BlockScore {
+		analysis.Recommended = append(analysis.Recommended, "quarantine")
+	}
+
+	return analysis
+}
+
+// AnomalyDetector counts per-client signals over a sliding window.
+//
+// Each client keeps counts for the current and previous fixed window; the
+// previous window is weighted by how much of it still overlaps the sliding
+// window, which approximates a true sliding count in constant memory.
+type AnomalyDetector struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*clientWindow
+	lastSweep time.Time
+}
+
+type clientWindow struct {
+	start    time.Time
+	current  [numSignals]int
+	previous [numSignals]int
+}
+
+// NewAnomalyDetector creates a detector with the given sliding window
+func NewAnomalyDetector(window time.Duration) *AnomalyDetector {
+	return &AnomalyDetector{
+		window:  window,
+		clients: make(map[string]*clientWindow),
+	}
+}
+
+// Record adds a signal for a client and returns the client's sliding counts
+func (a *AnomalyDetector) Record(client string, signal Signal, now time.Time) [numSignals]float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.sweepLocked(now)
+
+	w, ok := a.clients[client]
+	if !ok {
+		w = &clientWindow{start: now}
+		a.clients[client] = w
+	}
+	w.advance(now, a.window)
+	w.current[signal]++
+
+	return w.counts(now, a.window)
+}
+
+// Counts returns the client's sliding counts
+func (a *AnomalyDetector) Counts(client string, now time.Time) [numSignals]float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	w, ok := a.clients[client]
+	if !ok {
+		return [numSignals]float64{}
+	}
+	w.advance(now, a.window)
+
+	return w.counts(now, a.window)
+}
+
+// sweepLocked forgets clients that have been idle for two windows
+func (a *AnomalyDetector) sweepLocked(now time.Time) {
+	if now.Sub(a.lastSweep) < a.window {
+		return
+	}
+	a.lastSweep = now
+
+	for client, w := range a.clients {
+		if now.Sub(w.start) >= 2*a.window {
+			delete(a.clients, client)
+		}
+	}
+}
+
+func (w *clientWindow) advance(now time.Time, window time.Duration) {
+	elapsed := now.Sub(w.start)
+	if elapsed < window {
+		return
+	}
+	if elapsed < 2*window {
+		w.previous = w.current
+		w.start = w.start.Add(window)
+	} else {
+		w.previous = [numSignals]int{}
+		w.start = now
+	}
+	w.current = [numSignals]int{}
+}
+
+func (w *clientWindow) counts(now time.Time, window time.Duration) [numSignals]float64 {
+	overlap := 1 - float64(now.Sub(w.start))/float64(window)
+	if overlap < 0 {
+		overlap = 0
+	}
+
+	var out [numSignals]float64
+	for i := range out {
+		out[i] = float64(w.current[i]) + float64(w.previous[i])*overlap
+	}
+	return out
+}
+
+// QuarantineEntry describes a blocked client
+type QuarantineEntry struct {
+	Client        string    `json:"client"`
+	Reason        string    `json:"reason"`
+	RiskScore     float64   `json:"risk_score"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// QuarantineManager tracks quarantined clients and persists them to disk
+type QuarantineManager struct {
+	ttl    time.Duration
+	path   string
+	logger *zap.Logger
+
+	mu       sync.RWMutex
+	entries  map[string]QuarantineEntry
+	onChange func(active int)
+	audit    *AuditLogger
+}
+
+// NewQuarantineManager creates a quarantine store, loading persisted entries from path
+func NewQuarantineManager(ttl time.Duration, path string, logger *zap.Logger) (*QuarantineManager, error) {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+
+	q := &QuarantineManager{
+		ttl:     ttl,
+		path:    path,
+		logger:  logger,
+		entries: make(map[string]QuarantineEntry),
+	}
+
+	return q, q.load()
+}
+
+// OnChange registers a callback invoked with the active count after every change
+func (q *QuarantineManager) OnChange(fn func(active int)) {
+	q.mu.Lock()
+	q.onChange = fn
+	q.mu.Unlock()
+	fn(q.Count())
+}
+
+// AuditTo records every lift requested over HTTP in the audit log
+func (q *QuarantineManager) AuditTo(audit *AuditLogger) {
+	q.mu.Lock()
+	q.audit = audit
+	q.mu.Unlock()
+}
+
+// QuarantineClient blocks a client for the configured TTL
+func (q *QuarantineManager) QuarantineClient(client string) {
+	q.Quarantine(client, "risk_threshold", 0)
+}
+
+// Quarantine blocks a client for the configured TTL with a reason
+func (q *QuarantineManager) Quarantine(client, reason string, riskScore float64) QuarantineEntry {
+	now := time.Now()
+	entry := QuarantineEntry{
+		Client:        client,
+		Reason:        reason,
+		RiskScore:     riskScore,
+		QuarantinedAt: now,
+		ExpiresAt:     now.Add(q.ttl),
+	}
+
+	q.mu.Lock()
+	q.entries[client] = entry
+	err := q.saveLocked(now)
+	q.mu.Unlock()
+
+	if err != nil {
+		q.logger.Error("Failed to persist quarantine", zap.String("client", client), zap.Error(err))
+	}
+	q.notify()
+	q.logger.Warn("Client quarantined",
+		zap.String("client", client),
+		zap.String("reason", reason),
+		zap.Float64("risk_score", riskScore),
+		zap.Time("expires_at", entry.ExpiresAt),
+	)
+
+	return entry
+}
+
+// IsQuarantined reports whether a client is currently blocked
+func (q *QuarantineManager) IsQuarantined(client string) (QuarantineEntry, bool) {
+	q.mu.RLock()
+	entry, ok := q.entries[client]
+	q.mu.RUnlock()
+
+	if !ok || time.Now().After(entry.ExpiresAt) {
+		return QuarantineEntry{}, false
+	}
+	return entry, true
+}
+
+// List returns active quarantines ordered by expiry
+func (q *QuarantineManager) List() []QuarantineEntry {
+	now := time.Now()
+
+	q.mu.RLock()
+	entries := make([]QuarantineEntry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		if now.Before(entry.ExpiresAt) {
+			entries = append(entries, entry)
+		}
+	}
+	q.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ExpiresAt.Before(entries[j].ExpiresAt)
+	})
+	return entries
+}
+
+// Lift removes a client from quarantine, reporting whether it was quarantined
+func (q *QuarantineManager) Lift(client string) bool {
+	q.mu.Lock()
+	_, ok := q.entries[client]
+	delete(q.entries, client)
+	err := q.saveLocked(time.Now())
+	q.mu.Unlock()
+
+	if err != nil {
+		q.logger.Error("Failed to persist quarantine lift", zap.String("client", client), zap.Error(err))
+	}
+	if ok {
+		q.notify()
+		q.logger.Info("Quarantine lifted", zap.String("client", client))
+	}
+	return ok
+}
+
+// Count returns the number of active quarantines
+func (q *QuarantineManager) Count() int {
+	return len(q.List())
+}
+
+func (q *QuarantineManager) notify() {
+	q.mu.RLock()
+	fn := q.onChange
+	q.mu.RUnlock()
+
+	if fn != nil {
+		fn(q.Count())
+	}
+}
+
+// ServeHTTP lists quarantines on GET and lifts one on DELETE ?client=<id>.
+// It performs no authentication of its own and must only be served behind
+// RequireBearerToken or an equivalent check.
+func (q *QuarantineManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(q.List())
+	case http.MethodDelete:
+		client := r.URL.Query().Get("client")
+		if client == "" {
+			http.Error(w, "client parameter is required", http.StatusBadRequest)
+			return
+		}
+		lifted := q.Lift(client)
+		q.auditLift(r, client, lifted)
+		if !lifted {
+			http.Error(w, "client is not quarantined", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// auditLift records a lift request, including those for clients that were
+// not quarantined
+func (q *QuarantineManager) auditLift(r *http.Request, client string, lifted bool) {
+	q.mu.RLock()
+	audit := q.audit
+	q.mu.RUnlock()
+	if audit == nil {
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	event := SecurityEvent{
+		Type:     "quarantine_lift",
+		UserID:   "admin",
+		ClientIP: host,
+		Resource: client,
+		Action:   r.Method + " " + r.URL.Path,
+		Reason:   r.URL.Query().Get("reason"),
+		Severity: "warning",
+	}
+	if !lifted {
+		event.Error = "client is not quarantined"
+	}
+	audit.LogSecurityEvent(event)
+}
+
+// load reads persisted quarantines, dropping expired ones
+func (q *QuarantineManager) load() error {
+	if q.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read quarantine file: %w", err)
+	}
+
+	var entries []QuarantineEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse quarantine file: %w", err)
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if now.Before(entry.ExpiresAt) {
+			q.entries[entry.Client] = entry
+		}
+	}
+
+	return nil
+}
+
+// saveLocked atomically rewrites the quarantine file, pruning expired entries
+func (q *QuarantineManager) saveLocked(now time.Time) error {
+	entries := make([]QuarantineEntry, 0, len(q.entries))
+	for client, entry := range q.entries {
+		if now.After(entry.ExpiresAt) {
+			delete(q.entries, client)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if q.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode quarantines: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), ".quarantine-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp quarantine file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write quarantine file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close quarantine file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), q.path)
+}
@@ -0,0 +1,76 @@
+package security
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultThreatConfig mirrors the defaults of config.ThreatConfig
+var defaultThreatConfig = ThreatConfig{
+	Window:               time.Minute,
+	RequestThreshold:     6000,
+	MissThreshold:        3000,
+	MismatchThreshold:    5,
+	AuthFailureThreshold: 20,
+	RequestWeight:        0.5,
+	MissWeight:           0.6,
+	MismatchWeight:       0.9,
+	AuthFailureWeight:    0.9,
+	BlockScore:           0.8,
+}
+
+func TestThreatScoreDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		signals   map[Signal]int
+		wantBlock bool
+	}{
+		{
+			name:    "saturated requests",
+			signals: map[Signal]int{SignalRequest: 6000},
+		},
+		{
+			name:    "saturated requests and misses",
+			signals: map[Signal]int{SignalRequest: 6000, SignalMiss: 3000},
+		},
+		{
+			name:      "volume with a digest mismatch",
+			signals:   map[Signal]int{SignalRequest: 6000, SignalMiss: 3000, SignalDigestMismatch: 1},
+			wantBlock: true,
+		},
+		{
+			name:    "a few digest mismatches",
+			signals: map[Signal]int{SignalDigestMismatch: 4},
+		},
+		{
+			name:      "saturated digest mismatches",
+			signals:   map[Signal]int{SignalDigestMismatch: 5},
+			wantBlock: true,
+		},
+		{
+			name:      "saturated auth failures",
+			signals:   map[Signal]int{SignalAuthFailure: 20},
+			wantBlock: true,
+		},
+	}
+
+	detector := NewThreatDetector(defaultThreatConfig, zap.NewNop())
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fmt.Sprintf("client-%d", i)
+			for signal, n := range tt.signals {
+				for j := 0; j < n; j++ {
+					detector.Observe(client, signal)
+				}
+			}
+
+			analysis := detector.Analyze(client)
+			if blocked := analysis.RiskScore > detector.BlockScore(); blocked != tt.wantBlock {
+				t.Errorf("risk score %.3f blocks = %v, want %v", analysis.RiskScore, blocked, tt.wantBlock)
+			}
+		})
+	}
+}
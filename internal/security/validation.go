@@ -12,10 +12,79 @@ type InputValidator struct {
 	maxLength      int
 	allowedChars   *regexp.Regexp
 	blockedPatterns []string
+
+	// Cache request rules
+	rules               ValidationRules
+	hashRegex           *regexp.Regexp
+	instanceRegex       *regexp.Regexp
+	contentTypeRegex    *regexp.Regexp
+	allowedContentTypes map[string]bool
+}
+
+// reservedInstanceSegments are the namespaces the server keeps under each
+// instance, next to its CAS blobs
+var reservedInstanceSegments = map[string]bool{
+	"action_result":  true,
+	"ac_history":     true,
+	"provenance":     true,
+	"pins":           true,
+	"overlay":        true,
+	"nondeterminism": true,
+}
+
+// ValidationRules configures how cache requests are validated per deployment
+type ValidationRules struct {
+	HashPattern           string   // Regex every digest hash must match
+	InstanceNamePattern   string   // Regex every instance name must match
+	MaxInstanceNameLength int      // Maximum instance name length
+	AllowEmptyInstance    bool     // Accept the default (empty) instance name
+	ContentTypePattern    string   // Regex content types must match
+	AllowedContentTypes   []string // Exact allow-list; empty accepts any well-formed type
+	MaxDigestsPerRequest  int      // Upper bound for batch requests, 0 disables
+	MaxBlobSizeBytes      int64    // Upper bound for declared blob sizes, 0 disables
+}
+
+// DefaultValidationRules returns the rules used by NewInputValidator
+func DefaultValidationRules() ValidationRules {
+	return ValidationRules{
+		HashPattern:           `^[a-fA-F0-9]{64}$`,
+		InstanceNamePattern:   `^[a-zA-Z0-9\-_/]+$`,
+		MaxInstanceNameLength: 256,
+		AllowEmptyInstance:    true, // Bazel's default --remote_instance_name
+		ContentTypePattern:    `^[a-zA-Z0-9\-_/+.]+$`,
+		MaxDigestsPerRequest:  10000,
+	}
 }
 
 // NewInputValidator creates a new input validator with security rules
 func NewInputValidator() *InputValidator {
+	v, err := NewInputValidatorWithRules(DefaultValidationRules())
+	if err != nil {
+		panic(err) // default rules are static and always compile
+	}
+	return v
+}
+
+// NewInputValidatorWithRules creates an input validator with deployment-specific cache rules
+func NewInputValidatorWithRules(rules ValidationRules) (*InputValidator, error) {
+	hashRegex, err := regexp.Compile(rules.HashPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hash pattern: %w", err)
+	}
+	instanceRegex, err := regexp.Compile(rules.InstanceNamePattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance name pattern: %w", err)
+	}
+	contentTypeRegex, err := regexp.Compile(rules.ContentTypePattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type pattern: %w", err)
+	}
+
+	allowed := make(map[string]bool, len(rules.AllowedContentTypes))
+	for _, ct := range rules.AllowedContentTypes {
+		allowed[strings.ToLower(strings.TrimSpace(ct))] = true
+	}
+
 	return &InputValidator{
 		maxLength:    1000, // Reasonable default
 		allowedChars: regexp.MustCompile(`^[a-zA-Z0-9\-_./\s]+$`),
@@ -25,7 +94,17 @@ func NewInputValidator() *InputValidator {
 			"SELECT", "INSERT", "UPDATE", "DELETE", "DROP",
 			"eval(", "exec(", "system(", "shell(",
 		},
-	}
+		rules:               rules,
+		hashRegex:           hashRegex,
+		instanceRegex:       instanceRegex,
+		contentTypeRegex:    contentTypeRegex,
+		allowedContentTypes: allowed,
+	}, nil
+}
+
+// Rules returns the cache request rules in effect
+func (v *InputValidator) Rules() ValidationRules {
+	return v.rules
 }
 
 // ValidateString performs comprehensive string validation
@@ -66,10 +145,22 @@ func (v *InputValidator) ValidateArtifactHash(hash string) error {
 		return fmt.Errorf("artifact hash cannot be empty")
 	}
 
-	// Hash format validation (SHA256 expected)
-	hashRegex := regexp.MustCompile(`^[a-fA-F0-9]{64}$`)
-	if !hashRegex.MatchString(hash) {
-		return fmt.Errorf("invalid hash format, expected SHA256")
+	// Hash format validation (SHA256 expected by default)
+	if !v.hashRegex.MatchString(hash) {
+		return fmt.Errorf("invalid hash format, expected match for %s", v.rules.HashPattern)
+	}
+
+	return nil
+}
+
+// ValidateBlobSize validates a declared artifact size
+func (v *InputValidator) ValidateBlobSize(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("size cannot be negative")
+	}
+
+	if v.rules.MaxBlobSizeBytes > 0 && size > v.rules.MaxBlobSizeBytes {
+		return fmt.Errorf("size %d exceeds maximum of %d bytes", size, v.rules.MaxBlobSizeBytes)
 	}
 
 	return nil
@@ -78,13 +169,14 @@ func (v *InputValidator) ValidateArtifactHash(hash string) error {
 // ValidateInstanceName validates Bazel instance names
 func (v *InputValidator) ValidateInstanceName(instanceName string) error {
 	if instanceName == "" {
+		if v.rules.AllowEmptyInstance {
+			return nil
+		}
 		return fmt.Errorf("instance name cannot be empty")
 	}
 
-	// Instance name format validation
-	instanceRegex := regexp.MustCompile(`^[a-zA-Z0-9\-_/]+$`)
-	if !instanceRegex.MatchString(instanceName) {
-		return fmt.Errorf("invalid instance name format")
+	if v.rules.MaxInstanceNameLength > 0 && len(instanceName) > v.rules.MaxInstanceNameLength {
+		return fmt.Errorf("instance name exceeds maximum length of %d characters", v.rules.MaxInstanceNameLength)
 	}
 
 	// Prevent directory traversal
@@ -92,6 +184,25 @@ func (v *InputValidator) ValidateInstanceName(instanceName string) error {
 		return fmt.Errorf("instance name contains directory traversal")
 	}
 
+	// Instance name format validation
+	if !v.instanceRegex.MatchString(instanceName) {
+		return fmt.Errorf("invalid instance name format, expected match for %s", v.rules.InstanceNamePattern)
+	}
+
+	// Reject empty path segments, which collapse into other instances' keys
+	if strings.HasPrefix(instanceName, "/") || strings.HasSuffix(instanceName, "/") || strings.Contains(instanceName, "//") {
+		return fmt.Errorf("instance name contains empty path segments")
+	}
+
+	// A segment naming a per-instance namespace would read that namespace of
+	// the parent instance, e.g. a/provenance/<hash>, or make the pruners take
+	// keys below it for that namespace, e.g. a/provenance/b/<hash>
+	for _, segment := range strings.Split(instanceName, "/") {
+		if reservedInstanceSegments[segment] {
+			return fmt.Errorf("instance name must not contain reserved segment %q", segment)
+		}
+	}
+
 	return nil
 }
 
@@ -116,15 +227,18 @@ func (v *InputValidator) ValidateContentType(contentType string) error {
 		return nil // Optional field
 	}
 
+	// Maximum length for content type
+	if len(contentType) > 100 {
+		return fmt.Errorf("content type too long")
+	}
+
 	// Content type format validation
-	contentTypeRegex := regexp.MustCompile(`^[a-zA-Z0-9\-_/+.]+$`)
-	if !contentTypeRegex.MatchString(contentType) {
+	if !v.contentTypeRegex.MatchString(contentType) {
 		return fmt.Errorf("invalid content type format")
 	}
 
-	// Maximum length for content type
-	if len(contentType) > 100 {
-		return fmt.Errorf("content type too long")
+	if len(v.allowedContentTypes) > 0 && !v.allowedContentTypes[strings.ToLower(contentType)] {
+		return fmt.Errorf("content type %q is not allowed", contentType)
 	}
 
 	return nil
@@ -0,0 +1,49 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateInstanceName(t *testing.T) {
+	v := NewInputValidator()
+
+	tests := []struct {
+		name     string
+		instance string
+		wantErr  string
+	}{
+		{name: "empty", instance: ""},
+		{name: "single segment", instance: "ci"},
+		{name: "nested", instance: "team-a/ci"},
+		{name: "underscore", instance: "my_project"},
+		{name: "underscore nested", instance: "default_instance/ci_linux"},
+		{name: "traversal", instance: "ci/../prod", wantErr: "directory traversal"},
+		{name: "leading slash", instance: "/ci", wantErr: "empty path segments"},
+		{name: "trailing slash", instance: "ci/", wantErr: "empty path segments"},
+		{name: "double slash", instance: "ci//prod", wantErr: "empty path segments"},
+		{name: "reserved alone", instance: "provenance", wantErr: "reserved segment"},
+		{name: "reserved last", instance: "ci/pins", wantErr: "reserved segment"},
+		{name: "reserved first", instance: "overlay/ci", wantErr: "reserved segment"},
+		{name: "reserved in the middle", instance: "ci/provenance/prod", wantErr: "reserved segment"},
+		{name: "reserved deep in the middle", instance: "a/b/nondeterminism/c/d", wantErr: "reserved segment"},
+		{name: "action cache namespace", instance: "ci/action_result/prod", wantErr: "reserved segment"},
+		{name: "reserved word inside a segment", instance: "ci/provenance-tools"},
+		{name: "backslash", instance: `ci\prod`, wantErr: "invalid instance name format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.ValidateInstanceName(tt.instance)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateInstanceName(%q) error = %v", tt.instance, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateInstanceName(%q) error = %v, want %q", tt.instance, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+// Package simulation replays recorded cache accesses against a pruning
+// configuration to estimate its hit rate before it is deployed.
+package simulation
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/accesstrace"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+// Config is a pruning configuration to simulate
+type Config struct {
+	Name       string
+	MaxSize    int64            // Pruning starts above this many bytes
+	TargetSize int64            // Pruning stops at this many bytes, 80% of MaxSize like the pruning service if 0
+	Interval   time.Duration    // Time between pruning cycles, 0 to never prune
+	Policy     *eviction.Policy // Must be streamable
+}
+
+// Window aggregates the accesses of one period of the trace
+type Window struct {
+	Start          time.Time `json:"start"`
+	Gets           int64     `json:"gets"`
+	Hits           int64     `json:"hits"`
+	RequestedBytes int64     `json:"requested_bytes"`
+	HitBytes       int64     `json:"hit_bytes"`
+	Contains       int64     `json:"contains"`
+	ContainsHits   int64     `json:"contains_hits"`
+	Puts           int64     `json:"puts"`
+	PutBytes       int64     `json:"put_bytes"`
+	Deleted        int64     `json:"deleted"`
+	DeletedBytes   int64     `json:"deleted_bytes"`
+	Size           int64     `json:"size"` // Cache size at the end of the window
+}
+
+// HitRate is the fraction of gets served from the cache
+func (w *Window) HitRate() float64 {
+	return ratio(w.Hits, w.Gets)
+}
+
+// ByteHitRate is the fraction of requested bytes served from the cache
+func (w *Window) ByteHitRate() float64 {
+	return ratio(w.HitBytes, w.RequestedBytes)
+}
+
+// Result is the outcome of replaying a trace against one configuration
+type Result struct {
+	Name     string    `json:"name"`
+	Strategy string    `json:"strategy"`
+	MaxSize  int64     `json:"max_size"`
+	Total    Window    `json:"total"`
+	Cycles   int       `json:"cycles"`
+	PeakSize int64     `json:"peak_size"`
+	Windows  []*Window `json:"windows,omitempty"`
+}
+
+type entry struct {
+	size         int64
+	lastAccessed time.Time
+	accessCount  int64
+	expiresAt    time.Time
+}
+
+// Simulator models a cache bucket and its pruning service. It is not safe
+// for concurrent use.
+type Simulator struct {
+	cfg     Config
+	window  time.Duration
+	entries map[string]*entry
+	seen    *eviction.KeySet
+	size    int64
+
+	now       time.Time
+	nextPrune time.Time
+	current   *Window
+	result    *Result
+}
+
+// New returns a simulator starting with an empty cache. Windows of the given
+// length are reported, or none if window is 0.
+func New(cfg Config, window time.Duration) *Simulator {
+	if cfg.TargetSize == 0 {
+		cfg.TargetSize = int64(float64(cfg.MaxSize) * 0.8)
+	}
+	return &Simulator{
+		cfg:     cfg,
+		window:  window,
+		entries: make(map[string]*entry),
+		seen:    eviction.NewKeySet(),
+		result: &Result{
+			Name:     cfg.Name,
+			Strategy: cfg.Policy.Default.Name(),
+			MaxSize:  cfg.MaxSize,
+		},
+	}
+}
+
+// Apply replays one access. Records are expected in time order; a record
+// older than the previous one is applied at the previous time.
+//
+// The trace usually starts with a populated cache, so a get or contains the
+// server reported as a hit for a key the simulation has never seen is
+// treated as an entry stored before the trace began.
+func (s *Simulator) Apply(rec accesstrace.Record) {
+	s.advance(rec.Time)
+	w := s.current
+
+	e := s.entries[rec.Key]
+	if e != nil && !e.expiresAt.IsZero() && !s.now.Before(e.expiresAt) {
+		e = nil // Reads as a miss until pruned
+	}
+	if e == nil && rec.Hit && rec.Op != accesstrace.OpPut && !s.seen.Contains(rec.Key) {
+		e = s.store(rec.Key, rec.Size, 0)
+	}
+	s.seen.Add(rec.Key)
+
+	switch rec.Op {
+	case accesstrace.OpGet:
+		w.Gets++
+		if e == nil {
+			w.RequestedBytes += rec.Size
+			return
+		}
+		w.Hits++
+		w.RequestedBytes += e.size
+		w.HitBytes += e.size
+		s.touch(e)
+	case accesstrace.OpContains:
+		// The server checks existence with a read, which counts as an access
+		w.Contains++
+		if e != nil {
+			w.ContainsHits++
+			s.touch(e)
+		}
+	case accesstrace.OpPut:
+		w.Puts++
+		w.PutBytes += rec.Size
+		s.store(rec.Key, rec.Size, time.Duration(rec.TTLSeconds)*time.Second)
+	}
+}
+
+// Finish closes the last window and returns the result
+func (s *Simulator) Finish() *Result {
+	s.closeWindow()
+	return s.result
+}
+
+// advance moves the clock to t, running the pruning cycles and closing the
+// windows that are due before it
+func (s *Simulator) advance(t time.Time) {
+	if s.current == nil {
+		s.now = t
+		s.nextPrune = t.Add(s.cfg.Interval)
+		s.current = &Window{Start: s.windowStart(t)}
+		return
+	}
+	if t.Before(s.now) {
+		t = s.now
+	}
+
+	for s.cfg.Interval > 0 && !s.nextPrune.After(t) {
+		s.rollWindows(s.nextPrune)
+		s.now = s.nextPrune
+		s.prune()
+		s.nextPrune = s.nextPrune.Add(s.cfg.Interval)
+	}
+	s.rollWindows(t)
+	s.now = t
+}
+
+// rollWindows closes every window ending at or before t
+func (s *Simulator) rollWindows(t time.Time) {
+	if s.window <= 0 {
+		return
+	}
+	for !t.Before(s.current.Start.Add(s.window)) {
+		start := s.current.Start.Add(s.window)
+		s.closeWindow()
+		s.current = &Window{Start: start}
+	}
+}
+
+func (s *Simulator) closeWindow() {
+	if s.current == nil {
+		return
+	}
+	w := s.current
+	w.Size = s.size
+
+	t := &s.result.Total
+	t.Gets += w.Gets
+	t.Hits += w.Hits
+	t.RequestedBytes += w.RequestedBytes
+	t.HitBytes += w.HitBytes
+	t.Contains += w.Contains
+	t.ContainsHits += w.ContainsHits
+	t.Puts += w.Puts
+	t.PutBytes += w.PutBytes
+	t.Deleted += w.Deleted
+	t.DeletedBytes += w.DeletedBytes
+	t.Size = w.Size
+	if t.Start.IsZero() {
+		t.Start = w.Start
+	}
+
+	if s.window > 0 {
+		s.result.Windows = append(s.result.Windows, w)
+	}
+	s.current = nil
+}
+
+func (s *Simulator) windowStart(t time.Time) time.Time {
+	if s.window <= 0 {
+		return t
+	}
+	return t.Truncate(s.window)
+}
+
+func (s *Simulator) store(key string, size int64, ttl time.Duration) *entry {
+	if old := s.entries[key]; old != nil {
+		s.size -= old.size
+	}
+	e := &entry{size: size, lastAccessed: s.now}
+	if ttl > 0 {
+		e.expiresAt = s.now.Add(ttl)
+	}
+	s.entries[key] = e
+	s.size += size
+	if s.size > s.result.PeakSize {
+		s.result.PeakSize = s.size
+	}
+	return e
+}
+
+func (s *Simulator) touch(e *entry) {
+	e.lastAccessed = s.now
+	e.accessCount++
+}
+
+// prune runs one cycle of the pruning service: expired entries are always
+// deleted, and above MaxSize the streaming selection frees down to
+// TargetSize. Keys are visited in lexical order, as a bucket listing would.
+func (s *Simulator) prune() {
+	s.result.Cycles++
+
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var selector *eviction.Selector
+	if s.size > s.cfg.MaxSize {
+		estimator := eviction.NewEstimator(s.now)
+		for _, key := range keys {
+			estimator.Add(s.cfg.Policy, s.item(key))
+		}
+		selector = s.cfg.Policy.NewSelector(s.cfg.Policy.Cutoffs(estimator, s.size-s.cfg.TargetSize))
+	}
+
+	for _, key := range keys {
+		item := s.item(key)
+		evict := item.Expired(s.now)
+		if !evict && selector != nil {
+			_, evict = selector.Decide(item)
+		}
+		if evict {
+			s.current.Deleted++
+			s.current.DeletedBytes += item.Size
+			s.size -= item.Size
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *Simulator) item(key string) eviction.Item {
+	e := s.entries[key]
+	instance := ""
+	if i := strings.Index(key, "/"); i >= 0 {
+		instance = key[:i]
+	}
+	return eviction.Item{
+		Key:          key,
+		Instance:     instance,
+		Size:         e.size,
+		LastAccessed: e.lastAccessed,
+		AccessCount:  e.accessCount,
+		ExpiresAt:    e.expiresAt,
+	}
+}
+
+func ratio(a, b int64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
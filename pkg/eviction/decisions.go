@@ -0,0 +1,138 @@
+package eviction
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeletionPrefix holds the decision log, where the pruners record the entries
+// they delete. Records are sharded by a hash of the key, so looking up one key
+// reads one shard.
+const DeletionPrefix = "decisions/"
+
+// deletionFlushBytes is how much a DeletionLog buffers before asking to be flushed
+const deletionFlushBytes = 8 * 1024 * 1024
+
+// Deletion records why a pruner deleted a cache entry
+type Deletion struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastAccessed time.Time `json:"last_accessed"`
+	Reason       string    `json:"reason"` // Strategy reason, e.g. lru, expired, orphan
+	Pruner       string    `json:"pruner"` // Which pruner deleted it, e.g. cache-server
+	RunID        string    `json:"run_id"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	Trashed      bool      `json:"trashed,omitempty"` // Moved to the trash under RunID rather than deleted
+}
+
+// DeletionShard returns the shard directory recording decisions about key
+func DeletionShard(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return DeletionPrefix + hex.EncodeToString(sum[:1]) + "/"
+}
+
+// DeletionLog buffers decisions by shard until they are flushed. Every flush
+// writes new objects, so an interrupted run loses at most its buffer and a
+// resumed run never overwrites what was written before.
+type DeletionLog struct {
+	pruner string
+	runID  string
+
+	mu       sync.Mutex
+	shards   map[string]*bytes.Buffer
+	buffered int
+}
+
+// NewDeletionLog returns an empty log for a pruning run
+func NewDeletionLog(pruner, runID string) *DeletionLog {
+	return &DeletionLog{pruner: pruner, runID: runID, shards: make(map[string]*bytes.Buffer)}
+}
+
+// Add buffers a decision, filling in the pruner and run, and reports whether
+// the buffer is full and should be flushed
+func (l *DeletionLog) Add(d Deletion) bool {
+	d.Pruner, d.RunID = l.pruner, l.runID
+	line, err := json.Marshal(d)
+	if err != nil {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	shard := DeletionShard(d.Key)
+	buf, ok := l.shards[shard]
+	if !ok {
+		buf = &bytes.Buffer{}
+		l.shards[shard] = buf
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+	l.buffered += len(line) + 1
+	return l.buffered >= deletionFlushBytes
+}
+
+// Flush writes the buffered decisions of every shard with write, one object
+// per shard. Decisions are dropped from the buffer once handed to write.
+func (l *DeletionLog) Flush(write func(object string, data []byte) error) error {
+	l.mu.Lock()
+	shards := l.shards
+	l.shards = make(map[string]*bytes.Buffer)
+	l.buffered = 0
+	l.mu.Unlock()
+
+	// Object names sort by run, then by flush
+	suffix := fmt.Sprintf("%s.%s.%d.jsonl", l.runID, l.pruner, time.Now().UnixNano())
+	var firstErr error
+	for shard, buf := range shards {
+		if err := write(shard+suffix, buf.Bytes()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// DeletionObjectTime returns when a decision object was flushed, from its name
+func DeletionObjectTime(object string) (time.Time, bool) {
+	name := strings.TrimSuffix(object[strings.LastIndex(object, "/")+1:], ".jsonl")
+	nanos, err := strconv.ParseInt(name[strings.LastIndex(name, ".")+1:], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// FindDeletions reads a decision object and returns the decisions about key
+func FindDeletions(r io.Reader, key string) ([]Deletion, error) {
+	needle, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Deletion
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		// Skip the JSON decoding for other keys
+		if !bytes.Contains(line, needle) {
+			continue
+		}
+		var d Deletion
+		if err := json.Unmarshal(line, &d); err != nil {
+			return found, fmt.Errorf("failed to parse decision: %w", err)
+		}
+		if d.Key == key {
+			found = append(found, d)
+		}
+	}
+	return found, scanner.Err()
+}
@@ -0,0 +1,221 @@
+// Package eviction implements the cache eviction strategies shared by the
+// cache server's pruning service and the standalone pruning-service.
+//
+// The package depends only on the standard library and protowire so that
+// pruning-service, which is a separate module, can carry an identical copy.
+// Edit the files here and run `make sync-eviction` to update the copy.
+package eviction
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Strategy names accepted by New
+const (
+	StrategyLRU            = "lru"
+	StrategyLFU            = "lfu"
+	StrategyGDSF           = "gdsf"
+	StrategyRetentionScore = "retention_score"
+)
+
+// ReasonExpired marks entries evicted because their TTL passed, whatever the
+// strategy
+const ReasonExpired = "expired"
+
+// ExpiresAtKey is the object metadata holding an entry's RFC3339 expiry time
+const ExpiresAtKey = "expires_at"
+
+// Item is a cache entry considered for eviction
+type Item struct {
+	Key          string // Identifies the entry to the caller, opaque to strategies
+	Instance     string
+	Size         int64
+	LastAccessed time.Time
+	AccessCount  int64     // Reads recorded in object metadata, 0 if unknown
+	ExpiresAt    time.Time // Zero if the entry has no TTL
+}
+
+// Expired reports whether the item's TTL has passed
+func (i Item) Expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt)
+}
+
+// ExpiresAt reads the expiry time from object metadata, zero if there is none
+func ExpiresAt(metadata map[string]string) time.Time {
+	t, err := time.Parse(time.RFC3339, metadata[ExpiresAtKey])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Decision selects an item for eviction
+type Decision struct {
+	Item      Item
+	Reason    string
+	Mandatory bool // Evicted even if enough bytes were already freed
+}
+
+// Strategy orders items by eviction priority
+type Strategy interface {
+	// Name returns the configured strategy name
+	Name() string
+	// Rank returns every item it may evict, first to evict first
+	Rank(items []Item, now time.Time) []Decision
+}
+
+// Options configures the built-in strategies
+type Options struct {
+	Retention time.Duration // Entries idle longer are always evicted by retention_score
+}
+
+// New returns a built-in strategy by name
+func New(name string, opts Options) (Strategy, error) {
+	switch strings.ToLower(name) {
+	case StrategyLRU:
+		return LRU{}, nil
+	case StrategyLFU:
+		return LFU{}, nil
+	case StrategyGDSF:
+		return GDSF{}, nil
+	case StrategyRetentionScore:
+		if opts.Retention <= 0 {
+			return nil, fmt.Errorf("%s strategy requires a positive retention", StrategyRetentionScore)
+		}
+		return RetentionScore{Retention: opts.Retention}, nil
+	}
+	return nil, fmt.Errorf("unknown eviction strategy %q", name)
+}
+
+// Policy applies a strategy per instance
+type Policy struct {
+	Default     Strategy
+	PerInstance map[string]Strategy
+}
+
+// NewPolicy builds a policy from strategy names, instance -> strategy name
+func NewPolicy(defaultName string, perInstance map[string]string, opts Options) (*Policy, error) {
+	def, err := New(defaultName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{
+		Default:     def,
+		PerInstance: make(map[string]Strategy, len(perInstance)),
+	}
+	for instance, name := range perInstance {
+		strategy, err := New(name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("instance %q: %w", instance, err)
+		}
+		policy.PerInstance[instance] = strategy
+	}
+
+	return policy, nil
+}
+
+// StrategyFor returns the strategy applied to an instance
+func (p *Policy) StrategyFor(instance string) Strategy {
+	if s, ok := p.PerInstance[instance]; ok {
+		return s
+	}
+	return p.Default
+}
+
+// Select picks the items to evict to free bytesToFree bytes.
+//
+// Each instance frees a share proportional to its footprint, ranked by its own
+// strategy. When an instance runs out of candidates the remaining deficit is
+// spread over the others. Mandatory decisions, and expired items first of all,
+// are always included.
+func (p *Policy) Select(items []Item, bytesToFree int64, now time.Time) []Decision {
+	var selected []Decision
+	var freed int64
+
+	groups := make(map[string][]Item)
+	for _, item := range items {
+		if item.Expired(now) {
+			selected = append(selected, Decision{Item: item, Reason: ReasonExpired, Mandatory: true})
+			freed += item.Size
+			continue
+		}
+		groups[item.Instance] = append(groups[item.Instance], item)
+	}
+
+	instances := make([]string, 0, len(groups))
+	for instance := range groups {
+		instances = append(instances, instance)
+	}
+	sort.Strings(instances)
+
+	type queue struct {
+		ranked    []Decision
+		next      int
+		remaining int64 // Bytes not yet selected
+	}
+
+	queues := make(map[string]*queue, len(instances))
+
+	for _, instance := range instances {
+		q := &queue{ranked: p.StrategyFor(instance).Rank(groups[instance], now)}
+		for _, d := range q.ranked {
+			q.remaining += d.Item.Size
+		}
+		queues[instance] = q
+
+		// Mandatory decisions are ranked first
+		for q.next < len(q.ranked) && q.ranked[q.next].Mandatory {
+			d := q.ranked[q.next]
+			selected = append(selected, d)
+			freed += d.Item.Size
+			q.remaining -= d.Item.Size
+			q.next++
+		}
+	}
+
+	// Hand out the deficit proportionally until it is covered or nothing is left
+	for freed < bytesToFree {
+		var available int64
+		for _, q := range queues {
+			available += q.remaining
+		}
+		if available == 0 {
+			break
+		}
+
+		deficit := bytesToFree - freed
+		progress := false
+		for _, instance := range instances {
+			q := queues[instance]
+			if q.remaining == 0 {
+				continue
+			}
+
+			share := int64(float64(deficit) * float64(q.remaining) / float64(available))
+			if share == 0 {
+				share = 1
+			}
+
+			var taken int64
+			for taken < share && q.next < len(q.ranked) {
+				d := q.ranked[q.next]
+				selected = append(selected, d)
+				taken += d.Item.Size
+				q.remaining -= d.Item.Size
+				q.next++
+				progress = true
+			}
+			freed += taken
+		}
+
+		if !progress {
+			break
+		}
+	}
+
+	return selected
+}
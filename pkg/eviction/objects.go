@@ -0,0 +1,41 @@
+package eviction
+
+import "strings"
+
+// objectNameEscaper flattens the slashes of a key to underscores, as object
+// names always did, and percent-encodes the characters that could otherwise
+// make two keys share an object: underscores, backslashes, colons and the
+// percent sign itself.
+var objectNameEscaper = strings.NewReplacer(
+	"%", "%25",
+	"_", "%5F",
+	"\\", "%5C",
+	":", "%3A",
+	"/", "_",
+)
+
+// legacyObjectNameEscaper is how object names were derived before escaping,
+// mapping '/', '\' and ':' alike to '_'
+var legacyObjectNameEscaper = strings.NewReplacer("/", "_", "\\", "_", ":", "_")
+
+// EscapeKey maps a cache key to the object name the cache server stores it
+// under, relative to the cache prefix. Distinct keys never share a name, and
+// keys without '_', '\', ':', '%' or a leading '.' keep their legacy name.
+func EscapeKey(key string) string {
+	escaped := objectNameEscaper.Replace(key)
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "%2E" + escaped[1:]
+	}
+	return escaped
+}
+
+// LegacyEscapeKey returns the name a key was stored under before keys were
+// escaped. Several keys can share it, so an object found there only holds the
+// key if its recorded key matches.
+func LegacyEscapeKey(key string) string {
+	escaped := legacyObjectNameEscaper.Replace(key)
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "cache_" + escaped
+	}
+	return escaped
+}
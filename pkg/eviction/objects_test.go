@@ -0,0 +1,51 @@
+package eviction
+
+import "testing"
+
+func TestEscapeKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		want   string
+		legacy bool // Whether the legacy name is kept
+	}{
+		{key: "ci/ab12", want: "ci_ab12", legacy: true},
+		{key: "team-a/ci/ab12", want: "team-a_ci_ab12", legacy: true},
+		{key: "ci/action_result/ab12", want: "ci_action%5Fresult_ab12"},
+		{key: "my_project/ab12", want: "my%5Fproject_ab12"},
+		{key: `ci\ab12`, want: "ci%5Cab12"},
+		{key: "ci:ab12", want: "ci%3Aab12"},
+		{key: "ci%5F/ab12", want: "ci%255F_ab12"},
+		{key: ".hidden/ab12", want: "%2Ehidden_ab12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := EscapeKey(tt.key); got != tt.want {
+				t.Errorf("EscapeKey(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+			if kept := EscapeKey(tt.key) == LegacyEscapeKey(tt.key); kept != tt.legacy {
+				t.Errorf("EscapeKey(%q) keeps the legacy name = %v, want %v", tt.key, kept, tt.legacy)
+			}
+		})
+	}
+}
+
+func TestEscapeKeyIsInjective(t *testing.T) {
+	// Each group shared one object name before keys were escaped
+	groups := [][]string{
+		{"a/b/ab12", "a_b/ab12", "a/b_ab12", `a\b/ab12`, "a:b/ab12"},
+		{".x/ab12", "cache/.x/ab12", "cache_.x/ab12"},
+		{"a%5F/ab12", "a_/ab12"},
+	}
+
+	for _, keys := range groups {
+		seen := make(map[string]string)
+		for _, key := range keys {
+			name := EscapeKey(key)
+			if other, ok := seen[name]; ok {
+				t.Errorf("keys %q and %q share object name %q", other, key, name)
+			}
+			seen[name] = key
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package eviction
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// PinPrefix is where pins are stored in the bucket, next to and not inside the
+// cache entries the pruners list
+const PinPrefix = "pins/"
+
+// Pin exempts cache entries from pruning until it expires, e.g. the outputs of
+// a release build kept for hotfix rebuilds
+type Pin struct {
+	ID           string    `json:"id"`
+	Label        string    `json:"label"`
+	InstanceName string    `json:"instance_name"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"` // Zero for a pin that never expires
+	Keys         []string  `json:"keys"`       // Cache keys held by the pin
+	Bytes        int64     `json:"bytes"`      // Size of the held entries when pinned
+}
+
+// Expired reports whether the pin no longer holds its entries
+func (p *Pin) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+// PinObject returns the bucket object a pin is stored in
+func PinObject(id string) string {
+	return PinPrefix + id + ".json"
+}
+
+// DecodePin parses a stored pin
+func DecodePin(data []byte) (*Pin, error) {
+	pin := &Pin{}
+	if err := json.Unmarshal(data, pin); err != nil {
+		return nil, fmt.Errorf("failed to parse pin: %w", err)
+	}
+	return pin, nil
+}
+
+// PinnedKeys returns the keys held by pins that have not expired. A false
+// positive only keeps an entry that could have been pruned.
+func PinnedKeys(pins []*Pin, now time.Time) *KeySet {
+	keys := NewKeySet()
+	for _, pin := range pins {
+		if pin.Expired(now) {
+			continue
+		}
+		for _, key := range pin.Keys {
+			keys.Add(key)
+		}
+	}
+	return keys
+}
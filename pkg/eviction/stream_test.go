@@ -0,0 +1,195 @@
+package eviction
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var streamNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// idle returns an item of size bytes last read the given hours before streamNow
+func idle(key, instance string, hours float64, size int64) Item {
+	return Item{
+		Key:          key,
+		Instance:     instance,
+		Size:         size,
+		LastAccessed: streamNow.Add(-time.Duration(hours * float64(time.Hour))),
+	}
+}
+
+// streamSelect runs both passes of streaming selection over items in order
+func streamSelect(t *testing.T, p *Policy, items []Item, bytesToFree int64) []string {
+	t.Helper()
+
+	e := NewEstimator(streamNow)
+	for _, item := range items {
+		e.Add(p, item)
+	}
+	selector := p.NewSelector(p.Cutoffs(e, bytesToFree))
+
+	var selected []string
+	for _, item := range items {
+		if _, ok := selector.Decide(item); ok {
+			selected = append(selected, item.Key)
+		}
+	}
+	sort.Strings(selected)
+	return selected
+}
+
+func TestStreamingSelection(t *testing.T) {
+	lru, err := NewPolicy(StrategyLRU, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := idle("expired", "ci", 1, 100)
+	expired.ExpiresAt = streamNow.Add(-time.Minute)
+
+	tests := []struct {
+		name        string
+		items       []Item
+		bytesToFree int64
+		want        []string
+	}{
+		{
+			name:        "nothing to free",
+			items:       []Item{idle("a", "ci", 1, 100), idle("b", "ci", 8, 100)},
+			bytesToFree: 0,
+			want:        nil,
+		},
+		{
+			name: "oldest first, boundary bucket included",
+			items: []Item{
+				idle("1h", "ci", 1, 100),
+				idle("2h", "ci", 2, 100),
+				idle("4h", "ci", 4, 100),
+				idle("8h", "ci", 8, 100),
+			},
+			bytesToFree: 150,
+			want:        []string{"4h", "8h"},
+		},
+		{
+			name: "boundary bucket taken in listing order",
+			items: []Item{
+				idle("first", "ci", 4, 100),
+				idle("second", "ci", 4, 100),
+				idle("recent", "ci", 1, 100),
+			},
+			bytesToFree: 100,
+			want:        []string{"first"},
+		},
+		{
+			name:        "expired items are always evicted",
+			items:       []Item{expired, idle("old", "ci", 8, 100)},
+			bytesToFree: 0,
+			want:        []string{"expired"},
+		},
+		{
+			name:        "expired bytes count towards the deficit",
+			items:       []Item{expired, idle("old", "ci", 8, 100)},
+			bytesToFree: 100,
+			want:        []string{"expired"},
+		},
+		{
+			name: "deficit shared by evictable bytes",
+			items: []Item{
+				idle("ci-1h", "ci", 1, 100),
+				idle("ci-2h", "ci", 2, 100),
+				idle("ci-4h", "ci", 4, 100),
+				idle("dev-1h", "dev", 1, 100),
+			},
+			bytesToFree: 200,
+			// ci holds 3/4 of the bytes and frees 150, dev frees 50
+			want: []string{"ci-2h", "ci-4h", "dev-1h"},
+		},
+		{
+			name:        "more to free than evictable",
+			items:       []Item{idle("a", "ci", 1, 100), idle("b", "dev", 2, 100)},
+			bytesToFree: 1000,
+			want:        []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := streamSelect(t, lru, tt.items, tt.bytesToFree)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimatorMerge(t *testing.T) {
+	lru, err := NewPolicy(StrategyLRU, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []Item{
+		idle("1h", "ci", 1, 100),
+		idle("2h", "ci", 2, 100),
+		idle("4h", "dev", 4, 100),
+		idle("8h", "dev", 8, 100),
+	}
+
+	whole := NewEstimator(streamNow)
+	for _, item := range items {
+		whole.Add(lru, item)
+	}
+
+	merged := NewEstimator(streamNow)
+	for i, part := range [][]Item{items[:1], items[1:3], items[3:]} {
+		e := NewEstimator(streamNow)
+		for _, item := range part {
+			e.Add(lru, item)
+		}
+		merged.Merge(e)
+		if i == 0 && len(merged.Instances) != 1 {
+			t.Fatalf("merged %d instances, want 1", len(merged.Instances))
+		}
+	}
+
+	if !reflect.DeepEqual(merged.Instances, whole.Instances) {
+		t.Errorf("merged histograms %+v, want %+v", merged.Instances, whole.Instances)
+	}
+	if got, want := lru.Cutoffs(merged, 150), lru.Cutoffs(whole, 150); !reflect.DeepEqual(got, want) {
+		t.Errorf("cutoffs of merged histograms %+v, want %+v", got, want)
+	}
+}
+
+func TestSelectorStateResumes(t *testing.T) {
+	lru, err := NewPolicy(StrategyLRU, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []Item{
+		idle("first", "ci", 4, 100),
+		idle("second", "ci", 4, 100),
+	}
+
+	e := NewEstimator(streamNow)
+	for _, item := range items {
+		e.Add(lru, item)
+	}
+	selector := lru.NewSelector(lru.Cutoffs(e, 100))
+	if _, ok := selector.Decide(items[0]); !ok {
+		t.Fatal("first item of the boundary bucket was not selected")
+	}
+
+	// A checkpoint taken mid-pass keeps the spent budget
+	data, err := json.Marshal(selector.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state SelectorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lru.NewSelector(state).Decide(items[1]); ok {
+		t.Error("resumed selector exceeded the budget of the boundary bucket")
+	}
+}
@@ -1,40 +1,138 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"regexp"
+	"strconv"
 	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/ruslanbaba/distributed-build-cache/internal/accesstrace"
+	"github.com/ruslanbaba/distributed-build-cache/internal/bandwidth"
 	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/internal/determinism"
+	"github.com/ruslanbaba/distributed-build-cache/internal/invocation"
 	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+	"github.com/ruslanbaba/distributed-build-cache/internal/observability"
+	"github.com/ruslanbaba/distributed-build-cache/internal/provenance"
+	"github.com/ruslanbaba/distributed-build-cache/internal/security"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
 )
 
 // CacheServer implements the BuildCacheService gRPC interface
 type CacheServer struct {
 	UnimplementedBuildCacheServiceServer
-	cache   *cache.Service
-	logger  *zap.Logger
-	metrics *metrics.Collector
+	cache    *cache.Service
+	logger   *zap.Logger
+	metrics  *metrics.Collector
+	shaper   *bandwidth.Shaper
+	trust    *TrustPolicy
+	signer   *provenance.Signer
+	detector *determinism.Detector
+
+	secrets     *security.SecretScanner
+	secretAudit *security.AuditLogger
+
+	trace       *accesstrace.Recorder
+	invocations *invocation.Tracker
+
+	slos      *observability.ObservabilityStack
+	freshness *freshnessSampler
+}
+
+const (
+	// actionResultContentType marks serialized ActionResult entries
+	actionResultContentType = "application/x-protobuf; message=ActionResult"
+	// maxActionResultSize bounds how much of an AC entry is read into memory
+	maxActionResultSize = 64 * 1024 * 1024
+
+	// Object metadata recording who wrote an action cache entry
+	metadataWriter         = "writer"
+	metadataWriterVerified = "writer_verified"
+	metadataTrust          = "trust"
+)
+
+// sha256Hex matches digests the server can verify on upload
+var sha256Hex = regexp.MustCompile(`^[a-fA-F0-9]{64}$`)
+
+// DigestMismatchError is returned when uploaded content does not match its digest
+type DigestMismatchError struct {
+	Key string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("uploaded content does not match digest for %s", e.Key)
+}
+
+// GRPCStatus maps the error to InvalidArgument
+func (e *DigestMismatchError) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, e.Error())
+}
+
+// Option configures optional CacheServer components
+type Option func(*CacheServer)
+
+// WithBandwidthShaper applies byte-rate limits to Get and Put streams
+func WithBandwidthShaper(shaper *bandwidth.Shaper) Option {
+	return func(s *CacheServer) {
+		s.shaper = shaper
+	}
+}
+
+// WithTrustPolicy restricts shared action cache writes to trusted identities
+func WithTrustPolicy(policy *TrustPolicy) Option {
+	return func(s *CacheServer) {
+		s.trust = policy
+	}
+}
+
+// WithProvenanceSigner signs every stored action result
+func WithProvenanceSigner(signer *provenance.Signer) Option {
+	return func(s *CacheServer) {
+		s.signer = signer
+	}
+}
+
+// WithNondeterminismDetector tracks ActionResult history to flag nondeterministic actions
+func WithNondeterminismDetector(detector *determinism.Detector) Option {
+	return func(s *CacheServer) {
+		s.detector = detector
+	}
+}
+
+// WithAccessTrace records every Get, Put and Contains for replay by cmd/cache-sim
+func WithAccessTrace(recorder *accesstrace.Recorder) Option {
+	return func(s *CacheServer) {
+		s.trace = recorder
+	}
 }
 
 // NewCacheServer creates a new cache server
-func NewCacheServer(cache *cache.Service, logger *zap.Logger) *CacheServer {
-	return &CacheServer{
-		cache:  cache,
-		logger: logger,
+func NewCacheServer(cache *cache.Service, logger *zap.Logger, metrics *metrics.Collector, opts ...Option) *CacheServer {
+	s := &CacheServer{
+		cache:   cache,
+		logger:  logger,
+		metrics: metrics,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Get retrieves a cached artifact
 func (s *CacheServer) Get(req *GetRequest, stream BuildCacheService_GetServer) error {
 	start := time.Now()
 	defer func() {
-		s.metrics.GRPCRequestDuration.WithLabelValues("Get").Observe(time.Since(start).Seconds())
+		metrics.ObserveWithTrace(stream.Context(), s.metrics.GRPCRequestDuration.WithLabelValues("Get"), time.Since(start).Seconds())
 	}()
 
 	if req.Digest == nil {
@@ -53,21 +151,36 @@ func (s *CacheServer) Get(req *GetRequest, stream BuildCacheService_GetServer) e
 
 	// Retrieve from cache
 	reader, entry, err := s.cache.Get(stream.Context(), key)
+	if cache.IsNotFound(err) {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Get", "not_found").Inc()
+		s.trace.Record(accesstrace.Record{Op: accesstrace.OpGet, Key: key, Size: req.Digest.SizeBytes})
+		s.invocations.Record(stream.Context(), invocation.KindBlob, false)
+		return status.Error(codes.NotFound, "cache miss")
+	}
 	if err != nil {
 		s.logger.Error("Failed to get cache entry",
 			zap.String("key", key),
 			zap.Error(err),
 		)
 		s.metrics.GRPCRequestsTotal.WithLabelValues("Get", "error").Inc()
-		return status.Error(codes.NotFound, "cache miss")
+		return status.Error(codes.Unavailable, "failed to read cache entry")
 	}
 	defer reader.Close()
+	s.trace.Record(accesstrace.Record{Op: accesstrace.OpGet, Key: key, Size: entry.Size, Hit: true})
+	s.invocations.Record(stream.Context(), invocation.KindBlob, true)
+
+	client := clientKey(stream.Context())
 
 	// Stream the data back to client
 	buffer := make([]byte, 64*1024) // 64KB chunks
 	for {
 		n, err := reader.Read(buffer)
 		if n > 0 {
+			if err := s.shaper.Wait(stream.Context(), bandwidth.Download, req.InstanceName, client, n); err != nil {
+				s.metrics.GRPCRequestsTotal.WithLabelValues("Get", "cancelled").Inc()
+				return status.FromContextError(err).Err()
+			}
+
 			response := &GetResponse{
 				Data: buffer[:n],
 				Digest: &Digest{
@@ -112,7 +225,7 @@ func (s *CacheServer) Get(req *GetRequest, stream BuildCacheService_GetServer) e
 func (s *CacheServer) Put(stream BuildCacheService_PutServer) error {
 	start := time.Now()
 	defer func() {
-		s.metrics.GRPCRequestDuration.WithLabelValues("Put").Observe(time.Since(start).Seconds())
+		metrics.ObserveWithTrace(stream.Context(), s.metrics.GRPCRequestDuration.WithLabelValues("Put"), time.Since(start).Seconds())
 	}()
 
 	// Receive first message with metadata
@@ -132,6 +245,10 @@ func (s *CacheServer) Put(stream BuildCacheService_PutServer) error {
 		s.metrics.GRPCRequestsTotal.WithLabelValues("Put", "invalid_request").Inc()
 		return status.Error(codes.InvalidArgument, "digest is required")
 	}
+	if metadata.TtlSeconds < 0 {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Put", "invalid_request").Inc()
+		return status.Error(codes.InvalidArgument, "ttl_seconds cannot be negative")
+	}
 
 	s.logger.Debug("Put request", 
 		zap.String("hash", metadata.Digest.Hash),
@@ -145,43 +262,63 @@ func (s *CacheServer) Put(stream BuildCacheService_PutServer) error {
 
 	// Create a pipe to stream data to cache service
 	pr, pw := io.Pipe()
-	
+	identity := IdentityFromContext(stream.Context())
+
 	// Start goroutine to write to pipe
 	errChan := make(chan error, 1)
 	go func() {
-		defer pw.Close()
-		
-		// Write first chunk if present
-		if len(req.Data) > 0 {
-			if _, err := pw.Write(req.Data); err != nil {
-				errChan <- err
-				return
-			}
+		err := s.receiveChunks(stream, pw, req.Data, metadata.InstanceName, clientKey(stream.Context()))
+		// A nil error closes the pipe with EOF; anything else aborts the upload
+		pw.CloseWithError(err)
+		errChan <- err
+	}()
+
+	// Small blobs are buffered and scanned for secrets before they are stored
+	var body io.Reader = pr
+	if s.secrets != nil && metadata.Digest.SizeBytes <= s.secrets.MaxBlobScanBytes() {
+		limit := s.secrets.MaxBlobScanBytes()
+		buf, err := io.ReadAll(io.LimitReader(pr, limit+1))
+		if err != nil {
+			pr.CloseWithError(err)
+			s.metrics.GRPCRequestsTotal.WithLabelValues("Put", "stream_error").Inc()
+			return status.Error(codes.Internal, "failed to stream data")
 		}
 
-		// Read remaining chunks
-		for {
-			req, err := stream.Recv()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				errChan <- err
-				return
+		if int64(len(buf)) <= limit {
+			if err := s.scanBlob(stream.Context(), key, buf, identity); err != nil {
+				pr.CloseWithError(err)
+				s.metrics.GRPCRequestsTotal.WithLabelValues("Put", "secret_rejected").Inc()
+				return err
 			}
+			body = bytes.NewReader(buf)
+		} else {
+			// The declared size was wrong; store the blob unscanned
+			body = io.MultiReader(bytes.NewReader(buf), pr)
+		}
+	}
 
-			if len(req.Data) > 0 {
-				if _, err := pw.Write(req.Data); err != nil {
-					errChan <- err
-					return
-				}
-			}
+	// Store in cache, verifying content against SHA-256 digests
+	opts := cache.PutOptions{
+		ContentType: metadata.ContentType,
+		TTL:         time.Duration(metadata.TtlSeconds) * time.Second,
+	}
+	if isSHA256Hex(metadata.Digest.Hash) {
+		opts.ExpectedHash = metadata.Digest.Hash
+	}
+	if err := s.cache.PutWithOptions(stream.Context(), key, body, opts); err != nil {
+		// Unblock the receiving goroutine if storage gave up early
+		pr.CloseWithError(err)
+
+		if errors.Is(err, cache.ErrDigestMismatch) {
+			s.logger.Warn("Rejected upload with mismatched digest",
+				zap.String("key", key),
+				zap.String("client", identity.Name),
+				zap.Error(err),
+			)
+			s.metrics.GRPCRequestsTotal.WithLabelValues("Put", "digest_mismatch").Inc()
+			return &DigestMismatchError{Key: key}
 		}
-		errChan <- nil
-	}()
 
-	// Store in cache
-	if err := s.cache.Put(stream.Context(), key, pr, metadata.ContentType); err != nil {
 		s.logger.Error("Failed to store cache entry",
 			zap.String("key", key),
 			zap.Error(err),
@@ -216,6 +353,12 @@ func (s *CacheServer) Put(stream BuildCacheService_PutServer) error {
 	}
 
 	s.metrics.GRPCRequestsTotal.WithLabelValues("Put", "success").Inc()
+	s.trace.Record(accesstrace.Record{
+		Op:         accesstrace.OpPut,
+		Key:        key,
+		Size:       metadata.Digest.SizeBytes,
+		TTLSeconds: metadata.TtlSeconds,
+	})
 	s.logger.Debug("Put completed successfully",
 		zap.String("key", key),
 		zap.Int64("size", metadata.Digest.SizeBytes),
@@ -224,11 +367,51 @@ func (s *CacheServer) Put(stream BuildCacheService_PutServer) error {
 	return nil
 }
 
+// isSHA256Hex reports whether a digest hash can be verified on upload
+func isSHA256Hex(hash string) bool {
+	return sha256Hex.MatchString(hash)
+}
+
+// receiveChunks copies the Put stream into the pipe, applying upload limits
+func (s *CacheServer) receiveChunks(stream BuildCacheService_PutServer, pw *io.PipeWriter, first []byte, instance, client string) error {
+	ctx := stream.Context()
+
+	// Write first chunk if present
+	if len(first) > 0 {
+		if err := s.shaper.Wait(ctx, bandwidth.Upload, instance, client, len(first)); err != nil {
+			return err
+		}
+		if _, err := pw.Write(first); err != nil {
+			return err
+		}
+	}
+
+	// Read remaining chunks
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(req.Data) > 0 {
+			if err := s.shaper.Wait(ctx, bandwidth.Upload, instance, client, len(req.Data)); err != nil {
+				return err
+			}
+			if _, err := pw.Write(req.Data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 // Contains checks if artifacts exist in the cache
 func (s *CacheServer) Contains(ctx context.Context, req *ContainsRequest) (*ContainsResponse, error) {
 	start := time.Now()
 	defer func() {
-		s.metrics.GRPCRequestDuration.WithLabelValues("Contains").Observe(time.Since(start).Seconds())
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("Contains"), time.Since(start).Seconds())
 	}()
 
 	if len(req.Digests) == 0 {
@@ -243,12 +426,21 @@ func (s *CacheServer) Contains(ctx context.Context, req *ContainsRequest) (*Cont
 
 	var results []*ContentAddressableStorageStatus
 
+	now := time.Now()
 	for _, digest := range req.Digests {
 		key := fmt.Sprintf("%s/%s", req.InstanceName, digest.Hash)
 		
-		// Check if entry exists (lightweight operation)
-		_, _, err := s.cache.Get(ctx, key)
+		// Existence checks only read metadata, so they neither count as an
+		// access nor restore trashed entries. Clients check before uploading,
+		// so absent entries are not invocation misses either.
+		entry, err := s.cache.Stat(ctx, key)
 		exists := err == nil
+		if exists {
+			if expiresAt := eviction.ExpiresAt(entry.Metadata); !expiresAt.IsZero() && !now.Before(expiresAt) {
+				exists = false
+			}
+		}
+		s.trace.Record(accesstrace.Record{Op: accesstrace.OpContains, Key: key, Size: digest.SizeBytes, Hit: exists})
 
 		status := &ContentAddressableStorageStatus{
 			Digest: digest,
@@ -274,7 +466,7 @@ func (s *CacheServer) Contains(ctx context.Context, req *ContainsRequest) (*Cont
 func (s *CacheServer) GetActionResult(ctx context.Context, req *GetActionResultRequest) (*ActionResult, error) {
 	start := time.Now()
 	defer func() {
-		s.metrics.GRPCRequestDuration.WithLabelValues("GetActionResult").Observe(time.Since(start).Seconds())
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("GetActionResult"), time.Since(start).Seconds())
 	}()
 
 	if req.ActionDigest == nil {
@@ -282,16 +474,42 @@ func (s *CacheServer) GetActionResult(ctx context.Context, req *GetActionResultR
 		return nil, status.Error(codes.InvalidArgument, "action digest is required")
 	}
 
-	key := fmt.Sprintf("%s/action_result/%s", req.InstanceName, req.ActionDigest.Hash)
-	
+	identity := IdentityFromContext(ctx)
+
 	s.logger.Debug("GetActionResult request", 
 		zap.String("hash", req.ActionDigest.Hash),
 		zap.String("instance", req.InstanceName),
+		zap.String("identity", identity.Name),
 	)
 
-	// Implementation would deserialize ActionResult from cache
-	// For now, return not found
+	for _, key := range s.readableActionResultKeys(req.InstanceName, req.ActionDigest.Hash, identity) {
+		result, entry, err := s.readActionResult(ctx, key)
+		if cache.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			s.logger.Error("Failed to read action result",
+				zap.String("key", key),
+				zap.Error(err),
+			)
+			s.metrics.GRPCRequestsTotal.WithLabelValues("GetActionResult", "error").Inc()
+			return nil, status.Error(codes.Internal, "failed to read action result")
+		}
+
+		s.logger.Debug("GetActionResult hit",
+			zap.String("key", key),
+			zap.String("writer", entry.Metadata[metadataWriter]),
+		)
+		s.trace.Record(accesstrace.Record{Op: accesstrace.OpGet, Key: key, Size: entry.Size, Hit: true})
+		s.invocations.Record(ctx, invocation.KindAction, true)
+		s.sampleFreshness(req.InstanceName, result)
+		s.metrics.GRPCRequestsTotal.WithLabelValues("GetActionResult", "success").Inc()
+		return result, nil
+	}
+
 	s.metrics.GRPCRequestsTotal.WithLabelValues("GetActionResult", "not_found").Inc()
+	s.trace.Record(accesstrace.Record{Op: accesstrace.OpGet, Key: actionResultKey(req.InstanceName, req.ActionDigest.Hash)})
+	s.invocations.Record(ctx, invocation.KindAction, false)
 	return nil, status.Error(codes.NotFound, "action result not found")
 }
 
@@ -299,23 +517,109 @@ func (s *CacheServer) GetActionResult(ctx context.Context, req *GetActionResultR
 func (s *CacheServer) UpdateActionResult(ctx context.Context, req *UpdateActionResultRequest) (*UpdateActionResultResponse, error) {
 	start := time.Now()
 	defer func() {
-		s.metrics.GRPCRequestDuration.WithLabelValues("UpdateActionResult").Observe(time.Since(start).Seconds())
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("UpdateActionResult"), time.Since(start).Seconds())
 	}()
 
 	if req.ActionDigest == nil {
 		s.metrics.GRPCRequestsTotal.WithLabelValues("UpdateActionResult", "invalid_request").Inc()
 		return nil, status.Error(codes.InvalidArgument, "action digest is required")
 	}
+	if req.ActionResult == nil {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("UpdateActionResult", "invalid_request").Inc()
+		return nil, status.Error(codes.InvalidArgument, "action result is required")
+	}
+	if req.TtlSeconds < 0 {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("UpdateActionResult", "invalid_request").Inc()
+		return nil, status.Error(codes.InvalidArgument, "ttl_seconds cannot be negative")
+	}
 
-	key := fmt.Sprintf("%s/action_result/%s", req.InstanceName, req.ActionDigest.Hash)
+	identity := IdentityFromContext(ctx)
+	trusted := s.trust.IsTrusted(identity)
+
+	// Untrusted writers can only populate their private overlay, and only a
+	// verified identity has one, as the name of an unverified one is spoofable
+	key := actionResultKey(req.InstanceName, req.ActionDigest.Hash)
+	trustLevel := "trusted"
+	if !trusted {
+		if !identity.Verified {
+			s.metrics.GRPCRequestsTotal.WithLabelValues("UpdateActionResult", "untrusted_rejected").Inc()
+			return nil, status.Error(codes.PermissionDenied, "untrusted writers need a verified client certificate to update the action cache")
+		}
+		key = overlayActionResultKey(req.InstanceName, identity.Name, req.ActionDigest.Hash)
+		trustLevel = "untrusted"
+	}
 	
 	s.logger.Debug("UpdateActionResult request", 
 		zap.String("hash", req.ActionDigest.Hash),
 		zap.String("instance", req.InstanceName),
+		zap.String("writer", identity.Name),
+		zap.String("trust", trustLevel),
 	)
 
-	// Implementation would serialize and store ActionResult
-	// For now, return success
+	// Scan build logs before anything derived from them is stored or signed
+	if s.secrets != nil {
+		if err := s.scanActionResult(ctx, key, req.ActionResult, identity); err != nil {
+			s.metrics.GRPCRequestsTotal.WithLabelValues("UpdateActionResult", "secret_rejected").Inc()
+			return nil, err
+		}
+	}
+
+	// Deterministic encoding keeps the signed ActionResult digest reproducible
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(req.ActionResult)
+	if err != nil {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("UpdateActionResult", "invalid_request").Inc()
+		return nil, status.Error(codes.InvalidArgument, "failed to encode action result")
+	}
+
+	metadata := map[string]string{
+		metadataWriter:         identity.Name,
+		metadataWriterVerified: strconv.FormatBool(identity.Verified),
+		metadataTrust:          trustLevel,
+	}
+
+	// Store the attestation first so a signed entry never lacks its provenance
+	if s.signer != nil {
+		if err := s.storeProvenance(ctx, key, req, data, identity, metadata); err != nil {
+			s.logger.Error("Failed to store action result provenance",
+				zap.String("key", key),
+				zap.Error(err),
+			)
+			s.metrics.GRPCRequestsTotal.WithLabelValues("UpdateActionResult", "provenance_error").Inc()
+			return nil, status.Error(codes.Internal, "failed to sign action result")
+		}
+	}
+
+	err = s.cache.PutWithOptions(ctx, key, bytes.NewReader(data), cache.PutOptions{
+		ContentType: actionResultContentType,
+		Metadata:    metadata,
+		TTL:         time.Duration(req.TtlSeconds) * time.Second,
+	})
+	if err != nil {
+		s.logger.Error("Failed to store action result",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		s.metrics.GRPCRequestsTotal.WithLabelValues("UpdateActionResult", "storage_error").Inc()
+		return nil, status.Error(codes.Internal, "failed to store action result")
+	}
+
+	// History tracking is best effort and never fails the write
+	if s.detector != nil {
+		if _, err := s.detector.Record(ctx, req.InstanceName, req.ActionDigest.Hash, req.ActionDigest.SizeBytes, actionResultVersion(req.ActionResult, identity)); err != nil {
+			s.logger.Warn("Failed to record action result history",
+				zap.String("hash", req.ActionDigest.Hash),
+				zap.Error(err),
+			)
+		}
+	}
+
+	s.trace.Record(accesstrace.Record{
+		Op:         accesstrace.OpPut,
+		Key:        key,
+		Size:       int64(len(data)),
+		TTLSeconds: req.TtlSeconds,
+	})
+
 	response := &UpdateActionResultResponse{
 		Success: true,
 	}
@@ -323,3 +627,27 @@ func (s *CacheServer) UpdateActionResult(ctx context.Context, req *UpdateActionR
 	s.metrics.GRPCRequestsTotal.WithLabelValues("UpdateActionResult", "success").Inc()
 	return response, nil
 }
+
+// readActionResult loads and decodes a stored ActionResult
+func (s *CacheServer) readActionResult(ctx context.Context, key string) (*ActionResult, *cache.CacheEntry, error) {
+	reader, entry, err := s.cache.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxActionResultSize+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read action result: %w", err)
+	}
+	if len(data) > maxActionResultSize {
+		return nil, nil, fmt.Errorf("action result exceeds %d bytes", maxActionResultSize)
+	}
+
+	result := &ActionResult{}
+	if err := proto.Unmarshal(data, result); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode action result: %w", err)
+	}
+
+	return result, entry, nil
+}
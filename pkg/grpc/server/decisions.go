@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+)
+
+// Entry states reported by WhyMissing
+const (
+	entryPresent       = "present"
+	entryPruned        = "pruned"
+	entryNeverUploaded = "never_uploaded"
+)
+
+// WhyMissing looks a digest up as a CAS blob and as an action, reporting
+// for each whether it is cached and the deletions the pruners recorded
+func (s *CacheServer) WhyMissing(ctx context.Context, req *WhyMissingRequest) (*WhyMissingResponse, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("WhyMissing"), time.Since(start).Seconds())
+	}()
+
+	if req.Digest.GetHash() == "" {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("WhyMissing", "invalid_request").Inc()
+		return nil, status.Error(codes.InvalidArgument, "digest hash is required")
+	}
+
+	hash := req.Digest.Hash
+	type entry struct{ key, kind string }
+	entries := []entry{{req.InstanceName + "/" + hash, "cas"}}
+	for _, key := range s.readableActionResultKeys(req.InstanceName, hash, IdentityFromContext(ctx)) {
+		entries = append(entries, entry{key, "action_result"})
+	}
+
+	response := &WhyMissingResponse{}
+	for _, e := range entries {
+		history, err := s.entryHistory(ctx, e.key, e.kind)
+		if err != nil {
+			s.logger.Error("Failed to look up cache entry history", zap.String("key", e.key), zap.Error(err))
+			s.metrics.GRPCRequestsTotal.WithLabelValues("WhyMissing", "error").Inc()
+			return nil, status.Error(codes.Internal, "failed to look up entry history")
+		}
+		response.Entries = append(response.Entries, history)
+	}
+
+	s.metrics.GRPCRequestsTotal.WithLabelValues("WhyMissing", "success").Inc()
+	return response, nil
+}
+
+// entryHistory reports whether key is cached and its recorded deletions
+func (s *CacheServer) entryHistory(ctx context.Context, key, kind string) (*EntryHistory, error) {
+	history := &EntryHistory{Key: key, Kind: kind, State: entryNeverUploaded}
+
+	decisions, err := s.cache.Decisions(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range decisions {
+		history.Decisions = append(history.Decisions, &PruneDecision{
+			SizeBytes:    d.Size,
+			LastAccessed: d.LastAccessed.UnixNano(),
+			Reason:       d.Reason,
+			Pruner:       d.Pruner,
+			RunId:        d.RunID,
+			DeletedAt:    d.DeletedAt.UnixNano(),
+			Trashed:      d.Trashed,
+		})
+	}
+	if len(decisions) > 0 {
+		history.State = entryPruned
+	}
+
+	entry, err := s.cache.Stat(ctx, key)
+	switch {
+	case cache.IsNotFound(err):
+		return history, nil
+	case err != nil:
+		return nil, err
+	}
+	history.State = entryPresent
+	history.SizeBytes = entry.Size
+	history.LastAccessed = entry.LastAccessed.UnixNano()
+	return history, nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/determinism"
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+)
+
+// actionResultVersion reduces an ActionResult to the outputs compared between writes
+func actionResultVersion(result *ActionResult, identity Identity) determinism.Version {
+	outputs := make(map[string]string, len(result.OutputFiles)+len(result.OutputDirectories))
+	for _, file := range result.OutputFiles {
+		outputs[file.Path] = file.Digest.GetHash()
+	}
+	for _, dir := range result.OutputDirectories {
+		outputs[dir.Path] = dir.TreeDigest.GetHash()
+	}
+
+	return determinism.Version{
+		Writer:    identity.Name,
+		Timestamp: time.Now(),
+		ExitCode:  result.ExitCode,
+		Outputs:   outputs,
+	}
+}
+
+// GetNondeterminismReport lists actions whose outputs differed between writes
+func (s *CacheServer) GetNondeterminismReport(ctx context.Context, req *NondeterminismReportRequest) (*NondeterminismReport, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("GetNondeterminismReport"), time.Since(start).Seconds())
+	}()
+
+	if s.detector == nil {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("GetNondeterminismReport", "unavailable").Inc()
+		return nil, status.Error(codes.FailedPrecondition, "nondeterminism detection is disabled")
+	}
+
+	findings, err := s.detector.Findings(ctx, req.InstanceName, int(req.MaxResults))
+	if err != nil {
+		s.logger.Error("Failed to load nondeterminism findings",
+			zap.String("instance", req.InstanceName),
+			zap.Error(err),
+		)
+		s.metrics.GRPCRequestsTotal.WithLabelValues("GetNondeterminismReport", "error").Inc()
+		return nil, status.Error(codes.Internal, "failed to load findings")
+	}
+
+	report := &NondeterminismReport{
+		Actions: make([]*NondeterministicAction, 0, len(findings)),
+	}
+	for _, finding := range findings {
+		report.Actions = append(report.Actions, &NondeterministicAction{
+			ActionDigest: &Digest{
+				Hash:      finding.ActionDigest,
+				SizeBytes: finding.ActionSize,
+			},
+			DivergentPaths: finding.DivergentPaths,
+			Writers:        finding.Writers,
+			Occurrences:    int32(finding.Occurrences),
+			FirstSeen:      finding.FirstSeen.UnixNano(),
+			LastSeen:       finding.LastSeen.UnixNano(),
+		})
+	}
+
+	s.metrics.GRPCRequestsTotal.WithLabelValues("GetNondeterminismReport", "success").Inc()
+	return report, nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientIDHeader is the metadata key clients may use to identify themselves
+const ClientIDHeader = "x-cache-client-id"
+
+// Identity describes the caller of an RPC
+type Identity struct {
+	Name     string
+	Verified bool // true when taken from a verified mTLS client certificate
+}
+
+// IdentityFromContext resolves the caller identity. A verified client
+// certificate wins, then the x-cache-client-id header, then the peer host.
+func IdentityFromContext(ctx context.Context) Identity {
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			for _, chain := range tlsInfo.State.VerifiedChains {
+				if len(chain) > 0 && chain[0].Subject.CommonName != "" {
+					return Identity{Name: chain[0].Subject.CommonName, Verified: true}
+				}
+			}
+		}
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(ClientIDHeader); len(values) > 0 && values[0] != "" {
+			return Identity{Name: values[0]}
+		}
+	}
+
+	if host := peerHost(ctx); host != "" {
+		return Identity{Name: host}
+	}
+
+	return Identity{Name: "anonymous"}
+}
+
+// clientKey keys per-caller state: threat scores, quarantines and bandwidth
+// limits. Only a verified identity is trusted as a key; other callers are
+// keyed by their peer address in a separate namespace, so a spoofed
+// x-cache-client-id can neither frame a real client, nor shed a quarantine or
+// a rate limit by changing per request.
+func clientKey(ctx context.Context) string {
+	if identity := IdentityFromContext(ctx); identity.Verified {
+		return identity.Name
+	}
+	if host := peerHost(ctx); host != "" {
+		return "unverified:" + host
+	}
+	return "unverified:unknown"
+}
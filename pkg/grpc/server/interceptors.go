@@ -2,14 +2,32 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"time"
 
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+	"github.com/ruslanbaba/distributed-build-cache/internal/security"
 )
 
+// writeMethods lists the RPCs that mutate cache state
+var writeMethods = map[string]bool{
+	"/buildcache.BuildCacheService/Put":                true,
+	"/buildcache.BuildCacheService/UpdateActionResult": true,
+	"/buildcache.BuildCacheService/Pin":                true,
+	"/buildcache.BuildCacheService/Unpin":              true,
+}
+
 // UnaryLoggingInterceptor logs unary RPC calls
 func UnaryLoggingInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
@@ -29,11 +47,14 @@ func UnaryLoggingInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
 				zap.Duration("duration", duration),
 				zap.Error(err),
 				zap.String("code", status.Code(err).String()),
+				traceField(ctx),
+				requestMetadataField(ctx),
 			)
 		} else {
 			logger.Debug("Unary RPC completed",
 				zap.String("method", info.FullMethod),
 				zap.Duration("duration", duration),
+				requestMetadataField(ctx),
 			)
 		}
 
@@ -62,11 +83,14 @@ func StreamLoggingInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
 				zap.Duration("duration", duration),
 				zap.Error(err),
 				zap.String("code", status.Code(err).String()),
+				traceField(stream.Context()),
+				requestMetadataField(stream.Context()),
 			)
 		} else {
 			logger.Debug("Stream RPC completed",
 				zap.String("method", info.FullMethod),
 				zap.Duration("duration", duration),
+				requestMetadataField(stream.Context()),
 			)
 		}
 
@@ -74,6 +98,15 @@ func StreamLoggingInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
 	}
 }
 
+// traceField returns the trace ID of the RPC for its log lines, if it is traced
+func traceField(ctx context.Context) zap.Field {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsValid() {
+		return zap.Skip()
+	}
+	return zap.String("trace_id", spanCtx.TraceID().String())
+}
+
 // AuthInterceptor validates authentication
 func AuthInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
@@ -98,3 +131,226 @@ func RateLimitInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
 		return handler(ctx, req)
 	}
 }
+
+// UnaryAuditInterceptor records every write and every authorization failure
+// in the tamper-evident audit log
+func UnaryAuditInterceptor(audit *security.AuditLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		recordAudit(ctx, audit, info.FullMethod, auditResource(req), start, 0, err)
+
+		return resp, err
+	}
+}
+
+// StreamAuditInterceptor records every streamed write and every authorization
+// failure in the tamper-evident audit log
+func StreamAuditInterceptor(audit *security.AuditLogger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		wrapped := &auditServerStream{ServerStream: stream}
+
+		err := handler(srv, wrapped)
+
+		recordAudit(stream.Context(), audit, info.FullMethod, wrapped.resource, start, wrapped.bytesIn, err)
+
+		return err
+	}
+}
+
+// auditServerStream captures the resource and upload size of a client stream
+type auditServerStream struct {
+	grpc.ServerStream
+	resource string
+	bytesIn  int64
+}
+
+func (s *auditServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		if s.resource == "" {
+			s.resource = auditResource(m)
+		}
+		if req, ok := m.(*PutRequest); ok {
+			s.bytesIn += int64(len(req.Data))
+		}
+	}
+	return err
+}
+
+// recordAudit writes the request record and, for auth failures, a security event
+func recordAudit(ctx context.Context, audit *security.AuditLogger, method, resource string, start time.Time, bytesIn int64, err error) {
+	code := status.Code(err)
+	authFailure := code == codes.Unauthenticated || code == codes.PermissionDenied
+	if !writeMethods[method] && !authFailure {
+		return
+	}
+
+	identity := IdentityFromContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := uuid.New().String()
+
+	event := security.AuditEvent{
+		RequestID:  requestID,
+		Timestamp:  start,
+		Method:     method,
+		ClientIP:   peerHost(ctx),
+		Metadata:   md,
+		Duration:   time.Since(start),
+		StatusCode: int(code),
+		BytesIn:    bytesIn,
+		UserID:     identity.Name,
+		Resource:   resource,
+	}
+	if len(md.Get("user-agent")) > 0 {
+		event.UserAgent = md.Get("user-agent")[0]
+	}
+	if err != nil {
+		event.Error = status.Convert(err).Message()
+	}
+	audit.LogRequest(event)
+
+	if authFailure {
+		eventType := "authz_denied"
+		if code == codes.Unauthenticated {
+			eventType = "auth_failure"
+		}
+		audit.LogSecurityEvent(security.SecurityEvent{
+			Type:      eventType,
+			RequestID: requestID,
+			UserID:    identity.Name,
+			ClientIP:  event.ClientIP,
+			Resource:  resource,
+			Action:    method,
+			Reason:    status.Convert(err).Message(),
+			Severity:  "warning",
+		})
+	}
+}
+
+// auditResource names the cache entry a request targets
+func auditResource(req interface{}) string {
+	switch r := req.(type) {
+	case *PutRequest:
+		if r.Metadata != nil && r.Metadata.Digest != nil {
+			return fmt.Sprintf("%s/%s", r.Metadata.InstanceName, r.Metadata.Digest.Hash)
+		}
+	case *UpdateActionResultRequest:
+		if r.ActionDigest != nil {
+			return fmt.Sprintf("%s/action_result/%s", r.InstanceName, r.ActionDigest.Hash)
+		}
+	case *GetActionResultRequest:
+		if r.ActionDigest != nil {
+			return fmt.Sprintf("%s/action_result/%s", r.InstanceName, r.ActionDigest.Hash)
+		}
+	case *GetProvenanceRequest:
+		if r.ActionDigest != nil {
+			return fmt.Sprintf("%s/provenance/%s", r.InstanceName, r.ActionDigest.Hash)
+		}
+	case *GetRequest:
+		if r.Digest != nil {
+			return fmt.Sprintf("%s/%s", r.InstanceName, r.Digest.Hash)
+		}
+	case *ContainsRequest:
+		return r.InstanceName
+	case *PinRequest:
+		return r.InstanceName
+	case *UnpinRequest:
+		return fmt.Sprintf("%s/pins/%s", r.InstanceName, r.Id)
+	case *WhyMissingRequest:
+		return fmt.Sprintf("%s/%s", r.InstanceName, r.Digest.GetHash())
+	case *InvocationReportRequest:
+		return "invocation/" + r.InvocationId
+	}
+	return ""
+}
+
+// peerHost returns the remote host of the caller
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// UnaryThreatInterceptor blocks quarantined clients and feeds request outcomes
+// into the threat detector. Only reads answered with NotFound count as misses;
+// existence checks report absent entries as part of normal operation.
+func UnaryThreatInterceptor(detector *security.ThreatDetector, metrics *metrics.Collector) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		client := clientKey(ctx)
+		if err := admitClient(detector, metrics, client); err != nil {
+			return nil, err
+		}
+
+		resp, err := handler(ctx, req)
+
+		observeOutcome(detector, metrics, client, err)
+
+		return resp, err
+	}
+}
+
+// StreamThreatInterceptor blocks quarantined clients and feeds stream outcomes
+// into the threat detector
+func StreamThreatInterceptor(detector *security.ThreatDetector, metrics *metrics.Collector) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		client := clientKey(stream.Context())
+		if err := admitClient(detector, metrics, client); err != nil {
+			return err
+		}
+
+		err := handler(srv, stream)
+
+		observeOutcome(detector, metrics, client, err)
+
+		return err
+	}
+}
+
+// admitClient rejects quarantined clients and quarantines those whose score crosses the threshold
+func admitClient(detector *security.ThreatDetector, metrics *metrics.Collector, client string) error {
+	quarantine := detector.Quarantine()
+	if entry, blocked := quarantine.IsQuarantined(client); blocked {
+		metrics.BlockedRequests.WithLabelValues("quarantined").Inc()
+		return status.Errorf(codes.PermissionDenied, "client is quarantined until %s", entry.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+
+	analysis := observeSignal(detector, metrics, client, security.SignalRequest)
+	if analysis.RiskScore > detector.BlockScore() {
+		entry := quarantine.Quarantine(client, fmt.Sprintf("risk score %.2f: %v", analysis.RiskScore, analysis.Threats), analysis.RiskScore)
+		metrics.BlockedRequests.WithLabelValues("risk_score").Inc()
+		return status.Errorf(codes.PermissionDenied, "client is quarantined until %s", entry.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// observeOutcome maps a handler result onto risk signals
+func observeOutcome(detector *security.ThreatDetector, metrics *metrics.Collector, client string, err error) {
+	if err == nil {
+		return
+	}
+
+	var mismatch *DigestMismatchError
+	switch {
+	case errors.As(err, &mismatch):
+		observeSignal(detector, metrics, client, security.SignalDigestMismatch)
+	case status.Code(err) == codes.NotFound:
+		observeSignal(detector, metrics, client, security.SignalMiss)
+	case status.Code(err) == codes.Unauthenticated, status.Code(err) == codes.PermissionDenied:
+		observeSignal(detector, metrics, client, security.SignalAuthFailure)
+	}
+}
+
+func observeSignal(detector *security.ThreatDetector, metrics *metrics.Collector, client string, signal security.Signal) security.ThreatAnalysis {
+	metrics.ThreatSignals.WithLabelValues(signal.String()).Inc()
+	return detector.Observe(client, signal)
+}
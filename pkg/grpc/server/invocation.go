@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/invocation"
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+)
+
+// invocationID matches Bazel invocation IDs, which are UUIDs
+var invocationID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// WithInvocationTracker counts hits and misses per Bazel invocation, target and mnemonic
+func WithInvocationTracker(tracker *invocation.Tracker) Option {
+	return func(s *CacheServer) {
+		s.invocations = tracker
+	}
+}
+
+// UnaryRequestMetadataInterceptor tags the RPC span with the Bazel
+// RequestMetadata of the call
+func UnaryRequestMetadataInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		tagSpan(ctx)
+		return handler(ctx, req)
+	}
+}
+
+// StreamRequestMetadataInterceptor tags the RPC span with the Bazel
+// RequestMetadata of the call
+func StreamRequestMetadataInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		tagSpan(stream.Context())
+		return handler(srv, stream)
+	}
+}
+
+func tagSpan(ctx context.Context) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	md, ok := invocation.FromContext(ctx)
+	if !ok {
+		return
+	}
+	span.SetAttributes(
+		attribute.String("bazel.invocation_id", md.InvocationID),
+		attribute.String("bazel.correlated_invocations_id", md.CorrelatedInvocationsID),
+		attribute.String("bazel.action_id", md.ActionID),
+		attribute.String("bazel.mnemonic", md.Mnemonic),
+		attribute.String("bazel.target_id", md.TargetID),
+	)
+}
+
+// requestMetadataField returns the Bazel RequestMetadata of the call for its
+// log lines, if it was sent
+func requestMetadataField(ctx context.Context) zap.Field {
+	md, ok := invocation.FromContext(ctx)
+	if !ok {
+		return zap.Skip()
+	}
+	return zap.Object("bazel", requestMetadataLog(md))
+}
+
+type requestMetadataLog invocation.Metadata
+
+func (m requestMetadataLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("invocation_id", m.InvocationID)
+	if m.CorrelatedInvocationsID != "" {
+		enc.AddString("correlated_invocations_id", m.CorrelatedInvocationsID)
+	}
+	if m.ActionID != "" {
+		enc.AddString("action_id", m.ActionID)
+	}
+	if m.Mnemonic != "" {
+		enc.AddString("mnemonic", m.Mnemonic)
+	}
+	if m.TargetID != "" {
+		enc.AddString("target_id", m.TargetID)
+	}
+	return nil
+}
+
+// GetInvocationReport returns the cache hits and misses of a build invocation
+func (s *CacheServer) GetInvocationReport(ctx context.Context, req *InvocationReportRequest) (*InvocationReport, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("GetInvocationReport"), time.Since(start).Seconds())
+	}()
+
+	if s.invocations == nil {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("GetInvocationReport", "unavailable").Inc()
+		return nil, status.Error(codes.FailedPrecondition, "invocation tracking is disabled")
+	}
+	if !invocationID.MatchString(req.InvocationId) {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("GetInvocationReport", "invalid_request").Inc()
+		return nil, status.Error(codes.InvalidArgument, "invalid invocation id")
+	}
+
+	report, err := s.invocations.Report(ctx, req.InvocationId)
+	if errors.Is(err, invocation.ErrNotFound) {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("GetInvocationReport", "not_found").Inc()
+		return nil, status.Error(codes.NotFound, "no calls recorded for invocation")
+	}
+	if err != nil {
+		s.logger.Error("Failed to load invocation report",
+			zap.String("invocation", req.InvocationId),
+			zap.Error(err),
+		)
+		s.metrics.GRPCRequestsTotal.WithLabelValues("GetInvocationReport", "error").Inc()
+		return nil, status.Error(codes.Internal, "failed to load invocation report")
+	}
+
+	s.metrics.GRPCRequestsTotal.WithLabelValues("GetInvocationReport", "success").Inc()
+	return &InvocationReport{
+		InvocationId:            report.InvocationID,
+		CorrelatedInvocationsId: report.CorrelatedInvocationsID,
+		ToolName:                report.ToolName,
+		ToolVersion:             report.ToolVersion,
+		FirstSeen:               report.FirstSeen.UnixNano(),
+		LastSeen:                report.LastSeen.UnixNano(),
+		Totals:                  cacheCounts(&report.Totals),
+		Targets:                 cacheBreakdown(report.Targets, int(req.MaxResults), req.MissesOnly),
+		Mnemonics:               cacheBreakdown(report.Mnemonics, int(req.MaxResults), req.MissesOnly),
+	}, nil
+}
+
+// cacheBreakdown orders targets or mnemonics by action cache misses, then
+// by blob misses
+func cacheBreakdown(counts map[string]*invocation.Counts, limit int, missesOnly bool) []*CacheBreakdown {
+	out := make([]*CacheBreakdown, 0, len(counts))
+	for name, c := range counts {
+		if missesOnly && c.ActionMisses == 0 && c.BlobMisses == 0 {
+			continue
+		}
+		out = append(out, &CacheBreakdown{Name: name, Counts: cacheCounts(c)})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].Counts, out[j].Counts
+		if a.ActionMisses != b.ActionMisses {
+			return a.ActionMisses > b.ActionMisses
+		}
+		if a.BlobMisses != b.BlobMisses {
+			return a.BlobMisses > b.BlobMisses
+		}
+		return out[i].Name < out[j].Name
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func cacheCounts(c *invocation.Counts) *CacheCounts {
+	return &CacheCounts{
+		ActionHits:   c.ActionHits,
+		ActionMisses: c.ActionMisses,
+		BlobHits:     c.BlobHits,
+		BlobMisses:   c.BlobMisses,
+	}
+}
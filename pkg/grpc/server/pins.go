@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+// maxTreeSize bounds how much of an output directory tree is read when
+// resolving what an action result references
+const maxTreeSize = 64 * 1024 * 1024
+
+// pinID matches identifiers generated by newPinID
+var pinID = regexp.MustCompile(`^[a-f0-9]{16}$`)
+
+// Pin exempts cache entries from pruning. Only identities trusted to write the
+// shared action cache may pin, since pins hold storage for everyone.
+func (s *CacheServer) Pin(ctx context.Context, req *PinRequest) (*PinInfo, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("Pin"), time.Since(start).Seconds())
+	}()
+
+	if len(req.Digests) == 0 && len(req.ActionDigests) == 0 {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Pin", "invalid_request").Inc()
+		return nil, status.Error(codes.InvalidArgument, "at least one digest or action digest is required")
+	}
+	if req.TtlSeconds < 0 {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Pin", "invalid_request").Inc()
+		return nil, status.Error(codes.InvalidArgument, "ttl_seconds cannot be negative")
+	}
+	for _, digests := range [][]*Digest{req.Digests, req.ActionDigests} {
+		for _, digest := range digests {
+			if digest.GetHash() == "" {
+				s.metrics.GRPCRequestsTotal.WithLabelValues("Pin", "invalid_request").Inc()
+				return nil, status.Error(codes.InvalidArgument, "digest hash is required")
+			}
+		}
+	}
+
+	identity := IdentityFromContext(ctx)
+	if !s.trust.IsTrusted(identity) {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Pin", "permission_denied").Inc()
+		return nil, status.Error(codes.PermissionDenied, "pinning requires a trusted identity")
+	}
+
+	resolver := &pinResolver{server: s, seen: make(map[string]bool)}
+	for _, digest := range req.Digests {
+		if err := resolver.add(ctx, req.InstanceName+"/"+digest.Hash, true); err != nil {
+			return nil, s.pinError(err)
+		}
+	}
+	for _, digest := range req.ActionDigests {
+		key := actionResultKey(req.InstanceName, digest.Hash)
+		if err := resolver.add(ctx, key, true); err != nil {
+			return nil, s.pinError(err)
+		}
+		// Unsigned entries have no provenance
+		if err := resolver.add(ctx, provenanceKey(key), false); err != nil {
+			return nil, s.pinError(err)
+		}
+		if req.IncludeReachable {
+			if err := resolver.addReachable(ctx, key); err != nil {
+				return nil, s.pinError(err)
+			}
+		}
+	}
+
+	id, err := newPinID()
+	if err != nil {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Pin", "error").Inc()
+		return nil, status.Error(codes.Internal, "failed to create pin")
+	}
+
+	pin := &eviction.Pin{
+		ID:           id,
+		Label:        req.Label,
+		InstanceName: req.InstanceName,
+		CreatedBy:    identity.Name,
+		CreatedAt:    time.Now(),
+		Keys:         resolver.keys,
+		Bytes:        resolver.bytes,
+	}
+	if req.TtlSeconds > 0 {
+		pin.ExpiresAt = pin.CreatedAt.Add(time.Duration(req.TtlSeconds) * time.Second)
+	}
+
+	if err := s.cache.SavePin(ctx, pin); err != nil {
+		s.logger.Error("Failed to store pin", zap.String("id", id), zap.Error(err))
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Pin", "storage_error").Inc()
+		return nil, status.Error(codes.Internal, "failed to store pin")
+	}
+
+	s.logger.Info("Pinned cache entries",
+		zap.String("id", id),
+		zap.String("label", pin.Label),
+		zap.String("instance", pin.InstanceName),
+		zap.String("created_by", pin.CreatedBy),
+		zap.Int("entries", len(pin.Keys)),
+		zap.Int64("bytes", pin.Bytes),
+		zap.Time("expires_at", pin.ExpiresAt),
+	)
+	s.metrics.GRPCRequestsTotal.WithLabelValues("Pin", "success").Inc()
+	return pinInfo(pin, time.Now()), nil
+}
+
+// Unpin removes a pin of the request's instance
+func (s *CacheServer) Unpin(ctx context.Context, req *UnpinRequest) (*UnpinResponse, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("Unpin"), time.Since(start).Seconds())
+	}()
+
+	if !pinID.MatchString(req.Id) {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Unpin", "invalid_request").Inc()
+		return nil, status.Error(codes.InvalidArgument, "invalid pin id")
+	}
+
+	identity := IdentityFromContext(ctx)
+	if !s.trust.IsTrusted(identity) {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Unpin", "permission_denied").Inc()
+		return nil, status.Error(codes.PermissionDenied, "unpinning requires a trusted identity")
+	}
+
+	pin, err := s.cache.LoadPin(ctx, req.Id)
+	switch {
+	case cache.IsNotFound(err):
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Unpin", "not_found").Inc()
+		return &UnpinResponse{Found: false}, nil
+	case err != nil:
+		s.logger.Error("Failed to load pin", zap.String("id", req.Id), zap.Error(err))
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Unpin", "error").Inc()
+		return nil, status.Error(codes.Internal, "failed to load pin")
+	case pin.InstanceName != req.InstanceName:
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Unpin", "not_found").Inc()
+		return &UnpinResponse{Found: false}, nil
+	}
+
+	found, err := s.cache.DeletePin(ctx, req.Id)
+	if err != nil {
+		s.logger.Error("Failed to delete pin", zap.String("id", req.Id), zap.Error(err))
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Unpin", "storage_error").Inc()
+		return nil, status.Error(codes.Internal, "failed to delete pin")
+	}
+
+	s.logger.Info("Removed pin",
+		zap.String("id", req.Id),
+		zap.String("label", pin.Label),
+		zap.String("removed_by", identity.Name),
+	)
+	s.metrics.GRPCRequestsTotal.WithLabelValues("Unpin", "success").Inc()
+	return &UnpinResponse{Found: found}, nil
+}
+
+// ListPins lists the pins of an instance with the bytes they hold
+func (s *CacheServer) ListPins(ctx context.Context, req *ListPinsRequest) (*ListPinsResponse, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("ListPins"), time.Since(start).Seconds())
+	}()
+
+	pins, err := s.cache.LoadPins(ctx)
+	if err != nil {
+		s.logger.Error("Failed to load pins", zap.Error(err))
+		s.metrics.GRPCRequestsTotal.WithLabelValues("ListPins", "error").Inc()
+		return nil, status.Error(codes.Internal, "failed to load pins")
+	}
+
+	sort.Slice(pins, func(i, j int) bool { return pins[i].CreatedAt.Before(pins[j].CreatedAt) })
+
+	now := time.Now()
+	response := &ListPinsResponse{}
+	for _, pin := range pins {
+		if pin.InstanceName != req.InstanceName || (pin.Expired(now) && !req.IncludeExpired) {
+			continue
+		}
+		response.Pins = append(response.Pins, pinInfo(pin, now))
+	}
+
+	s.metrics.GRPCRequestsTotal.WithLabelValues("ListPins", "success").Inc()
+	return response, nil
+}
+
+// pinError maps a failure to resolve the entries to pin to a status
+func (s *CacheServer) pinError(err error) error {
+	if cache.IsNotFound(err) {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("Pin", "not_found").Inc()
+		return status.Error(codes.NotFound, err.Error())
+	}
+	s.logger.Error("Failed to resolve entries to pin", zap.Error(err))
+	s.metrics.GRPCRequestsTotal.WithLabelValues("Pin", "error").Inc()
+	return status.Error(codes.Internal, "failed to resolve entries to pin")
+}
+
+// pinResolver collects the keys a pin holds and their total size
+type pinResolver struct {
+	server *CacheServer
+	seen   map[string]bool
+	keys   []string
+	bytes  int64
+}
+
+// add holds key. A missing entry fails the pin when required and is skipped
+// otherwise.
+func (r *pinResolver) add(ctx context.Context, key string, required bool) error {
+	if r.seen[key] {
+		return nil
+	}
+
+	entry, err := r.server.cache.Stat(ctx, key)
+	if err != nil {
+		if cache.IsNotFound(err) && !required {
+			return nil
+		}
+		return err
+	}
+
+	r.seen[key] = true
+	r.keys = append(r.keys, key)
+	r.bytes += entry.Size
+	return nil
+}
+
+// addReachable holds the output files and directory trees an action result
+// references, and the files and directories inside those trees. Outputs that
+// are already gone are skipped.
+func (r *pinResolver) addReachable(ctx context.Context, key string) error {
+	result, _, err := r.server.readActionResult(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range result.OutputFiles {
+		if err := r.add(ctx, eviction.BlobKey(key, file.Digest.GetHash()), false); err != nil {
+			return err
+		}
+	}
+	for _, dir := range result.OutputDirectories {
+		treeKey := eviction.BlobKey(key, dir.TreeDigest.GetHash())
+		if err := r.add(ctx, treeKey, false); err != nil {
+			return err
+		}
+
+		tree, err := r.server.peek(ctx, treeKey)
+		if cache.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		children, err := eviction.TreeDigests(tree)
+		if err != nil {
+			r.server.logger.Warn("Failed to parse output tree", zap.String("key", treeKey), zap.Error(err))
+			continue
+		}
+		for _, child := range children {
+			if err := r.add(ctx, eviction.BlobKey(key, child), false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// peek reads a cache entry without recording an access
+func (s *CacheServer) peek(ctx context.Context, key string) ([]byte, error) {
+	reader, err := s.cache.Peek(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(io.LimitReader(reader, maxTreeSize))
+}
+
+// newPinID returns a random pin identifier
+func newPinID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func pinInfo(pin *eviction.Pin, now time.Time) *PinInfo {
+	info := &PinInfo{
+		Id:           pin.ID,
+		Label:        pin.Label,
+		InstanceName: pin.InstanceName,
+		CreatedBy:    pin.CreatedBy,
+		CreatedAt:    pin.CreatedAt.UnixNano(),
+		Entries:      int64(len(pin.Keys)),
+		BytesHeld:    pin.Bytes,
+		Expired:      pin.Expired(now),
+	}
+	if !pin.ExpiresAt.IsZero() {
+		info.ExpiresAt = pin.ExpiresAt.UnixNano()
+	}
+	return info
+}
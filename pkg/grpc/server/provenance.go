@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+	"github.com/ruslanbaba/distributed-build-cache/internal/provenance"
+)
+
+const (
+	// envelopeContentType marks stored DSSE envelopes
+	envelopeContentType = "application/vnd.dsse.envelope.v1+json"
+	// maxEnvelopeSize bounds how much of a stored envelope is read into memory
+	maxEnvelopeSize = 4 * 1024 * 1024
+
+	metadataKeyID = "key_id"
+)
+
+// provenanceKey returns the attestation key stored next to an action cache entry
+func provenanceKey(actionResultKey string) string {
+	i := strings.LastIndex(actionResultKey, "/action_result/")
+	return actionResultKey[:i] + "/provenance/" + actionResultKey[i+len("/action_result/"):]
+}
+
+// storeProvenance signs the encoded action result and stores the envelope
+func (s *CacheServer) storeProvenance(ctx context.Context, key string, req *UpdateActionResultRequest, data []byte, identity Identity, metadata map[string]string) error {
+	sum := sha256.Sum256(data)
+
+	outputs := make([]provenance.Output, 0, len(req.ActionResult.OutputFiles)+len(req.ActionResult.OutputDirectories))
+	for _, file := range req.ActionResult.OutputFiles {
+		outputs = append(outputs, provenance.Output{
+			Path:   file.Path,
+			SHA256: file.Digest.GetHash(),
+		})
+	}
+	for _, dir := range req.ActionResult.OutputDirectories {
+		outputs = append(outputs, provenance.Output{
+			Path:   dir.Path,
+			SHA256: dir.TreeDigest.GetHash(),
+			Tree:   true,
+		})
+	}
+
+	envelope, err := s.signer.Sign(provenance.ActionInfo{
+		InstanceName:       req.InstanceName,
+		ActionDigest:       req.ActionDigest.Hash,
+		ActionResultDigest: hex.EncodeToString(sum[:]),
+		ExitCode:           req.ActionResult.ExitCode,
+		Writer:             identity.Name,
+		WriterVerified:     identity.Verified,
+		Outputs:            outputs,
+		Timestamp:          time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode envelope: %w", err)
+	}
+
+	envelopeMetadata := map[string]string{metadataKeyID: s.signer.KeyID()}
+	for k, v := range metadata {
+		envelopeMetadata[k] = v
+	}
+
+	return s.cache.PutWithOptions(ctx, provenanceKey(key), bytes.NewReader(encoded), cache.PutOptions{
+		ContentType: envelopeContentType,
+		Metadata:    envelopeMetadata,
+		TTL:         time.Duration(req.TtlSeconds) * time.Second,
+	})
+}
+
+// GetProvenance retrieves the signed attestation of an action result
+func (s *CacheServer) GetProvenance(ctx context.Context, req *GetProvenanceRequest) (*Provenance, error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveWithTrace(ctx, s.metrics.GRPCRequestDuration.WithLabelValues("GetProvenance"), time.Since(start).Seconds())
+	}()
+
+	if req.ActionDigest == nil {
+		s.metrics.GRPCRequestsTotal.WithLabelValues("GetProvenance", "invalid_request").Inc()
+		return nil, status.Error(codes.InvalidArgument, "action digest is required")
+	}
+
+	identity := IdentityFromContext(ctx)
+
+	// Resolve the same entry GetActionResult would return to this reader
+	for _, key := range s.readableActionResultKeys(req.InstanceName, req.ActionDigest.Hash, identity) {
+		reader, entry, err := s.cache.Get(ctx, provenanceKey(key))
+		if cache.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			s.logger.Error("Failed to read provenance",
+				zap.String("key", key),
+				zap.Error(err),
+			)
+			s.metrics.GRPCRequestsTotal.WithLabelValues("GetProvenance", "error").Inc()
+			return nil, status.Error(codes.Internal, "failed to read provenance")
+		}
+
+		envelope, err := io.ReadAll(io.LimitReader(reader, maxEnvelopeSize))
+		reader.Close()
+		if err != nil {
+			s.metrics.GRPCRequestsTotal.WithLabelValues("GetProvenance", "error").Inc()
+			return nil, status.Error(codes.Internal, "failed to read provenance")
+		}
+
+		s.metrics.GRPCRequestsTotal.WithLabelValues("GetProvenance", "success").Inc()
+		return &Provenance{
+			Envelope: envelope,
+			KeyId:    entry.Metadata[metadataKeyID],
+			Writer:   entry.Metadata[metadataWriter],
+			Trusted:  entry.Metadata[metadataTrust] == "trusted",
+		}, nil
+	}
+
+	s.metrics.GRPCRequestsTotal.WithLabelValues("GetProvenance", "not_found").Inc()
+	return nil, status.Error(codes.NotFound, "provenance not found")
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/security"
+)
+
+// WithSecretScanner scans action logs and small CAS blobs for credentials.
+// Findings are recorded in the audit log when one is given.
+func WithSecretScanner(scanner *security.SecretScanner, audit *security.AuditLogger) Option {
+	return func(s *CacheServer) {
+		s.secrets = scanner
+		s.secretAudit = audit
+	}
+}
+
+// scanActionResult applies the secret policy to stdout and stderr, redacting
+// them in place or returning an error when the write must be rejected
+func (s *CacheServer) scanActionResult(ctx context.Context, resource string, result *ActionResult, identity Identity) error {
+	stdout := s.secrets.Scan(result.StdoutRaw)
+	stderr := s.secrets.Scan(result.StderrRaw)
+
+	findings := append(append([]security.SecretFinding{}, stdout...), stderr...)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	policy := security.StrongestSecretPolicy(findings)
+	s.recordSecretFindings(ctx, resource, findings, policy, identity)
+
+	switch policy {
+	case security.SecretPolicyReject:
+		return status.Errorf(codes.InvalidArgument, "action output contains secrets (%s)", strings.Join(secretRules(findings), ", "))
+	case security.SecretPolicyRedact:
+		if len(stdout) > 0 {
+			result.StdoutRaw = s.secrets.Redact(result.StdoutRaw)
+		}
+		if len(stderr) > 0 {
+			result.StderrRaw = s.secrets.Redact(result.StderrRaw)
+		}
+	}
+	return nil
+}
+
+// scanBlob applies the secret policy to a CAS blob. Rewriting a blob would
+// break its content digest, so the redact policy rejects the upload instead.
+func (s *CacheServer) scanBlob(ctx context.Context, resource string, data []byte, identity Identity) error {
+	findings := s.secrets.Scan(data)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	policy := security.StrongestSecretPolicy(findings)
+	if policy == security.SecretPolicyRedact {
+		policy = security.SecretPolicyReject
+	}
+	s.recordSecretFindings(ctx, resource, findings, policy, identity)
+
+	if policy == security.SecretPolicyReject {
+		return status.Errorf(codes.InvalidArgument, "blob contains secrets (%s)", strings.Join(secretRules(findings), ", "))
+	}
+	return nil
+}
+
+// recordSecretFindings updates metrics, logs and the audit trail
+func (s *CacheServer) recordSecretFindings(ctx context.Context, resource string, findings []security.SecretFinding, policy security.SecretPolicy, identity Identity) {
+	action := map[security.SecretPolicy]string{
+		security.SecretPolicyAudit:  "audited",
+		security.SecretPolicyRedact: "redacted",
+		security.SecretPolicyReject: "rejected",
+	}[policy]
+
+	for _, finding := range findings {
+		s.metrics.SecretFindings.WithLabelValues(finding.Rule, action).Inc()
+	}
+
+	rules := secretRules(findings)
+	s.logger.Warn("Secrets detected in upload",
+		zap.String("resource", resource),
+		zap.String("client", identity.Name),
+		zap.Strings("rules", rules),
+		zap.Int("matches", len(findings)),
+		zap.String("action", action),
+	)
+
+	if s.secretAudit != nil {
+		s.secretAudit.LogSecurityEvent(security.SecurityEvent{
+			Type:     "secret_detected",
+			UserID:   identity.Name,
+			ClientIP: peerHost(ctx),
+			Resource: resource,
+			Action:   action,
+			Reason:   strings.Join(rules, ","),
+			Severity: "critical",
+		})
+	}
+}
+
+// secretRules returns the distinct rule names among findings
+func secretRules(findings []security.SecretFinding) []string {
+	seen := make(map[string]bool)
+	var rules []string
+	for _, finding := range findings {
+		if !seen[finding.Rule] {
+			seen[finding.Rule] = true
+			rules = append(rules, finding.Rule)
+		}
+	}
+	sort.Strings(rules)
+	return rules
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/cache"
+	"github.com/ruslanbaba/distributed-build-cache/internal/observability"
+	"github.com/ruslanbaba/distributed-build-cache/pkg/eviction"
+)
+
+const (
+	// maxFreshnessChecks bounds the sampled action results checked at once;
+	// samples beyond it are skipped rather than queued
+	maxFreshnessChecks = 8
+	// freshnessCheckTimeout bounds the lookups of one sampled action result
+	freshnessCheckTimeout = 30 * time.Second
+)
+
+// freshnessSampler checks a sample of action cache hits for outputs that
+// are no longer stored
+type freshnessSampler struct {
+	ratio float64
+	slots chan struct{}
+}
+
+// WithSLOs reports RPC outcomes to the SLOs of stack, checking a
+// sampleRatio of action cache hits for freshness
+func WithSLOs(stack *observability.ObservabilityStack, sampleRatio float64) Option {
+	return func(s *CacheServer) {
+		s.slos = stack
+		s.freshness = &freshnessSampler{ratio: sampleRatio, slots: make(chan struct{}, maxFreshnessChecks)}
+	}
+}
+
+// StreamSLOInterceptor counts Get calls towards the availability and latency
+// SLOs from their final status and the time to their first response. It
+// goes first in the chain, so requests rejected by other interceptors count.
+func StreamSLOInterceptor(stack *observability.ObservabilityStack) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod != "/buildcache.BuildCacheService/Get" {
+			return handler(srv, stream)
+		}
+
+		timed := &firstSendStream{ServerStream: stream, start: time.Now()}
+		err := handler(srv, timed)
+		code := status.Code(err)
+		if code == codes.Canceled {
+			return err // The client gave up, not counted either way
+		}
+		stack.RecordGet(serverError(code), timed.firstByte())
+		return err
+	}
+}
+
+// serverError reports whether a status code means the server failed the
+// call rather than the client asking for something missing or invalid
+func serverError(code codes.Code) bool {
+	switch code {
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// firstSendStream records when the first response of a stream was sent
+type firstSendStream struct {
+	grpc.ServerStream
+	start time.Time
+
+	once  sync.Once
+	first time.Duration
+}
+
+func (s *firstSendStream) SendMsg(m interface{}) error {
+	s.once.Do(func() { s.first = time.Since(s.start) })
+	return s.ServerStream.SendMsg(m)
+}
+
+// firstByte returns the time to the first response, or to the end of the
+// call if nothing was sent
+func (s *firstSendStream) firstByte() time.Duration {
+	s.once.Do(func() { s.first = time.Since(s.start) })
+	return s.first
+}
+
+// sampleFreshness checks in the background whether the outputs of a
+// sampled action cache hit are all still stored
+func (s *CacheServer) sampleFreshness(instance string, result *ActionResult) {
+	if s.freshness == nil || rand.Float64() >= s.freshness.ratio {
+		return
+	}
+	select {
+	case s.freshness.slots <- struct{}{}:
+	default:
+		return
+	}
+
+	go func() {
+		defer func() { <-s.freshness.slots }()
+		ctx, cancel := context.WithTimeout(context.Background(), freshnessCheckTimeout)
+		defer cancel()
+
+		fresh, err := s.outputsStored(ctx, instance, result)
+		if err != nil {
+			s.logger.Debug("Failed to check action result freshness", zap.Error(err))
+			return
+		}
+		s.slos.RecordActionHit(fresh)
+	}()
+}
+
+// outputsStored reports whether every output file and tree of an action
+// result is present and unexpired in the CAS
+func (s *CacheServer) outputsStored(ctx context.Context, instance string, result *ActionResult) (bool, error) {
+	var hashes []string
+	for _, file := range result.OutputFiles {
+		hashes = append(hashes, file.Digest.GetHash())
+	}
+	for _, dir := range result.OutputDirectories {
+		hashes = append(hashes, dir.TreeDigest.GetHash())
+	}
+
+	now := time.Now()
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		entry, err := s.cache.Stat(ctx, fmt.Sprintf("%s/%s", instance, hash))
+		if cache.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if expiresAt := eviction.ExpiresAt(entry.Metadata); !expiresAt.IsZero() && !now.Before(expiresAt) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
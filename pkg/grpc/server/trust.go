@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+)
+
+// TrustPolicy decides which writers may update the shared action cache.
+// Untrusted writers with a verified identity are redirected to a private
+// per-identity overlay; unverified ones have no overlay to write to.
+type TrustPolicy struct {
+	patterns        []string
+	requireVerified bool
+}
+
+// NewTrustPolicy creates a policy trusting identities that match any of the
+// path.Match patterns, e.g. "ci-*" or "spiffe://ci/*". When requireVerified is
+// set, only identities taken from verified client certificates can be trusted.
+func NewTrustPolicy(patterns []string, requireVerified bool) (*TrustPolicy, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid trusted writer pattern %q: %w", pattern, err)
+		}
+	}
+
+	return &TrustPolicy{
+		patterns:        patterns,
+		requireVerified: requireVerified,
+	}, nil
+}
+
+// IsTrusted reports whether the identity may write to the shared action cache.
+// A nil policy trusts everyone.
+func (p *TrustPolicy) IsTrusted(id Identity) bool {
+	if p == nil {
+		return true
+	}
+	if p.requireVerified && !id.Verified {
+		return false
+	}
+
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, id.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// actionResultKey returns the shared action cache key for an action digest
+func actionResultKey(instance, hash string) string {
+	return fmt.Sprintf("%s/action_result/%s", instance, hash)
+}
+
+// overlayActionResultKey returns the private action cache key of an untrusted
+// writer. The identity is hex encoded, so distinct identities never share an
+// overlay.
+func overlayActionResultKey(instance, identity, hash string) string {
+	return fmt.Sprintf("%s/overlay/%s/action_result/%s", instance, hex.EncodeToString([]byte(identity)), hash)
+}
+
+// readableActionResultKeys lists the action cache keys visible to identity in
+// lookup order: untrusted verified readers see their own overlay first, then
+// the shared cache
+func (s *CacheServer) readableActionResultKeys(instance, hash string, identity Identity) []string {
+	keys := []string{actionResultKey(instance, hash)}
+	if identity.Verified && !s.trust.IsTrusted(identity) {
+		keys = append([]string{overlayActionResultKey(instance, identity.Name, hash)}, keys...)
+	}
+	return keys
+}
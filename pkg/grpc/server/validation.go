@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ruslanbaba/distributed-build-cache/internal/metrics"
+	"github.com/ruslanbaba/distributed-build-cache/internal/security"
+)
+
+// UnaryValidationInterceptor rejects malformed unary requests before they reach storage
+func UnaryValidationInterceptor(validator *security.InputValidator, metrics *metrics.Collector) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := validationError(validator, req); err != nil {
+			metrics.GRPCRequestsTotal.WithLabelValues(methodName(info.FullMethod), "invalid_request").Inc()
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamValidationInterceptor rejects malformed streamed requests before they reach storage
+func StreamValidationInterceptor(validator *security.InputValidator, metrics *metrics.Collector) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingServerStream{
+			ServerStream: stream,
+			validator:    validator,
+			metrics:      metrics,
+			method:       methodName(info.FullMethod),
+		})
+	}
+}
+
+// validatingServerStream validates the first message of a stream, which carries
+// the request or Put metadata; later messages only carry data chunks
+type validatingServerStream struct {
+	grpc.ServerStream
+	validator *security.InputValidator
+	metrics   *metrics.Collector
+	method    string
+	validated bool
+}
+
+func (s *validatingServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if req, ok := m.(*PutRequest); ok && s.validated && req.Metadata != nil {
+		s.metrics.GRPCRequestsTotal.WithLabelValues(s.method, "invalid_request").Inc()
+		return badRequest([]*errdetails.BadRequest_FieldViolation{
+			violation("metadata", fmt.Errorf("metadata is only allowed in the first message")),
+		})
+	}
+
+	if !s.validated {
+		s.validated = true
+		if err := validationError(s.validator, m); err != nil {
+			s.metrics.GRPCRequestsTotal.WithLabelValues(s.method, "invalid_request").Inc()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validationError returns an InvalidArgument status listing every violation in req
+func validationError(v *security.InputValidator, req interface{}) error {
+	var violations []*errdetails.BadRequest_FieldViolation
+
+	switch r := req.(type) {
+	case *GetRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+		violations = append(violations, validateDigest(v, "digest", r.Digest)...)
+	case *PutRequest:
+		if r.Metadata == nil {
+			violations = append(violations, violation("metadata", fmt.Errorf("metadata is required in the first message")))
+			break
+		}
+		violations = append(violations, validateInstance(v, "metadata.instance_name", r.Metadata.InstanceName)...)
+		violations = append(violations, validateDigest(v, "metadata.digest", r.Metadata.Digest)...)
+		if err := v.ValidateContentType(r.Metadata.ContentType); err != nil {
+			violations = append(violations, violation("metadata.content_type", err))
+		}
+		if r.Metadata.TtlSeconds < 0 {
+			violations = append(violations, violation("metadata.ttl_seconds", fmt.Errorf("ttl_seconds cannot be negative")))
+		}
+	case *ContainsRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+		if max := v.Rules().MaxDigestsPerRequest; max > 0 && len(r.Digests) > max {
+			violations = append(violations, violation("digests", fmt.Errorf("at most %d digests per request", max)))
+			break
+		}
+		for i, digest := range r.Digests {
+			violations = append(violations, validateDigest(v, fmt.Sprintf("digests[%d]", i), digest)...)
+		}
+	case *GetActionResultRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+		violations = append(violations, validateDigest(v, "action_digest", r.ActionDigest)...)
+	case *UpdateActionResultRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+		violations = append(violations, validateDigest(v, "action_digest", r.ActionDigest)...)
+		violations = append(violations, validateActionResult(v, "action_result", r.ActionResult)...)
+		if r.TtlSeconds < 0 {
+			violations = append(violations, violation("ttl_seconds", fmt.Errorf("ttl_seconds cannot be negative")))
+		}
+	case *NondeterminismReportRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+		if r.MaxResults < 0 {
+			violations = append(violations, violation("max_results", fmt.Errorf("max_results cannot be negative")))
+		}
+	case *GetProvenanceRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+		violations = append(violations, validateDigest(v, "action_digest", r.ActionDigest)...)
+	case *PinRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+		if max := v.Rules().MaxDigestsPerRequest; max > 0 && len(r.Digests)+len(r.ActionDigests) > max {
+			violations = append(violations, violation("digests", fmt.Errorf("at most %d digests per request", max)))
+			break
+		}
+		for i, digest := range r.Digests {
+			violations = append(violations, validateDigest(v, fmt.Sprintf("digests[%d]", i), digest)...)
+		}
+		for i, digest := range r.ActionDigests {
+			violations = append(violations, validateDigest(v, fmt.Sprintf("action_digests[%d]", i), digest)...)
+		}
+		if r.TtlSeconds < 0 {
+			violations = append(violations, violation("ttl_seconds", fmt.Errorf("ttl_seconds cannot be negative")))
+		}
+	case *UnpinRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+		if !pinID.MatchString(r.Id) {
+			violations = append(violations, violation("id", fmt.Errorf("invalid pin id")))
+		}
+	case *ListPinsRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+	case *WhyMissingRequest:
+		violations = append(violations, validateInstance(v, "instance_name", r.InstanceName)...)
+		violations = append(violations, validateDigest(v, "digest", r.Digest)...)
+	case *InvocationReportRequest:
+		if !invocationID.MatchString(r.InvocationId) {
+			violations = append(violations, violation("invocation_id", fmt.Errorf("invalid invocation id")))
+		}
+		if r.MaxResults < 0 {
+			violations = append(violations, violation("max_results", fmt.Errorf("max_results cannot be negative")))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return badRequest(violations)
+}
+
+func validateInstance(v *security.InputValidator, field, instance string) []*errdetails.BadRequest_FieldViolation {
+	if err := v.ValidateInstanceName(instance); err != nil {
+		return []*errdetails.BadRequest_FieldViolation{violation(field, err)}
+	}
+	return nil
+}
+
+func validateDigest(v *security.InputValidator, field string, digest *Digest) []*errdetails.BadRequest_FieldViolation {
+	if digest == nil {
+		return []*errdetails.BadRequest_FieldViolation{violation(field, fmt.Errorf("digest is required"))}
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	if err := v.ValidateArtifactHash(digest.Hash); err != nil {
+		violations = append(violations, violation(field+".hash", err))
+	}
+	if err := v.ValidateBlobSize(digest.SizeBytes); err != nil {
+		violations = append(violations, violation(field+".size_bytes", err))
+	}
+	return violations
+}
+
+func validateActionResult(v *security.InputValidator, field string, result *ActionResult) []*errdetails.BadRequest_FieldViolation {
+	if result == nil {
+		return []*errdetails.BadRequest_FieldViolation{violation(field, fmt.Errorf("action result is required"))}
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	for i, file := range result.OutputFiles {
+		prefix := fmt.Sprintf("%s.output_files[%d]", field, i)
+		if err := validateOutputPath(file.Path); err != nil {
+			violations = append(violations, violation(prefix+".path", err))
+		}
+		violations = append(violations, validateDigest(v, prefix+".digest", file.Digest)...)
+	}
+	for i, dir := range result.OutputDirectories {
+		prefix := fmt.Sprintf("%s.output_directories[%d]", field, i)
+		if err := validateOutputPath(dir.Path); err != nil {
+			violations = append(violations, violation(prefix+".path", err))
+		}
+		violations = append(violations, validateDigest(v, prefix+".tree_digest", dir.TreeDigest)...)
+	}
+	return violations
+}
+
+// validateOutputPath ensures output paths stay inside the action's working directory
+func validateOutputPath(p string) error {
+	if p == "" {
+		return fmt.Errorf("path cannot be empty")
+	}
+	if strings.HasPrefix(p, "/") {
+		return fmt.Errorf("path must be relative")
+	}
+	if clean := path.Clean(p); clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("path escapes the working directory")
+	}
+	return nil
+}
+
+func violation(field string, err error) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: err.Error(),
+	}
+}
+
+// badRequest builds an InvalidArgument status carrying BadRequest details
+func badRequest(violations []*errdetails.BadRequest_FieldViolation) error {
+	msg := fmt.Sprintf("invalid %s: %s", violations[0].Field, violations[0].Description)
+	if len(violations) > 1 {
+		msg = fmt.Sprintf("%s (and %d more violations)", msg, len(violations)-1)
+	}
+
+	st, err := status.New(codes.InvalidArgument, msg).WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return st.Err()
+}
+
+// methodName strips the service prefix from a full gRPC method name
+func methodName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
@@ -2,33 +2,42 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/eviction"
 	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/gcs"
 	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/metrics"
 )
 
 func main() {
-	cfg := gcs.Config{
-		ProjectID:       env("GCP_PROJECT_ID", ""),
-		Bucket:          env("GCS_BUCKET", ""),
-		MaxTotalBytes:   envInt64("MAX_TOTAL_BYTES", 5*1024*1024*1024*1024), // 5 TB
-		MinAgeToDelete:  envDuration("MIN_AGE", 14*24*time.Hour),
-		DeleteBatchSize: envInt("DELETE_BATCH_SIZE", 1000),
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		restoreMain(os.Args[2:])
+		return
 	}
 
-	if cfg.Bucket == "" {
-		log.Fatal("GCS_BUCKET is required")
-	}
+	dryRun := flag.Bool("dry-run", envBool("DRY_RUN", false), "Report which objects would be deleted without deleting them")
+	report := flag.String("report", env("REPORT_FILE", ""), "Write the JSON plan to this file (and a .txt report next to it); the previous report is used for the diff")
+	pushURL := flag.String("pushgateway", env("PUSHGATEWAY_URL", ""), "Push metrics to this Pushgateway when the run ends")
+	flag.Parse()
 
-	log.Printf("Starting pruner with config: ProjectID=%s, Bucket=%s, MaxTotalBytes=%d, MinAge=%s", 
-		cfg.ProjectID, cfg.Bucket, cfg.MaxTotalBytes, cfg.MinAgeToDelete)
+	cfg := loadConfig(*dryRun)
+
+	log.Printf("Starting pruner with config: ProjectID=%s, Bucket=%s, MaxTotalBytes=%d, MinAge=%s, DryRun=%t, Strategy=%s", 
+		cfg.ProjectID, cfg.Bucket, cfg.MaxTotalBytes, cfg.MinAgeToDelete, cfg.DryRun, cfg.Eviction.Default.Name())
+
+	// The CronJob deadline sends SIGTERM, stop deleting and report what was done
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	ctx := context.Background()
 	cl, err := gcs.NewClient(ctx, cfg)
 	if err != nil {
 		log.Fatal(err)
@@ -45,20 +54,137 @@ func main() {
 
 	// Run pruning
 	log.Println("Starting cache pruning...")
-	stats, err := cl.Prune(ctx)
+	startedAt := time.Now()
+	stats, plan, err := cl.Prune(ctx)
+
+	if plan != nil && *report != "" {
+		if reportErr := writeReport(plan, *report); reportErr != nil && err == nil {
+			err = reportErr
+		}
+	}
+
+	switch {
+	case err != nil:
+	case cfg.DryRun:
+		if err := plan.WriteText(os.Stdout); err != nil {
+			log.Printf("Failed to print report: %v", err)
+		}
+		log.Printf("Dry run completed: scanned=%d would_delete=%d bytes=%d", stats.Scanned, stats.Deleted, stats.BytesFreed)
+	default:
+		log.Printf("Pruning completed: scanned=%d deleted=%d bytes_freed=%d total=%d efficiency=%.2f%%", 
+			stats.Scanned, stats.Deleted, stats.BytesFreed, stats.Total, stats.Efficiency())
+	}
+
+	// Deletion counters and totals are updated while running, and the
+	// duration when Prune returns
+	finishedAt := time.Now()
+	if !cfg.DryRun {
+		metrics.PruningEfficiency.Set(stats.Efficiency())
+		if err == nil {
+			metrics.LastSuccess.Set(float64(finishedAt.Unix()))
+		}
+	}
+
+	if *pushURL != "" {
+		// ctx may already be cancelled by the CronJob deadline
+		pushCtx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+		if err := metrics.Push(pushCtx, *pushURL, env("PUSHGATEWAY_JOB", "gcs-pruner"), env("PUSHGATEWAY_INSTANCE", cfg.Bucket)); err != nil {
+			log.Printf("Failed to push metrics to %s: %v", *pushURL, err)
+		}
+		cancel()
+	}
+
+	s := summary{
+		Bucket:            cfg.Bucket,
+		DryRun:            cfg.DryRun,
+		StartedAt:         startedAt,
+		FinishedAt:        finishedAt,
+		DurationSeconds:   finishedAt.Sub(startedAt).Seconds(),
+		Stats:             stats,
+		EfficiencyPercent: stats.Efficiency(),
+	}
 	if err != nil {
-		log.Fatal(err)
+		s.Error = err.Error()
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(s); err != nil {
+		log.Printf("Failed to write summary: %v", err)
+	}
+
+	if err != nil {
+		log.Fatalf("%v (deleted=%d bytes_freed=%d)", err, stats.Deleted, stats.BytesFreed)
+	}
+}
+
+// pushTimeout bounds the final Pushgateway push
+const pushTimeout = 30 * time.Second
+
+// summary is the JSON line a run ends with, for log-based job history
+type summary struct {
+	Bucket          string    `json:"bucket"`
+	DryRun          bool      `json:"dry_run"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	gcs.Stats
+	EfficiencyPercent float64 `json:"efficiency_percent"`
+	Error             string  `json:"error,omitempty"`
+}
+
+// writeReport writes the plan, diffed against the previous report at path
+func writeReport(plan *gcs.Plan, path string) error {
+	previous, err := gcs.LoadPlan(path)
+	if err != nil {
+		log.Printf("Ignoring previous report: %v", err)
+	}
+	plan.DiffAgainst(previous)
+	if err := plan.WriteFiles(path); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	log.Printf("Wrote pruning report to %s", path)
+	return nil
+}
+
+// loadConfig reads the pruner configuration from the environment
+func loadConfig(dryRun bool) gcs.Config {
+	policy, err := eviction.NewPolicy(
+		env("EVICTION_STRATEGY", eviction.StrategyLRU),
+		envMap("INSTANCE_STRATEGIES"),
+		eviction.Options{Retention: envDuration("RETENTION", 30*24*time.Hour)},
+	)
+	if err != nil {
+		log.Fatalf("Invalid eviction configuration: %v", err)
 	}
-	
-	log.Printf("Pruning completed: scanned=%d deleted=%d bytes_freed=%d total=%d efficiency=%.2f%%", 
-		stats.Scanned, stats.Deleted, stats.BytesFreed, stats.Total, 
-		float64(stats.BytesFreed)/float64(stats.Total)*100)
 
-	// Update final metrics
-	metrics.TotalBytes.Set(float64(stats.Total))
-	metrics.ObjectsDeleted.Add(float64(stats.Deleted))
-	metrics.BytesFreed.Add(float64(stats.BytesFreed))
-	metrics.PruningDuration.Observe(time.Since(time.Now()).Seconds())
+	cfg := gcs.Config{
+		ProjectID:         env("GCP_PROJECT_ID", ""),
+		Bucket:            env("GCS_BUCKET", ""),
+		MaxTotalBytes:     envInt64("MAX_TOTAL_BYTES", 5*1024*1024*1024*1024), // 5 TB
+		MinAgeToDelete:    envDuration("MIN_AGE", 14*24*time.Hour),
+		DeleteBatchSize:   envInt("DELETE_BATCH_SIZE", 1000),
+		DeleteConcurrency: envInt("DELETE_CONCURRENCY", 32),
+		DeleteRate:        envFloat("DELETE_RATE", 500),
+		DeleteRetries:     envInt("DELETE_RETRIES", 5),
+		DryRun:            dryRun,
+		Eviction:          policy,
+		ReferenceAware:    envBool("REFERENCE_AWARE", true),
+		References: eviction.ReferenceOptions{
+			OrphanGrace:   envDuration("ORPHAN_GRACE", 24*time.Hour),
+			ProtectWindow: envDuration("PROTECT_WINDOW", 72*time.Hour),
+		},
+		ListParallelism:     envInt("LIST_PARALLELISM", 16),
+		CheckpointObject:    env("CHECKPOINT_OBJECT", "pruner/checkpoint.json"),
+		CheckpointInterval:  envDuration("CHECKPOINT_INTERVAL", time.Minute),
+		CheckpointMaxAge:    envDuration("CHECKPOINT_MAX_AGE", 24*time.Hour),
+		MaxReportCandidates: envInt("REPORT_MAX_CANDIDATES", 100000),
+		SoftDelete:          envBool("SOFT_DELETE", false),
+		TrashRetention:      envDuration("TRASH_RETENTION", 72*time.Hour),
+		DecisionRetention:   envDuration("DECISION_RETENTION", 30*24*time.Hour),
+	}
+
+	if cfg.Bucket == "" {
+		log.Fatal("GCS_BUCKET is required")
+	}
+	return cfg
 }
 
 func env(k, d string) string {
@@ -68,6 +194,15 @@ func env(k, d string) string {
 	return d
 }
 
+func envBool(k string, d bool) bool {
+	if v := os.Getenv(k); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return d
+}
+
 func envInt(k string, d int) int {
 	if v := os.Getenv(k); v != "" {
 		if x, err := strconv.Atoi(v); err == nil {
@@ -86,6 +221,15 @@ func envInt64(k string, d int64) int64 {
 	return d
 }
 
+func envFloat(k string, d float64) float64 {
+	if v := os.Getenv(k); v != "" {
+		if x, err := strconv.ParseFloat(v, 64); err == nil {
+			return x
+		}
+	}
+	return d
+}
+
 func envDuration(k string, d time.Duration) time.Duration {
 	if v := os.Getenv(k); v != "" {
 		if dur, err := time.ParseDuration(v); err == nil {
@@ -94,3 +238,14 @@ func envDuration(k string, d time.Duration) time.Duration {
 	}
 	return d
 }
+
+// envMap parses "key:value,key:value" pairs
+func envMap(k string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(k), ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(pair), ":"); ok {
+			m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return m
+}
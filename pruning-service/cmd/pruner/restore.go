@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/gcs"
+)
+
+// restoreMain brings trashed objects back into the cache:
+//
+//	pruner restore -run 20240101T020000Z
+//	pruner restore -prefix ac/ -dry-run
+func restoreMain(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Count the matching trashed objects without restoring them")
+	key := fs.String("key", "", "Restore the object stored under this cache key")
+	prefix := fs.String("prefix", "", "Restore objects whose cache key starts with this prefix")
+	runID := fs.String("run", "", "Restore objects trashed by this pruning run (see run_id in the report)")
+	fs.Parse(args)
+
+	cfg := loadConfig(*dryRun)
+	filter := gcs.RestoreFilter{Key: *key, Prefix: *prefix, RunID: *runID}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cl, err := gcs.NewClient(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cl.Close()
+
+	log.Printf("Restoring trashed objects from %s: key=%q prefix=%q run=%q dry_run=%t",
+		cfg.Bucket, filter.Key, filter.Prefix, filter.RunID, cfg.DryRun)
+	stats, err := cl.Restore(ctx, filter)
+	if encodeErr := json.NewEncoder(os.Stdout).Encode(stats); encodeErr != nil {
+		log.Printf("Failed to write summary: %v", encodeErr)
+	}
+	if err != nil {
+		log.Fatalf("Restore failed: %v (restored=%d)", err, stats.Restored)
+	}
+	log.Printf("Restore completed: matched=%d restored=%d skipped=%d failed=%d", stats.Matched, stats.Restored, stats.Skipped, stats.Failed)
+}
@@ -0,0 +1,293 @@
+// Code generated by make sync-eviction from pkg/eviction/references.go. DO NOT EDIT.
+
+package eviction
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Key layout segments written by the cache server
+const (
+	actionResultSegment = "/action_result/"
+	provenanceSegment   = "/provenance/"
+	overlaySegment      = "/overlay/"
+)
+
+// auxSegments mark objects that describe actions rather than hold content
+var auxSegments = []string{actionResultSegment, provenanceSegment, overlaySegment, "/ac_history/", "/nondeterminism/"}
+
+// ReferenceOptions configures reference-aware selection
+type ReferenceOptions struct {
+	OrphanGrace   time.Duration // Unreferenced blobs idle longer than this are pruned first
+	ProtectWindow time.Duration // Blobs referenced by AC entries used within this window are kept
+}
+
+// References lists the CAS blobs an action cache entry points at
+type References struct {
+	LastAccessed time.Time
+	Blobs        []string // CAS keys
+}
+
+// IsActionResultKey reports whether key holds an action cache entry
+func IsActionResultKey(key string) bool {
+	return strings.Contains(key, actionResultSegment)
+}
+
+// IsBlobKey reports whether key holds a CAS blob
+func IsBlobKey(key string) bool {
+	for _, segment := range auxSegments {
+		if strings.Contains(key, segment) {
+			return false
+		}
+	}
+	return key != ""
+}
+
+// BlobKey returns the CAS key of a digest referenced by an action cache entry
+func BlobKey(actionResultKey, hash string) string {
+	instance := actionResultKey
+	if i := strings.Index(instance, overlaySegment); i >= 0 {
+		instance = instance[:i]
+	} else if i := strings.Index(instance, actionResultSegment); i >= 0 {
+		instance = instance[:i]
+	}
+	return instance + "/" + hash
+}
+
+// IsProvenanceKey reports whether key holds an action result attestation
+func IsProvenanceKey(key string) bool {
+	return strings.Contains(key, provenanceSegment)
+}
+
+// ActionResultKey returns the action cache entry an attestation belongs to
+func ActionResultKey(provenanceKey string) string {
+	i := strings.LastIndex(provenanceKey, provenanceSegment)
+	if i < 0 {
+		return ""
+	}
+	return provenanceKey[:i] + actionResultSegment + provenanceKey[i+len(provenanceSegment):]
+}
+
+// ProvenanceKey returns the attestation key stored next to an action cache entry
+func ProvenanceKey(actionResultKey string) string {
+	i := strings.LastIndex(actionResultKey, actionResultSegment)
+	if i < 0 {
+		return ""
+	}
+	return actionResultKey[:i] + provenanceSegment + actionResultKey[i+len(actionResultSegment):]
+}
+
+// ActionResultDigests extracts output file digests and output directory tree
+// digests from an encoded ActionResult
+func ActionResultDigests(data []byte) (files, trees []string, err error) {
+	err = forEachField(data, func(num protowire.Number, value []byte) error {
+		// output_files = 1 and output_directories = 2 both carry their digest in field 2
+		if num != 1 && num != 2 {
+			return nil
+		}
+		hash, err := nestedDigestHash(value, 2)
+		if err != nil || hash == "" {
+			return err
+		}
+		if num == 1 {
+			files = append(files, hash)
+		} else {
+			trees = append(trees, hash)
+		}
+		return nil
+	})
+	return files, trees, err
+}
+
+// TreeDigests extracts file and directory digests from a REAPI Tree message
+func TreeDigests(data []byte) ([]string, error) {
+	var hashes []string
+	err := forEachField(data, func(num protowire.Number, directory []byte) error {
+		// root = 1, children = 2
+		if num != 1 && num != 2 {
+			return nil
+		}
+		return forEachField(directory, func(num protowire.Number, node []byte) error {
+			// files = 1, directories = 2, both carry their digest in field 2
+			if num != 1 && num != 2 {
+				return nil
+			}
+			hash, err := nestedDigestHash(node, 2)
+			if err == nil && hash != "" {
+				hashes = append(hashes, hash)
+			}
+			return err
+		})
+	})
+	return hashes, err
+}
+
+// SelectWithReferences picks items to evict while keeping the action cache
+// consistent with the CAS.
+//
+// Blobs referenced by an AC entry used within ProtectWindow are never evicted.
+// Unreferenced blobs idle longer than OrphanGrace are evicted first. The
+// strategies then free the remaining bytes, and every AC entry referencing an
+// evicted blob is evicted with it, together with its provenance. Provenance is
+// never picked by the strategies unless expired.
+func (p *Policy) SelectWithReferences(items []Item, refs map[string]References, bytesToFree int64, now time.Time, opts ReferenceOptions) []Decision {
+	byKey := make(map[string]Item, len(items))
+	for _, item := range items {
+		byKey[item.Key] = item
+	}
+
+	referencedBy := make(map[string][]string)
+	protected := make(map[string]bool)
+	for ac, ref := range refs {
+		recent := now.Sub(ref.LastAccessed) <= opts.ProtectWindow
+		for _, blob := range ref.Blobs {
+			referencedBy[blob] = append(referencedBy[blob], ac)
+			if recent {
+				protected[blob] = true
+			}
+		}
+	}
+
+	var selected []Decision
+	var rest []Item
+	var freed int64
+	for _, item := range items {
+		switch {
+		case protected[item.Key]:
+			continue
+		case IsBlobKey(item.Key) && len(referencedBy[item.Key]) == 0 && now.Sub(item.LastAccessed) > opts.OrphanGrace:
+			selected = append(selected, Decision{Item: item, Reason: "orphan", Mandatory: true})
+			freed += item.Size
+		case IsProvenanceKey(item.Key) && !item.Expired(now):
+			// Only evicted with its AC entry
+			continue
+		default:
+			rest = append(rest, item)
+		}
+	}
+
+	selected = append(selected, p.Select(rest, bytesToFree-freed, now)...)
+
+	chosen := make(map[string]bool, len(selected))
+	for _, d := range selected {
+		chosen[d.Item.Key] = true
+	}
+
+	// Cascade to AC entries left pointing at evicted blobs. The slice grows
+	// while iterating so provenance of cascaded entries is covered too.
+	for i := 0; i < len(selected); i++ {
+		key := selected[i].Item.Key
+
+		dependents := referencedBy[key]
+		if IsActionResultKey(key) {
+			dependents = []string{ProvenanceKey(key)}
+		}
+
+		for _, dep := range dependents {
+			item, ok := byKey[dep]
+			if !ok || chosen[dep] {
+				continue
+			}
+			chosen[dep] = true
+			selected = append(selected, Decision{Item: item, Reason: "dangling_reference", Mandatory: true})
+		}
+	}
+
+	return selected
+}
+
+// ReferenceIndex is the memory-bounded form of the reference graph used by
+// streaming selection
+type ReferenceIndex struct {
+	Referenced *KeySet // Blobs referenced by any AC entry
+	Protected  *KeySet // Blobs referenced by AC entries used within the protect window
+}
+
+// NewReferenceIndex returns an empty index
+func NewReferenceIndex() *ReferenceIndex {
+	return &ReferenceIndex{Referenced: NewKeySet(), Protected: NewKeySet()}
+}
+
+// Add records the blobs referenced by an AC entry
+func (r *ReferenceIndex) Add(ref References, now time.Time, opts ReferenceOptions) {
+	recent := now.Sub(ref.LastAccessed) <= opts.ProtectWindow
+	for _, blob := range ref.Blobs {
+		r.Referenced.Add(blob)
+		if recent {
+			r.Protected.Add(blob)
+		}
+	}
+}
+
+// Check classifies an item once every AC entry has been added. Protected
+// items must be kept and orphans are evicted before anything else.
+func (r *ReferenceIndex) Check(item Item, now time.Time, opts ReferenceOptions) (protected, orphan bool) {
+	if !IsBlobKey(item.Key) {
+		return false, false
+	}
+	if r.Protected.Contains(item.Key) {
+		return true, false
+	}
+	return false, !r.Referenced.Contains(item.Key) && now.Sub(item.LastAccessed) > opts.OrphanGrace
+}
+
+// Dangling reports whether an AC entry references an evicted blob
+func Dangling(ref References, evicted *KeySet) bool {
+	for _, blob := range ref.Blobs {
+		if evicted.Contains(blob) {
+			return true
+		}
+	}
+	return false
+}
+
+func forEachField(data []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("malformed message: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return fmt.Errorf("malformed field %d: %w", num, protowire.ParseError(n))
+			}
+			data = data[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return fmt.Errorf("malformed field %d: %w", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nestedDigestHash returns Digest.hash (field 1) of the Digest in field num
+func nestedDigestHash(msg []byte, num protowire.Number) (string, error) {
+	var hash string
+	err := forEachField(msg, func(n protowire.Number, digest []byte) error {
+		if n != num {
+			return nil
+		}
+		return forEachField(digest, func(n protowire.Number, value []byte) error {
+			if n == 1 {
+				hash = string(value)
+			}
+			return nil
+		})
+	})
+	return hash, err
+}
@@ -0,0 +1,161 @@
+// Code generated by make sync-eviction from pkg/eviction/strategies.go. DO NOT EDIT.
+
+package eviction
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Heuristic thresholds carried over from the original pruning service
+const (
+	largeEntryBytes = 100 * 1024 * 1024  // 100MB
+	largeStaleAge   = 3 * 24 * time.Hour // 3 days
+)
+
+// LRU evicts the least recently used entries first
+type LRU struct{}
+
+func (LRU) Name() string { return StrategyLRU }
+
+func (LRU) Rank(items []Item, now time.Time) []Decision {
+	ranked := sortedCopy(items, func(a, b Item) bool {
+		return a.LastAccessed.Before(b.LastAccessed)
+	})
+	return decisions(ranked, StrategyLRU)
+}
+
+func (LRU) Score(item Item, now time.Time) (float64, Decision) {
+	return math.Max(now.Sub(item.LastAccessed).Seconds(), 0), Decision{Item: item, Reason: StrategyLRU}
+}
+
+// LFU evicts the least frequently used entries first, oldest first on ties
+type LFU struct{}
+
+func (LFU) Name() string { return StrategyLFU }
+
+func (LFU) Rank(items []Item, now time.Time) []Decision {
+	ranked := sortedCopy(items, func(a, b Item) bool {
+		if a.AccessCount != b.AccessCount {
+			return a.AccessCount < b.AccessCount
+		}
+		return a.LastAccessed.Before(b.LastAccessed)
+	})
+	return decisions(ranked, StrategyLFU)
+}
+
+// Score orders by access count only, ties are broken by listing order
+func (LFU) Score(item Item, now time.Time) (float64, Decision) {
+	return 1 / float64(item.AccessCount+1), Decision{Item: item, Reason: StrategyLFU}
+}
+
+// GDSF (Greedy-Dual-Size-Frequency) evicts entries with the lowest
+// frequency per byte first, so large rarely read entries go before small hot ones.
+//
+// Classic GDSF ages entries with an inflation value carried between
+// evictions. Pruning runs are independent batches, so the frequency is aged
+// by idle time instead.
+type GDSF struct{}
+
+func (GDSF) Name() string { return StrategyGDSF }
+
+func (GDSF) Rank(items []Item, now time.Time) []Decision {
+	ranked := sortedCopy(items, func(a, b Item) bool {
+		return gdsfPriority(a, now) < gdsfPriority(b, now)
+	})
+	return decisions(ranked, StrategyGDSF)
+}
+
+func (GDSF) Score(item Item, now time.Time) (float64, Decision) {
+	return 1 / gdsfPriority(item, now), Decision{Item: item, Reason: StrategyGDSF}
+}
+
+func gdsfPriority(item Item, now time.Time) float64 {
+	idleDays := math.Max(now.Sub(item.LastAccessed).Hours()/24, 0)
+	frequency := float64(item.AccessCount+1) / (1 + idleDays)
+	return frequency / float64(max64(item.Size, 1))
+}
+
+// RetentionScore is a two-tier strategy. Entries idle longer than Retention
+// are always evicted. The rest are ranked by a score that grows with idle time
+// and size and shrinks with access frequency.
+type RetentionScore struct {
+	Retention time.Duration
+}
+
+func (RetentionScore) Name() string { return StrategyRetentionScore }
+
+func (s RetentionScore) Rank(items []Item, now time.Time) []Decision {
+	cutoff := now.Add(-s.Retention)
+
+	var expired, retained []Item
+	for _, item := range items {
+		if item.LastAccessed.Before(cutoff) {
+			expired = append(expired, item)
+		} else {
+			retained = append(retained, item)
+		}
+	}
+
+	expired = sortedCopy(expired, func(a, b Item) bool {
+		return a.LastAccessed.Before(b.LastAccessed)
+	})
+	retained = sortedCopy(retained, func(a, b Item) bool {
+		return retentionScore(a, now) > retentionScore(b, now)
+	})
+
+	ranked := make([]Decision, 0, len(items))
+	for _, item := range expired {
+		_, d := s.Score(item, now)
+		ranked = append(ranked, d)
+	}
+	for _, item := range retained {
+		_, d := s.Score(item, now)
+		ranked = append(ranked, d)
+	}
+
+	return ranked
+}
+
+func (s RetentionScore) Score(item Item, now time.Time) (float64, Decision) {
+	if item.LastAccessed.Before(now.Add(-s.Retention)) {
+		return math.Inf(1), Decision{Item: item, Reason: "retention", Mandatory: true}
+	}
+
+	reason := "score"
+	if item.Size > largeEntryBytes && now.Sub(item.LastAccessed) > largeStaleAge {
+		reason = "large_and_stale"
+	}
+	return retentionScore(item, now), Decision{Item: item, Reason: reason}
+}
+
+func retentionScore(item Item, now time.Time) float64 {
+	idleHours := math.Max(now.Sub(item.LastAccessed).Hours(), 0)
+	sizeFactor := math.Log2(2 + float64(item.Size)/(1024*1024))
+	return idleHours * sizeFactor / float64(item.AccessCount+1)
+}
+
+func sortedCopy(items []Item, less func(a, b Item) bool) []Item {
+	out := make([]Item, len(items))
+	copy(out, items)
+	sort.SliceStable(out, func(i, j int) bool {
+		return less(out[i], out[j])
+	})
+	return out
+}
+
+func decisions(items []Item, reason string) []Decision {
+	out := make([]Decision, len(items))
+	for i, item := range items {
+		out[i] = Decision{Item: item, Reason: reason}
+	}
+	return out
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,350 @@
+// Code generated by make sync-eviction from pkg/eviction/stream.go. DO NOT EDIT.
+
+package eviction
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Streaming selection lets a pruner evict from listings too large to hold in
+// memory. A first pass feeds every item to an Estimator, which keeps a
+// byte-weighted histogram of scores per instance. Cutoffs turns the histograms
+// into per-instance score thresholds, and a second pass asks a Selector about
+// each item as it is listed. The result approximates Select: items are only
+// ordered to histogram bucket precision, and items in the boundary bucket are
+// taken in listing order.
+
+const (
+	// Score histogram resolution, each power of two is split into this many buckets
+	bucketsPerOctave = 16
+	// KeySet insertions buffered before they are merged into the sorted hashes
+	minPendingKeys = 1 << 16
+)
+
+// Scorer is implemented by strategies that can score each item on its own,
+// which streaming selection requires
+type Scorer interface {
+	// Score returns the eviction priority of an item, higher evicts first,
+	// and the decision that evicts it
+	Score(item Item, now time.Time) (float64, Decision)
+}
+
+// Streamable reports an error if a strategy of the policy cannot be used for
+// streaming selection
+func (p *Policy) Streamable() error {
+	if _, ok := p.Default.(Scorer); !ok {
+		return fmt.Errorf("eviction strategy %s does not support streaming", p.Default.Name())
+	}
+	for instance, s := range p.PerInstance {
+		if _, ok := s.(Scorer); !ok {
+			return fmt.Errorf("instance %q: eviction strategy %s does not support streaming", instance, s.Name())
+		}
+	}
+	return nil
+}
+
+func (p *Policy) score(item Item, now time.Time) (float64, Decision) {
+	if item.Expired(now) {
+		return math.Inf(1), Decision{Item: item, Reason: ReasonExpired, Mandatory: true}
+	}
+	return p.StrategyFor(item.Instance).(Scorer).Score(item, now)
+}
+
+// Histogram is the score distribution of one instance
+type Histogram struct {
+	MandatoryBytes int64         `json:"mandatory_bytes"`
+	Buckets        map[int]int64 `json:"buckets"` // Score bucket -> bytes of optional items
+}
+
+// Estimator accumulates score histograms. It is not safe for concurrent use,
+// give each lister its own and Merge them.
+type Estimator struct {
+	Now       time.Time             `json:"now"` // Scoring time, shared by both passes
+	Instances map[string]*Histogram `json:"instances"`
+}
+
+// NewEstimator returns an empty estimator scoring items at now
+func NewEstimator(now time.Time) *Estimator {
+	return &Estimator{Now: now, Instances: make(map[string]*Histogram)}
+}
+
+// Add records an item using the strategy of its instance
+func (e *Estimator) Add(p *Policy, item Item) {
+	h := e.histogram(item.Instance)
+	score, d := p.score(item, e.Now)
+	if d.Mandatory {
+		h.MandatoryBytes += item.Size
+		return
+	}
+	h.Buckets[scoreBucket(score)] += item.Size
+}
+
+// Merge adds the histograms of other
+func (e *Estimator) Merge(other *Estimator) {
+	for instance, oh := range other.Instances {
+		h := e.histogram(instance)
+		h.MandatoryBytes += oh.MandatoryBytes
+		for b, bytes := range oh.Buckets {
+			h.Buckets[b] += bytes
+		}
+	}
+}
+
+func (e *Estimator) histogram(instance string) *Histogram {
+	h, ok := e.Instances[instance]
+	if !ok {
+		h = &Histogram{Buckets: make(map[int]int64)}
+		e.Instances[instance] = h
+	}
+	return h
+}
+
+// Cutoff selects the optional items of an instance
+type Cutoff struct {
+	Bucket int   `json:"bucket"` // Items scoring above this bucket are evicted
+	Budget int64 `json:"budget"` // Bytes still to take from the boundary bucket
+}
+
+// SelectorState is the serializable state of a Selector
+type SelectorState struct {
+	Now     time.Time          `json:"now"`
+	Cutoffs map[string]*Cutoff `json:"cutoffs"`
+}
+
+// Cutoffs computes the thresholds that free bytesToFree bytes, sharing the
+// deficit between instances in proportion to their evictable bytes like Select
+func (p *Policy) Cutoffs(e *Estimator, bytesToFree int64) SelectorState {
+	state := SelectorState{Now: e.Now, Cutoffs: make(map[string]*Cutoff)}
+
+	instances := make([]string, 0, len(e.Instances))
+	remaining := make(map[string]int64, len(e.Instances))
+	deficit := bytesToFree
+	for instance, h := range e.Instances {
+		instances = append(instances, instance)
+		deficit -= h.MandatoryBytes
+		for _, bytes := range h.Buckets {
+			remaining[instance] += bytes
+		}
+	}
+	sort.Strings(instances)
+
+	shares := make(map[string]int64, len(instances))
+	for deficit > 0 {
+		var available int64
+		for _, instance := range instances {
+			available += remaining[instance]
+		}
+		if available == 0 {
+			break
+		}
+
+		var taken int64
+		for _, instance := range instances {
+			if remaining[instance] == 0 {
+				continue
+			}
+			share := int64(float64(deficit) * float64(remaining[instance]) / float64(available))
+			share = min(max(share, 1), remaining[instance])
+			shares[instance] += share
+			remaining[instance] -= share
+			taken += share
+		}
+		deficit -= taken
+	}
+
+	for instance, share := range shares {
+		h := e.Instances[instance]
+		buckets := make([]int, 0, len(h.Buckets))
+		for b := range h.Buckets {
+			buckets = append(buckets, b)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(buckets)))
+
+		var above int64
+		for _, b := range buckets {
+			if above+h.Buckets[b] >= share {
+				state.Cutoffs[instance] = &Cutoff{Bucket: b, Budget: share - above}
+				break
+			}
+			above += h.Buckets[b]
+		}
+	}
+
+	return state
+}
+
+// Selector decides on items as they are listed. It is safe for concurrent use.
+type Selector struct {
+	policy *Policy
+
+	mu    sync.Mutex
+	state SelectorState
+}
+
+// NewSelector returns a selector resuming from state
+func (p *Policy) NewSelector(state SelectorState) *Selector {
+	return &Selector{policy: p, state: state}
+}
+
+// Decide reports whether item is evicted
+func (s *Selector) Decide(item Item) (Decision, bool) {
+	score, d := s.policy.score(item, s.state.Now)
+	if d.Mandatory {
+		return d, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.state.Cutoffs[item.Instance]
+	if !ok {
+		return Decision{}, false
+	}
+
+	b := scoreBucket(score)
+	switch {
+	case b > c.Bucket:
+		return d, true
+	case b == c.Bucket && c.Budget > 0:
+		c.Budget -= item.Size
+		return d, true
+	}
+	return Decision{}, false
+}
+
+// State returns a copy of the selector state for checkpoints
+func (s *Selector) State() SelectorState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := SelectorState{Now: s.state.Now, Cutoffs: make(map[string]*Cutoff, len(s.state.Cutoffs))}
+	for instance, c := range s.state.Cutoffs {
+		copied := *c
+		state.Cutoffs[instance] = &copied
+	}
+	return state
+}
+
+func scoreBucket(score float64) int {
+	switch {
+	case score <= 0:
+		return math.MinInt32
+	case math.IsInf(score, 1):
+		return math.MaxInt32
+	}
+	return int(math.Floor(math.Log2(score) * bucketsPerOctave))
+}
+
+// KeySet is a compact set of keys stored as 64-bit hashes. With n keys a false
+// positive has a probability of about n²/2⁶⁵, so callers only rely on
+// membership where a false positive costs a cache miss rather than
+// correctness. It is safe for concurrent use.
+type KeySet struct {
+	mu      sync.Mutex
+	sorted  []uint64
+	pending map[uint64]struct{}
+}
+
+// NewKeySet returns an empty set
+func NewKeySet() *KeySet {
+	return &KeySet{pending: make(map[uint64]struct{})}
+}
+
+// Add inserts key
+func (s *KeySet) Add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[hashKey(key)] = struct{}{}
+	// Pending hashes cost several times more memory than sorted ones, merge
+	// them once they outgrow a quarter of the set to keep merging amortized
+	if len(s.pending) > max(minPendingKeys, len(s.sorted)/4) {
+		s.compact()
+	}
+}
+
+// Contains reports whether key was added
+func (s *KeySet) Contains(key string) bool {
+	h := hashKey(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pending[h]; ok {
+		return true
+	}
+	i := sort.Search(len(s.sorted), func(i int) bool { return s.sorted[i] >= h })
+	return i < len(s.sorted) && s.sorted[i] == h
+}
+
+// Len returns the number of keys
+func (s *KeySet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compact()
+	return len(s.sorted)
+}
+
+// MarshalBinary encodes the set as sorted little-endian hashes
+func (s *KeySet) MarshalBinary() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compact()
+
+	data := make([]byte, 8*len(s.sorted))
+	for i, h := range s.sorted {
+		binary.LittleEndian.PutUint64(data[8*i:], h)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary replaces the set with one encoded by MarshalBinary
+func (s *KeySet) UnmarshalBinary(data []byte) error {
+	if len(data)%8 != 0 {
+		return errors.New("key set length is not a multiple of 8")
+	}
+
+	sorted := make([]uint64, len(data)/8)
+	for i := range sorted {
+		sorted[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sorted = sorted
+	s.pending = make(map[uint64]struct{})
+	return nil
+}
+
+// compact merges pending hashes into the sorted slice, called with mu held
+func (s *KeySet) compact() {
+	if len(s.pending) == 0 {
+		return
+	}
+	for h := range s.pending {
+		s.sorted = append(s.sorted, h)
+	}
+	sort.Slice(s.sorted, func(i, j int) bool { return s.sorted[i] < s.sorted[j] })
+
+	deduped := s.sorted[:0]
+	for i, h := range s.sorted {
+		if i == 0 || h != s.sorted[i-1] {
+			deduped = append(deduped, h)
+		}
+	}
+	s.sorted = deduped
+	s.pending = make(map[uint64]struct{})
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
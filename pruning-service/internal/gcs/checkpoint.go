@@ -0,0 +1,223 @@
+package gcs
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/eviction"
+)
+
+// Pruning phases, in order
+const (
+	phaseScan    = "scan"    // Estimate score histograms and index references
+	phaseDelete  = "delete"  // Delete objects selected by the cutoffs
+	phaseCascade = "cascade" // Delete provenance and action results left behind
+	phaseDone    = "done"
+)
+
+// run is the state of a pruning run, persisted as the checkpoint. Key sets are
+// stored in objects next to it.
+type run struct {
+	StartedAt  time.Time               `json:"started_at"`
+	Phase      string                  `json:"phase"`
+	Shards     []shard                 `json:"shards"`
+	ShardsDone []bool                  `json:"shards_done"` // For the current phase
+	Estimator  *eviction.Estimator     `json:"estimator"`
+	Selector   *eviction.SelectorState `json:"selector,omitempty"`
+	Stats      Stats                   `json:"stats"`
+	Plan       *Plan                   `json:"plan"`
+
+	mu        sync.Mutex
+	refs      *eviction.ReferenceIndex // Built while scanning
+	selected  *eviction.KeySet         // Keys deleted, or selected in dry-run mode
+	pinned    *eviction.KeySet         // Keys held by pins, reloaded by every process
+	decisions *eviction.DeletionLog    // Deletions not yet written to the decision log
+	selector  *eviction.Selector
+	lastSaved time.Time
+}
+
+func (r *run) progress() float64 {
+	var done int
+	for _, d := range r.ShardsDone {
+		if d {
+			done++
+		}
+	}
+	return float64(done) / float64(len(r.ShardsDone))
+}
+
+// startPhase moves to the next phase with every shard to do
+func (r *run) startPhase(phase string) {
+	r.Phase = phase
+	r.ShardsDone = make([]bool, len(r.Shards))
+}
+
+// keySets names the sets persisted for the current phase
+func (r *run) keySets() map[string]*eviction.KeySet {
+	if r.Phase == phaseScan {
+		return map[string]*eviction.KeySet{"referenced": r.refs.Referenced, "protected": r.refs.Protected}
+	}
+	return map[string]*eviction.KeySet{"selected": r.selected}
+}
+
+// newRun plans the shards of a fresh run
+func (c *Client) newRun(ctx context.Context, bucket *storage.BucketHandle) (*run, error) {
+	shards, err := planShards(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Listing bucket in %d shards", len(shards))
+
+	now := time.Now()
+	r := &run{
+		StartedAt: now,
+		Shards:    shards,
+		Estimator: eviction.NewEstimator(now),
+		Plan:      newPlan(0, c.cfg.MaxTotalBytes, c.cfg.DryRun, c.cfg.MaxReportCandidates),
+		refs:      eviction.NewReferenceIndex(),
+		selected:  eviction.NewKeySet(),
+	}
+	if c.cfg.SoftDelete && !c.cfg.DryRun {
+		r.Plan.RunID = runID(now)
+		log.Printf("Moving pruned objects to the trash as run %s", r.Plan.RunID)
+	}
+	r.startPhase(phaseScan)
+	return r, nil
+}
+
+// resume continues the run recorded in the checkpoint, or starts a new one if
+// there is none, it is too old, or it cannot be read. Dry runs change nothing
+// and never checkpoint.
+func (c *Client) resume(ctx context.Context, bucket *storage.BucketHandle) (*run, error) {
+	if c.cfg.CheckpointObject == "" || c.cfg.DryRun {
+		return c.newRun(ctx, bucket)
+	}
+
+	r, err := c.loadCheckpoint(ctx, bucket)
+	switch {
+	case errors.Is(err, storage.ErrObjectNotExist):
+		return c.newRun(ctx, bucket)
+	case err != nil:
+		log.Printf("Ignoring unreadable checkpoint: %v", err)
+		return c.newRun(ctx, bucket)
+	case time.Since(r.StartedAt) > c.cfg.CheckpointMaxAge:
+		log.Printf("Ignoring checkpoint from %v, older than %v", r.StartedAt, c.cfg.CheckpointMaxAge)
+		return c.newRun(ctx, bucket)
+	}
+
+	log.Printf("Resuming pruning run from %v in phase %s (%.0f%% of shards done)", r.StartedAt, r.Phase, r.progress()*100)
+	return r, nil
+}
+
+func (c *Client) loadCheckpoint(ctx context.Context, bucket *storage.BucketHandle) (*run, error) {
+	data, err := readAll(ctx, bucket, c.cfg.CheckpointObject)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &run{refs: eviction.NewReferenceIndex(), selected: eviction.NewKeySet()}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	if r.Plan == nil || r.Estimator == nil || len(r.ShardsDone) != len(r.Shards) {
+		return nil, errors.New("incomplete checkpoint")
+	}
+	r.Plan.limit = c.cfg.MaxReportCandidates
+
+	// The scan's references are needed by every later phase
+	sets := map[string]encoding.BinaryUnmarshaler{"referenced": r.refs.Referenced, "protected": r.refs.Protected}
+	if r.Phase != phaseScan {
+		sets["selected"] = r.selected
+	}
+	for name, set := range sets {
+		data, err := readAll(ctx, bucket, c.cfg.CheckpointObject+"."+name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s keys: %w", name, err)
+		}
+		if err := set.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("failed to parse %s keys: %w", name, err)
+		}
+	}
+
+	if r.Selector != nil {
+		r.selector = c.cfg.Eviction.NewSelector(*r.Selector)
+	}
+	return r, nil
+}
+
+// saveCheckpoint writes the run state, at most once per CheckpointInterval
+// unless force is set. Failures are logged, the run carries on.
+func (c *Client) saveCheckpoint(ctx context.Context, bucket *storage.BucketHandle, r *run, force bool) {
+	if c.cfg.CheckpointObject == "" || c.cfg.DryRun {
+		return
+	}
+
+	r.mu.Lock()
+	if !force && time.Since(r.lastSaved) < c.cfg.CheckpointInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastSaved = time.Now()
+	if r.selector != nil {
+		state := r.selector.State()
+		r.Selector = &state
+	}
+	data, err := json.Marshal(r)
+	r.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to encode checkpoint: %v", err)
+		return
+	}
+
+	// Key sets first, so the checkpoint never refers to older sets than it saw
+	for name, set := range r.keySets() {
+		keys, _ := set.MarshalBinary()
+		if err := writeAll(ctx, bucket, c.cfg.CheckpointObject+"."+name, keys); err != nil {
+			log.Printf("Failed to write checkpoint %s keys: %v", name, err)
+			return
+		}
+	}
+	if err := writeAll(ctx, bucket, c.cfg.CheckpointObject, data); err != nil {
+		log.Printf("Failed to write checkpoint: %v", err)
+	}
+}
+
+// clearCheckpoint removes the checkpoint of a finished run
+func (c *Client) clearCheckpoint(ctx context.Context, bucket *storage.BucketHandle) {
+	if c.cfg.CheckpointObject == "" || c.cfg.DryRun {
+		return
+	}
+	for _, name := range []string{"", ".referenced", ".protected", ".selected"} {
+		err := bucket.Object(c.cfg.CheckpointObject + name).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			log.Printf("Failed to remove checkpoint %s: %v", c.cfg.CheckpointObject+name, err)
+		}
+	}
+}
+
+func readAll(ctx context.Context, bucket *storage.BucketHandle, name string) ([]byte, error) {
+	r, err := bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func writeAll(ctx context.Context, bucket *storage.BucketHandle, name string, data []byte) error {
+	w := bucket.Object(name).NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,85 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/eviction"
+)
+
+// prunerName identifies this service's deletions in the decision log the
+// cache server queries
+const prunerName = "pruning-service"
+
+// recordDecision adds a deleted object to the decision log, flushing it when
+// the buffer is full
+func (c *Client) recordDecision(ctx context.Context, bucket *storage.BucketHandle, r *run, obj *storage.ObjectAttrs, reason string) {
+	item := evictionItem(*obj)
+	full := r.decisions.Add(eviction.Deletion{
+		Key:          item.Key,
+		Size:         item.Size,
+		LastAccessed: item.LastAccessed,
+		Reason:       reason,
+		DeletedAt:    time.Now(),
+		Trashed:      r.Plan.RunID != "",
+	})
+	if full {
+		c.flushDecisions(ctx, bucket, r)
+	}
+}
+
+// flushDecisions writes the buffered decisions. A failure loses them but does
+// not stop the run.
+func (c *Client) flushDecisions(ctx context.Context, bucket *storage.BucketHandle, r *run) {
+	if r.decisions == nil {
+		return
+	}
+	err := r.decisions.Flush(func(object string, data []byte) error {
+		return writeAll(ctx, bucket, object, data)
+	})
+	if err != nil {
+		log.Printf("Failed to record pruning decisions: %v", err)
+	}
+}
+
+// purgeDecisions deletes decisions recorded more than DecisionRetention ago
+func (c *Client) purgeDecisions(ctx context.Context, bucket *storage.BucketHandle) error {
+	if c.cfg.DecisionRetention <= 0 || c.cfg.DryRun {
+		return nil
+	}
+	cutoff := time.Now().Add(-c.cfg.DecisionRetention)
+
+	var purged int
+	it := bucket.Objects(ctx, &storage.Query{Prefix: eviction.DeletionPrefix})
+	for {
+		obj, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list decisions: %w", err)
+		}
+
+		flushed, ok := eviction.DeletionObjectTime(obj.Name)
+		if !ok {
+			flushed = obj.Updated
+		}
+		if !flushed.Before(cutoff) {
+			continue
+		}
+		if err := bucket.Object(obj.Name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return fmt.Errorf("failed to delete %s: %w", obj.Name, err)
+		}
+		purged++
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d decision log objects", purged)
+	}
+	return nil
+}
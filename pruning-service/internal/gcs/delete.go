@@ -0,0 +1,137 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/metrics"
+)
+
+// Retry backoff bounds for transient deletion errors
+const (
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// deleter runs deletions on a bounded number of goroutines shared by all
+// listers, capped at DeleteRate requests per second. With a trash run set,
+// objects are moved to the trash instead of being deleted.
+type deleter struct {
+	bucket   *storage.BucketHandle
+	retries  int
+	limiter  *rate.Limiter
+	slots    chan struct{}
+	trashRun string
+}
+
+func newDeleter(cfg Config, bucket *storage.BucketHandle, trashRun string) *deleter {
+	workers := max(cfg.DeleteConcurrency, 1)
+
+	limit := rate.Inf
+	if cfg.DeleteRate > 0 {
+		limit = rate.Limit(cfg.DeleteRate)
+	}
+
+	return &deleter{
+		bucket:   bucket,
+		retries:  cfg.DeleteRetries,
+		limiter:  rate.NewLimiter(limit, workers),
+		slots:    make(chan struct{}, workers),
+		trashRun: trashRun,
+	}
+}
+
+// permanent returns a deleter sharing d's workers and rate limit that always
+// deletes, for purging the trash
+func (d *deleter) permanent() *deleter {
+	p := *d
+	p.trashRun = ""
+	return &p
+}
+
+// submit deletes obj in the background once a worker is free and calls done
+// with the outcome. skipped reports an object that was already gone or was
+// rewritten since it was listed. wg tracks the deletion.
+func (d *deleter) submit(ctx context.Context, wg *sync.WaitGroup, obj *storage.ObjectAttrs, done func(skipped bool, err error)) error {
+	select {
+	case d.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() { <-d.slots }()
+
+		metrics.DeletionsInFlight.Inc()
+		skipped, err := d.deleteWithRetry(ctx, obj)
+		metrics.DeletionsInFlight.Dec()
+
+		done(skipped, err)
+	}()
+	return nil
+}
+
+// deleteWithRetry deletes the listed generation of an object, retrying
+// transient errors with jittered exponential backoff. The generation
+// precondition makes the delete idempotent and leaves objects rewritten since
+// the listing alone.
+func (d *deleter) deleteWithRetry(ctx context.Context, obj *storage.ObjectAttrs) (skipped bool, err error) {
+	backoff := retryBaseDelay
+
+	for attempt := 0; ; attempt++ {
+		if err := d.limiter.Wait(ctx); err != nil {
+			return false, err
+		}
+
+		err := d.remove(ctx, obj)
+		switch {
+		case err == nil:
+			return false, nil
+		case errors.Is(err, storage.ErrObjectNotExist), isPreconditionFailed(err):
+			return true, nil
+		case ctx.Err() != nil:
+			return false, ctx.Err()
+		case attempt >= d.retries || !storage.ShouldRetry(err):
+			return false, err
+		}
+
+		metrics.DeletionRetries.Inc()
+
+		delay := time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		if backoff < retryMaxDelay {
+			backoff *= 2
+		}
+	}
+}
+
+// remove deletes the listed generation of obj, copying it to the trash first
+// in soft-delete mode. A retry after the copy succeeded copies it again.
+func (d *deleter) remove(ctx context.Context, obj *storage.ObjectAttrs) error {
+	handle := d.bucket.Object(obj.Name).If(storage.Conditions{GenerationMatch: obj.Generation})
+	if d.trashRun != "" {
+		if err := copyToTrash(ctx, d.bucket, handle, obj, d.trashRun); err != nil {
+			return err
+		}
+	}
+	return handle.Delete(ctx)
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
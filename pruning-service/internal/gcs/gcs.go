@@ -3,20 +3,41 @@ package gcs
 import (
 	"cloud.google.com/go/storage"
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"sort"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/eviction"
 	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/metrics"
 )
 
+// checkpointSaveTimeout bounds the final checkpoint write of an interrupted run
+const checkpointSaveTimeout = 30 * time.Second
+
 type Config struct {
-	ProjectID       string
-	Bucket          string
-	MaxTotalBytes   int64
-	MinAgeToDelete  time.Duration
-	DeleteBatchSize int
+	ProjectID           string
+	Bucket              string
+	MaxTotalBytes       int64
+	MinAgeToDelete      time.Duration
+	DeleteBatchSize     int                       // Progress is logged every DeleteBatchSize deletions
+	DeleteConcurrency   int                       // Parallel delete requests
+	DeleteRate          float64                   // Delete requests per second, 0 for unlimited
+	DeleteRetries       int                       // Retries per object for transient errors
+	DryRun              bool                      // Build the plan without deleting anything
+	Eviction            *eviction.Policy          // Per-instance eviction strategies
+	ReferenceAware      bool                      // Keep action cache entries consistent with the CAS blobs they reference
+	References          eviction.ReferenceOptions // Orphan grace period and protect window
+	ListParallelism     int                       // Shards listed concurrently
+	CheckpointObject    string                    // Object recording progress so an interrupted run resumes, empty to disable
+	CheckpointInterval  time.Duration             // Minimum time between checkpoint writes
+	CheckpointMaxAge    time.Duration             // Older checkpoints are discarded and the run starts over
+	MaxReportCandidates int                       // Candidates listed in the plan, 0 for all
+	SoftDelete          bool                      // Move pruned objects to the trash instead of deleting them
+	TrashRetention      time.Duration             // How long trashed objects can be restored before they are purged
+	DecisionRetention   time.Duration             // How long deletions stay in the decision log, 0 to keep them
 }
 
 type Client struct {
@@ -25,13 +46,29 @@ type Client struct {
 }
 
 type Stats struct {
-	Scanned    int64
-	Deleted    int64
-	BytesFreed int64
-	Total      int64
+	Scanned    int64 `json:"scanned"`
+	Deleted    int64 `json:"deleted"`
+	BytesFreed int64 `json:"bytes_freed"`
+	Total      int64 `json:"total"`
+
+	// Trashed objects permanently deleted, or that would be in dry-run mode
+	Purged      int64 `json:"purged"`
+	PurgedBytes int64 `json:"purged_bytes"`
+}
+
+// Efficiency is the percentage of the bucket's size at the start of the run
+// that was freed
+func (s Stats) Efficiency() float64 {
+	if s.Total+s.BytesFreed == 0 {
+		return 0
+	}
+	return float64(s.BytesFreed) / float64(s.Total+s.BytesFreed) * 100
 }
 
 func NewClient(ctx context.Context, cfg Config) (*Client, error) {
+	if err := cfg.Eviction.Streamable(); err != nil {
+		return nil, err
+	}
 	client, err := storage.NewClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage client: %w", err)
@@ -43,101 +80,313 @@ func (c *Client) Close() error {
 	return c.client.Close()
 }
 
-func (c *Client) Prune(ctx context.Context) (Stats, error) {
+// Prune deletes objects whose TTL passed, then objects until the bucket is
+// under MaxTotalBytes, and returns the plan of selected objects. In dry-run
+// mode nothing is deleted and Stats reports what would have been freed.
+//
+// The bucket is listed in shards, in parallel and without holding the listing
+// in memory. A scan phase builds score histograms and indexes the blobs action
+// results reference, a delete phase deletes the objects above the cutoffs
+// derived from the histograms, and a cascade phase deletes the provenance of
+// deleted action results and action results left pointing at deleted blobs.
+// Provenance is never selected by the strategies. Progress is checkpointed after completed shards,
+// so an interrupted run resumes where it stopped. If ctx is cancelled the
+// stats and plan cover the work done so far.
+func (c *Client) Prune(ctx context.Context) (Stats, *Plan, error) {
 	startTime := time.Now()
+	defer func() {
+		metrics.PruningDuration.Observe(time.Since(startTime).Seconds())
+	}()
 	bucket := c.client.Bucket(c.cfg.Bucket)
 	
-	// List all objects
-	query := &storage.Query{Prefix: ""}
-	it := bucket.Objects(ctx, query)
-	
-	var objects []storage.ObjectAttrs
-	var totalBytes int64
+	r, err := c.resume(ctx, bucket)
+	if err != nil {
+		return Stats{}, nil, err
+	}
+	if r.pinned, err = loadPins(ctx, bucket, r.Estimator.Now); err != nil {
+		return Stats{}, nil, err
+	}
+	d := newDeleter(c.cfg, bucket, r.Plan.RunID)
+	if !c.cfg.DryRun {
+		r.decisions = eviction.NewDeletionLog(prunerName, runID(r.StartedAt))
+	}
 	
-	log.Println("Scanning bucket objects...")
-	for {
-		attrs, err := it.Next()
-		if err == storage.IteratorDone {
-			break
+	for r.Phase != phaseDone {
+		log.Printf("Starting %s phase", r.Phase)
+		
+		var err error
+		switch r.Phase {
+		case phaseScan:
+			err = c.scan(ctx, bucket, r)
+		case phaseDelete:
+			err = c.deleteSelected(ctx, r, d)
+		case phaseCascade:
+			err = c.cascade(ctx, bucket, r, d)
 		}
 		if err != nil {
-			return Stats{}, fmt.Errorf("failed to list objects: %w", err)
+			// Record the completed shards so the next run resumes after them
+			saveCtx, cancel := context.WithTimeout(context.Background(), checkpointSaveTimeout)
+			c.flushDecisions(saveCtx, bucket, r)
+			c.saveCheckpoint(saveCtx, bucket, r, true)
+			cancel()
+			return r.Stats, r.Plan, fmt.Errorf("pruning interrupted in %s phase after %d deletions: %w", r.Phase, r.Stats.Deleted, err)
 		}
-		objects = append(objects, *attrs)
-		totalBytes += attrs.Size
+		
+		c.flushDecisions(ctx, bucket, r)
+		c.saveCheckpoint(ctx, bucket, r, true)
+		c.nextPhase(r)
 	}
 	
-	log.Printf("Found %d objects, total size: %d bytes (%.2f GB)", 
-		len(objects), totalBytes, float64(totalBytes)/1024/1024/1024)
+	if err := c.purgeTrash(ctx, bucket, r, d); err != nil {
+		return r.Stats, r.Plan, fmt.Errorf("failed to purge trash: %w", err)
+	}
+	if err := c.purgeDecisions(ctx, bucket); err != nil {
+		return r.Stats, r.Plan, fmt.Errorf("failed to purge decisions: %w", err)
+	}
+	c.clearCheckpoint(ctx, bucket)
 	
-	// Update metrics
-	metrics.TotalBytes.Set(float64(totalBytes))
-	metrics.ObjectsScanned.Set(float64(len(objects)))
+	log.Printf("Pruning completed in %v", time.Since(startTime))
 	
-	// Check if pruning is needed
-	if totalBytes <= c.cfg.MaxTotalBytes {
-		log.Printf("Total size (%d) is under limit (%d), no pruning needed", totalBytes, c.cfg.MaxTotalBytes)
-		return Stats{
-			Scanned: int64(len(objects)),
-			Total:   totalBytes,
-		}, nil
+	return r.Stats, r.Plan, nil
+}
+
+// nextPhase moves a run on once every shard of its phase is done
+func (c *Client) nextPhase(r *run) {
+	switch r.Phase {
+	case phaseScan:
+		log.Printf("Found %d objects, total size: %d bytes (%.2f GB)", 
+			r.Stats.Scanned, r.Stats.Total, float64(r.Stats.Total)/1024/1024/1024)
+		metrics.TotalBytes.Set(float64(r.Stats.Total))
+		r.Plan.TotalBytes = r.Stats.Total
+		
+		if r.Stats.Total <= c.cfg.MaxTotalBytes {
+			// Without a selector the delete phase only removes expired objects
+			log.Printf("Total size (%d) is under limit (%d), deleting expired objects only", r.Stats.Total, c.cfg.MaxTotalBytes)
+			r.startPhase(phaseDelete)
+			return
+		}
+		
+		log.Printf("Pruning needed: current=%d target=%d excess=%d", 
+			r.Stats.Total, c.cfg.MaxTotalBytes, r.Stats.Total-c.cfg.MaxTotalBytes)
+		
+		state := c.cfg.Eviction.Cutoffs(r.Estimator, r.Stats.Total-c.cfg.MaxTotalBytes)
+		r.Selector = &state
+		r.selector = c.cfg.Eviction.NewSelector(state)
+		r.startPhase(phaseDelete)
+	case phaseDelete:
+		r.startPhase(phaseCascade)
+	default:
+		r.Phase = phaseDone
 	}
+}
+
+// scan builds the score histograms of objects older than MinAgeToDelete and
+// indexes the blobs every action result references
+func (c *Client) scan(ctx context.Context, bucket *storage.BucketHandle, r *run) error {
+	now := r.Estimator.Now
+	threshold := now.Add(-c.cfg.MinAgeToDelete)
 	
-	log.Printf("Pruning needed: current=%d target=%d excess=%d", 
-		totalBytes, c.cfg.MaxTotalBytes, totalBytes-c.cfg.MaxTotalBytes)
-	
-	// Sort by updated time (oldest first) for LRU approximation
-	sort.Slice(objects, func(i, j int) bool {
-		return objects[i].Updated.Before(objects[j].Updated)
+	return c.forEachShard(ctx, bucket, r, func() shardVisitor {
+		// Shard totals are only merged once the shard completes, so a resumed
+		// run does not count a partially listed shard twice
+		est := eviction.NewEstimator(now)
+		var objects, bytes int64
+		
+		return shardVisitor{
+			visit: func(ctx context.Context, obj *storage.ObjectAttrs) error {
+				objects++
+				bytes += obj.Size
+				
+				item := evictionItem(*obj)
+				if c.cfg.ReferenceAware && eviction.IsActionResultKey(item.Key) {
+					ref, err := readReferences(ctx, bucket, item.Key)
+					if err != nil {
+						log.Printf("Skipping references of %s: %v", obj.Name, err)
+					} else {
+						ref.LastAccessed = item.LastAccessed
+						r.refs.Add(ref, now, c.cfg.References)
+					}
+				}
+				
+				// Objects younger than MinAgeToDelete or pinned are never candidates
+				if !obj.Updated.After(threshold) && !r.pinned.Contains(item.Key) && !eviction.IsProvenanceKey(item.Key) {
+					est.Add(c.cfg.Eviction, item)
+				}
+				return nil
+			},
+			commit: func() {
+				r.Estimator.Merge(est)
+				r.Stats.Scanned += objects
+				r.Stats.Total += bytes
+				metrics.ObjectsScanned.Set(float64(r.Stats.Scanned))
+			},
+		}
 	})
+}
+
+// deleteSelected deletes expired objects, then orphaned blobs and the objects
+// above the cutoffs, keeping blobs of recently used action results and pinned
+// objects. Expired objects are deleted even when younger than MinAgeToDelete,
+// since they already read as misses.
+func (c *Client) deleteSelected(ctx context.Context, r *run, d *deleter) error {
+	now := r.Estimator.Now
+	threshold := now.Add(-c.cfg.MinAgeToDelete)
 	
-	// Delete objects respecting MinAgeToDelete
-	threshold := time.Now().Add(-c.cfg.MinAgeToDelete)
-	var deleted, bytesFreed int64
-	
-	log.Printf("Deleting objects older than %v", threshold)
-	
-	for _, obj := range objects {
-		if totalBytes <= c.cfg.MaxTotalBytes {
-			break
+	return c.forEachShard(ctx, d.bucket, r, func() shardVisitor {
+		var wg sync.WaitGroup
+		return shardVisitor{
+			visit: func(ctx context.Context, obj *storage.ObjectAttrs) error {
+				item := evictionItem(*obj)
+				if r.pinned.Contains(item.Key) {
+					return nil
+				}
+				if item.Expired(now) {
+					return c.evict(ctx, d, &wg, r, obj, item.Key, eviction.ReasonExpired)
+				}
+				// Provenance is deleted with its action result by the cascade
+				if r.selector == nil || obj.Updated.After(threshold) || eviction.IsProvenanceKey(item.Key) {
+					return nil
+				}
+				if c.cfg.ReferenceAware {
+					protected, orphan := r.refs.Check(item, now, c.cfg.References)
+					switch {
+					case protected:
+						return nil
+					case orphan:
+						return c.evict(ctx, d, &wg, r, obj, item.Key, "orphan")
+					}
+				}
+				
+				decision, ok := r.selector.Decide(item)
+				if !ok {
+					return nil
+				}
+				return c.evict(ctx, d, &wg, r, obj, item.Key, decision.Reason)
+			},
+			wait: wg.Wait,
 		}
-		
-		if obj.Updated.After(threshold) {
-			log.Printf("Skipping recent object: %s (updated: %v)", obj.Name, obj.Updated)
-			continue
+	})
+}
+
+// cascade deletes the provenance of deleted action results and, with
+// reference awareness, action results that reference a deleted blob
+func (c *Client) cascade(ctx context.Context, bucket *storage.BucketHandle, r *run, d *deleter) error {
+	return c.forEachShard(ctx, bucket, r, func() shardVisitor {
+		var wg sync.WaitGroup
+		return shardVisitor{
+			visit: func(ctx context.Context, obj *storage.ObjectAttrs) error {
+				item := evictionItem(*obj)
+				
+				acKey := item.Key
+				switch {
+				case r.pinned.Contains(item.Key):
+					return nil
+				case r.selected.Contains(item.Key):
+					// Already in the plan, only listed again in dry-run mode
+					return nil
+				case eviction.IsProvenanceKey(item.Key):
+					acKey = eviction.ActionResultKey(item.Key)
+					if r.selected.Contains(acKey) {
+						return c.evict(ctx, d, &wg, r, obj, item.Key, "dangling_reference")
+					}
+					if !c.cfg.ReferenceAware {
+						return nil
+					}
+				case !c.cfg.ReferenceAware || !eviction.IsActionResultKey(item.Key):
+					return nil
+				}
+				
+				// Provenance shares the fate of its action result, which may
+				// sit in a shard that is not cascaded yet
+				ref, err := readReferences(ctx, bucket, acKey)
+				if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+					log.Printf("Skipping references of %s: %v", acKey, err)
+					return nil
+				}
+				if err == nil && !eviction.Dangling(ref, r.selected) {
+					return nil
+				}
+				return c.evict(ctx, d, &wg, r, obj, item.Key, "dangling_reference")
+			},
+			wait: wg.Wait,
 		}
-		
-		log.Printf("Deleting object: %s (size: %d, updated: %v)", obj.Name, obj.Size, obj.Updated)
-		
-		if err := bucket.Object(obj.Name).Delete(ctx); err != nil {
+	})
+}
+
+// evict deletes an object, or only records it in dry-run mode. The key is
+// marked selected right away so the cascade sees it even if the delete is
+// still in flight.
+func (c *Client) evict(ctx context.Context, d *deleter, wg *sync.WaitGroup, r *run, obj *storage.ObjectAttrs, key, reason string) error {
+	r.selected.Add(key)
+	
+	if c.cfg.DryRun {
+		r.record(obj, reason, c.cfg.DeleteBatchSize)
+		return nil
+	}
+	
+	return d.submit(ctx, wg, obj, func(skipped bool, err error) {
+		switch {
+		case err != nil:
 			log.Printf("Failed to delete %s: %v", obj.Name, err)
 			metrics.DeletionErrors.Inc()
-			continue
+			return
+		case skipped:
+			metrics.DeletionsSkipped.Inc()
+			return
 		}
 		
-		deleted++
-		bytesFreed += obj.Size
-		totalBytes -= obj.Size
-		
-		// Update metrics
 		metrics.ObjectsDeleted.Inc()
 		metrics.BytesFreed.Add(float64(obj.Size))
-		
-		// Batch size check
-		if int(deleted)%c.cfg.DeleteBatchSize == 0 {
-			log.Printf("Deleted %d objects so far, freed %d bytes", deleted, bytesFreed)
-		}
-	}
+		c.recordDecision(ctx, d.bucket, r, obj, reason)
+		total := r.record(obj, reason, c.cfg.DeleteBatchSize)
+		metrics.TotalBytes.Set(float64(total))
+	})
+}
+
+// record adds a deleted object to the plan and stats, returning the remaining total
+func (r *run) record(obj *storage.ObjectAttrs, reason string, logEvery int) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	
-	duration := time.Since(startTime)
-	metrics.PruningDuration.Observe(duration.Seconds())
+	r.Plan.add(newCandidate(*obj, reason))
+	r.Stats.Deleted++
+	r.Stats.BytesFreed += obj.Size
+	r.Stats.Total -= obj.Size
 	
-	log.Printf("Pruning completed in %v", duration)
+	if logEvery > 0 && r.Stats.Deleted%int64(logEvery) == 0 {
+		log.Printf("Deleted %d objects so far, freed %d bytes", r.Stats.Deleted, r.Stats.BytesFreed)
+	}
+	return r.Stats.Total
+}
+
+// recordPurge counts a permanently deleted trash object
+func (r *run) recordPurge(obj *storage.ObjectAttrs) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	
-	return Stats{
-		Scanned:    int64(len(objects)),
-		Deleted:    deleted,
-		BytesFreed: bytesFreed,
-		Total:      totalBytes,
-	}, nil
+	r.Stats.Purged++
+	r.Stats.PurgedBytes += obj.Size
+}
+
+// evictionItem describes an object to the eviction strategies using the
+// access metadata the cache server maintains
+func evictionItem(obj storage.ObjectAttrs) eviction.Item {
+	c := newCandidate(obj, "")
+
+	lastAccessed := obj.Updated
+	if v, ok := obj.Metadata["last_accessed"]; ok {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			lastAccessed = t
+		}
+	}
+	accessCount, _ := strconv.ParseInt(obj.Metadata["access_count"], 10, 64)
+
+	return eviction.Item{
+		Key:          c.Key,
+		Instance:     c.Instance,
+		Size:         obj.Size,
+		LastAccessed: lastAccessed,
+		AccessCount:  accessCount,
+		ExpiresAt:    eviction.ExpiresAt(obj.Metadata),
+	}
 }
@@ -0,0 +1,54 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/eviction"
+)
+
+// loadPins reads the pins the cache server stores and returns the keys held
+// by those that have not expired. Pins are honored unconditionally, so an
+// unreadable pin fails the run rather than being skipped.
+func loadPins(ctx context.Context, bucket *storage.BucketHandle, now time.Time) (*eviction.KeySet, error) {
+	var pins []*eviction.Pin
+	it := bucket.Objects(ctx, &storage.Query{Prefix: eviction.PinPrefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pins: %w", err)
+		}
+		if !strings.HasSuffix(attrs.Name, ".json") {
+			continue
+		}
+
+		data, err := readAll(ctx, bucket, attrs.Name)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			continue // Removed since listing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pin %s: %w", attrs.Name, err)
+		}
+		pin, err := eviction.DecodePin(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", attrs.Name, err)
+		}
+		pins = append(pins, pin)
+	}
+
+	keys := eviction.PinnedKeys(pins, now)
+	if len(pins) > 0 {
+		log.Printf("Loaded %d pins holding %d objects", len(pins), keys.Len())
+	}
+	return keys, nil
+}
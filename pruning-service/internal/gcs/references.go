@@ -0,0 +1,87 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/eviction"
+)
+
+// maxReferenceRead bounds how much of an action result or tree is read
+const maxReferenceRead = 64 * 1024 * 1024
+
+// readReferences reads an action result, and the output directory trees it
+// points at, to find the CAS blobs it references
+func readReferences(ctx context.Context, bucket *storage.BucketHandle, key string) (eviction.References, error) {
+	var ref eviction.References
+
+	data, err := readEntry(ctx, bucket, key)
+	if err != nil {
+		return ref, fmt.Errorf("failed to read action result: %w", err)
+	}
+
+	files, trees, err := eviction.ActionResultDigests(data)
+	if err != nil {
+		return ref, fmt.Errorf("failed to parse action result: %w", err)
+	}
+
+	for _, hash := range files {
+		ref.Blobs = append(ref.Blobs, eviction.BlobKey(key, hash))
+	}
+	for _, hash := range trees {
+		treeKey := eviction.BlobKey(key, hash)
+		ref.Blobs = append(ref.Blobs, treeKey)
+
+		tree, err := readEntry(ctx, bucket, treeKey)
+		if err != nil {
+			if err != storage.ErrObjectNotExist {
+				log.Printf("Failed to read output tree %s: %v", treeKey, err)
+			}
+			continue
+		}
+		children, err := eviction.TreeDigests(tree)
+		if err != nil {
+			log.Printf("Failed to parse output tree %s: %v", treeKey, err)
+			continue
+		}
+		for _, child := range children {
+			ref.Blobs = append(ref.Blobs, eviction.BlobKey(key, child))
+		}
+	}
+
+	return ref, nil
+}
+
+// readEntry reads the object holding key, falling back to its legacy name
+func readEntry(ctx context.Context, bucket *storage.BucketHandle, key string) ([]byte, error) {
+	data, err := readObject(ctx, bucket, objectName(key))
+	legacy := legacyObjectName(key)
+	if err != storage.ErrObjectNotExist || legacy == objectName(key) {
+		return data, err
+	}
+
+	attrs, attrsErr := bucket.Object(legacy).Attrs(ctx)
+	switch {
+	case attrsErr == storage.ErrObjectNotExist:
+		return nil, err
+	case attrsErr != nil:
+		return nil, attrsErr
+	case attrs.Metadata["cache_key"] != key:
+		return nil, err
+	}
+	return readObject(ctx, bucket, legacy)
+}
+
+func readObject(ctx context.Context, bucket *storage.BucketHandle, name string) ([]byte, error) {
+	r, err := bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(io.LimitReader(r, maxReferenceRead))
+}
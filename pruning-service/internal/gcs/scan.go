@@ -0,0 +1,169 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/eviction"
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/metrics"
+)
+
+// objectPrefix is where the cache server stores entries
+const objectPrefix = "cache/"
+
+// splitChars subdivide each instance prefix. Hashes are hex, so CAS objects
+// spread evenly over the digit and a-f shards.
+const splitChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz"
+
+// shard is a contiguous range of object names, End is exclusive and empty for
+// the end of the bucket
+type shard struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// shardVisitor processes the objects of one shard
+type shardVisitor struct {
+	visit  func(context.Context, *storage.ObjectAttrs) error
+	wait   func() // Called once listing has stopped, e.g. to drain deletions
+	commit func() // Called with the run locked once every object was visited
+}
+
+// planShards partitions the cache objects into ranges. Object names flatten
+// the key's slashes to underscores, so a delimited listing finds each
+// instance's prefix, and every prefix is split on the next character. The
+// ranges cover the whole key space, objects outside the prefixes fall into the
+// ranges between them.
+func planShards(ctx context.Context, bucket *storage.BucketHandle) ([]shard, error) {
+	it := bucket.Objects(ctx, &storage.Query{Prefix: objectPrefix, Delimiter: "_"})
+
+	var bounds []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instance prefixes: %w", err)
+		}
+		if attrs.Prefix == "" {
+			continue
+		}
+		bounds = append(bounds, attrs.Prefix)
+		for _, c := range splitChars {
+			bounds = append(bounds, attrs.Prefix+string(c))
+		}
+	}
+	sort.Strings(bounds)
+
+	shards := make([]shard, 0, len(bounds)+1)
+	start := ""
+	for _, bound := range bounds {
+		shards = append(shards, shard{Start: start, End: bound})
+		start = bound
+	}
+	return append(shards, shard{Start: start}), nil
+}
+
+// forEachShard lists the shards not yet done with up to ListParallelism
+// listers. A shard is marked done once all of its objects were visited.
+// The first error stops all listers.
+func (c *Client) forEachShard(ctx context.Context, bucket *storage.BucketHandle, r *run, open func() shardVisitor) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan int)
+	go func() {
+		defer close(queue)
+		for i, done := range r.ShardsDone {
+			if done {
+				continue
+			}
+			select {
+			case queue <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i := 0; i < max(c.cfg.ListParallelism, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range queue {
+				v := open()
+				err := listShard(ctx, bucket, r.Shards[idx], v.visit)
+				if v.wait != nil {
+					v.wait()
+				}
+				if err == nil {
+					err = ctx.Err()
+				}
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+
+				r.mu.Lock()
+				if v.commit != nil {
+					v.commit()
+				}
+				r.ShardsDone[idx] = true
+				metrics.PruningProgress.Set(r.progress())
+				r.mu.Unlock()
+
+				c.saveCheckpoint(ctx, bucket, r, false)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+func listShard(ctx context.Context, bucket *storage.BucketHandle, s shard, visit func(context.Context, *storage.ObjectAttrs) error) error {
+	query := &storage.Query{Prefix: objectPrefix, StartOffset: s.Start, EndOffset: s.End}
+	if err := query.SetAttrSelection([]string{"Name", "Size", "Updated", "Generation", "ContentType", "Metadata"}); err != nil {
+		return err
+	}
+
+	it := bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list objects from %q: %w", s.Start, err)
+		}
+		if err := visit(ctx, attrs); err != nil {
+			return err
+		}
+	}
+}
+
+// objectName maps a cache key to the object the cache server stores it in
+func objectName(key string) string {
+	return objectPrefix + eviction.EscapeKey(key)
+}
+
+// legacyObjectName is where the cache server stored key before object names
+// were escaped. Several keys shared such names, so an object found there only
+// holds key if its recorded cache_key matches.
+func legacyObjectName(key string) string {
+	return objectPrefix + eviction.LegacyEscapeKey(key)
+}
@@ -0,0 +1,274 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/ruslanbaba/distributed-build-cache/pruning-service/internal/metrics"
+)
+
+// Soft-deleted objects are moved under trashPrefix with the name they had
+// under objectPrefix, and are purged once TrashRetention has passed. The cache
+// server uses the same layout, and restores trashed objects on a miss when
+// configured to.
+const (
+	trashPrefix  = "trash/"
+	trashedAtKey = "trashed_at" // RFC3339 time the object was trashed
+	trashRunKey  = "trash_run"  // ID of the pruning run that trashed it
+)
+
+// RestoreFilter selects trashed objects to restore. Every field that is set
+// must match.
+type RestoreFilter struct {
+	Key    string // Exact cache key
+	Prefix string // Cache key prefix
+	RunID  string // Pruning run that trashed the objects
+}
+
+// RestoreStats counts the outcome of a restore
+type RestoreStats struct {
+	Matched  int64 `json:"matched"`
+	Restored int64 `json:"restored"`
+	Bytes    int64 `json:"bytes"`
+	Skipped  int64 `json:"skipped"` // A newer object was written under the same key
+	Failed   int64 `json:"failed"`
+}
+
+// runID names a pruning run in the trash, so everything it removed can be
+// restored together. It is derived from the start time, so a resumed run
+// keeps its ID.
+func runID(startedAt time.Time) string {
+	return startedAt.UTC().Format("20060102T150405Z")
+}
+
+// trashName is the trash object holding the object stored at name
+func trashName(name string) string {
+	return trashPrefix + strings.TrimPrefix(name, objectPrefix)
+}
+
+// copyToTrash copies the listed generation of obj to the trash
+func copyToTrash(ctx context.Context, bucket *storage.BucketHandle, src *storage.ObjectHandle, obj *storage.ObjectAttrs, run string) error {
+	copier := bucket.Object(trashName(obj.Name)).CopierFrom(src)
+	copier.ContentType = obj.ContentType
+	copier.Metadata = make(map[string]string, len(obj.Metadata)+2)
+	for k, v := range obj.Metadata {
+		copier.Metadata[k] = v
+	}
+	copier.Metadata[trashedAtKey] = time.Now().Format(time.RFC3339)
+	copier.Metadata[trashRunKey] = run
+
+	_, err := copier.Run(ctx)
+	return err
+}
+
+// purgeTrash permanently deletes objects trashed more than TrashRetention ago.
+// In dry-run mode they are only counted.
+func (c *Client) purgeTrash(ctx context.Context, bucket *storage.BucketHandle, r *run, d *deleter) error {
+	cutoff := time.Now().Add(-c.cfg.TrashRetention)
+	d = d.permanent()
+
+	var (
+		wg        sync.WaitGroup
+		trashSize atomic.Int64
+	)
+	err := listTrash(ctx, bucket, trashPrefix, func(obj *storage.ObjectAttrs) error {
+		trashSize.Add(obj.Size)
+		if !trashedAt(obj).Before(cutoff) {
+			return nil
+		}
+
+		if c.cfg.DryRun {
+			r.recordPurge(obj)
+			return nil
+		}
+		return d.submit(ctx, &wg, obj, func(skipped bool, err error) {
+			switch {
+			case err != nil:
+				log.Printf("Failed to purge %s: %v", obj.Name, err)
+				metrics.DeletionErrors.Inc()
+			case !skipped:
+				metrics.ObjectsPurged.Inc()
+				trashSize.Add(-obj.Size)
+				r.recordPurge(obj)
+			}
+		})
+	})
+	wg.Wait()
+
+	metrics.TrashBytes.Set(float64(trashSize.Load()))
+	if r.Stats.Purged > 0 {
+		log.Printf("Purged %d trashed objects, %d bytes", r.Stats.Purged, r.Stats.PurgedBytes)
+	}
+	return err
+}
+
+// Restore moves trashed objects matching f back into the cache. Objects
+// written again since they were trashed are left alone. In dry-run mode the
+// matching objects are only counted.
+func (c *Client) Restore(ctx context.Context, f RestoreFilter) (RestoreStats, error) {
+	var stats RestoreStats
+	if f.Key == "" && f.Prefix == "" && f.RunID == "" {
+		return stats, errors.New("a key, prefix or run ID is required")
+	}
+
+	bucket := c.client.Bucket(c.cfg.Bucket)
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		slots = make(chan struct{}, max(c.cfg.DeleteConcurrency, 1))
+		err   error
+	)
+	restore := func(obj *storage.ObjectAttrs) error {
+		if f.RunID != "" && obj.Metadata[trashRunKey] != f.RunID {
+			return nil
+		}
+
+		mu.Lock()
+		stats.Matched++
+		mu.Unlock()
+		if c.cfg.DryRun {
+			return nil
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			restored, err := restoreObject(ctx, bucket, obj)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				log.Printf("Failed to restore %s: %v", obj.Name, err)
+				stats.Failed++
+			case !restored:
+				stats.Skipped++
+			default:
+				stats.Restored++
+				stats.Bytes += obj.Size
+				if c.cfg.DeleteBatchSize > 0 && stats.Restored%int64(c.cfg.DeleteBatchSize) == 0 {
+					log.Printf("Restored %d objects so far", stats.Restored)
+				}
+			}
+		}()
+		return nil
+	}
+
+	for _, prefix := range restorePrefixes(f) {
+		err = listTrash(ctx, bucket, prefix, func(obj *storage.ObjectAttrs) error {
+			if f.Key != "" && obj.Name != prefix {
+				return nil
+			}
+			// A legacy name may hold another key
+			if key, ok := obj.Metadata["cache_key"]; ok && (!strings.HasPrefix(key, f.Prefix) || f.Key != "" && key != f.Key) {
+				return nil
+			}
+			return restore(obj)
+		})
+		if err != nil {
+			break
+		}
+	}
+	wg.Wait()
+
+	return stats, err
+}
+
+// restorePrefixes returns where trashed objects matching f are listed. Objects
+// trashed before keys were escaped keep their legacy name, which is listed
+// too unless one listing covers the other.
+func restorePrefixes(f RestoreFilter) []string {
+	key := f.Prefix
+	if f.Key != "" {
+		key = f.Key
+	}
+	current, legacy := trashName(objectName(key)), trashName(legacyObjectName(key))
+
+	switch {
+	case legacy == current:
+		return []string{current}
+	case f.Key != "":
+		return []string{current, legacy}
+	case strings.HasPrefix(legacy, current):
+		return []string{current}
+	case strings.HasPrefix(current, legacy):
+		return []string{legacy}
+	}
+	return []string{current, legacy}
+}
+
+// restoreObject copies a trashed object back to its cache name, unless an
+// object was written there since, and removes it from the trash
+func restoreObject(ctx context.Context, bucket *storage.BucketHandle, obj *storage.ObjectAttrs) (bool, error) {
+	src := bucket.Object(obj.Name).If(storage.Conditions{GenerationMatch: obj.Generation})
+	copier := bucket.Object(objectPrefix + strings.TrimPrefix(obj.Name, trashPrefix)).
+		If(storage.Conditions{DoesNotExist: true}).
+		CopierFrom(src)
+	copier.ContentType = obj.ContentType
+	copier.Metadata = make(map[string]string, len(obj.Metadata))
+	for k, v := range obj.Metadata {
+		if k != trashedAtKey && k != trashRunKey {
+			copier.Metadata[k] = v
+		}
+	}
+	// Restored objects count as just used, so the next run does not select
+	// them again straight away
+	copier.Metadata["last_accessed"] = time.Now().Format(time.RFC3339)
+
+	if _, err := copier.Run(ctx); err != nil {
+		if isPreconditionFailed(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := src.Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		log.Printf("Failed to remove restored object %s from the trash: %v", obj.Name, err)
+	}
+	return true, nil
+}
+
+func listTrash(ctx context.Context, bucket *storage.BucketHandle, prefix string, visit func(*storage.ObjectAttrs) error) error {
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name", "Size", "Updated", "Generation", "ContentType", "Metadata"}); err != nil {
+		return err
+	}
+
+	it := bucket.Objects(ctx, query)
+	for {
+		obj, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list trash: %w", err)
+		}
+		if err := visit(obj); err != nil {
+			return err
+		}
+	}
+}
+
+// trashedAt reads when an object was trashed, falling back to its update time
+func trashedAt(obj *storage.ObjectAttrs) time.Time {
+	if t, err := time.Parse(time.RFC3339, obj.Metadata[trashedAtKey]); err == nil {
+		return t
+	}
+	return obj.Updated
+}
@@ -1,11 +1,13 @@
 package metrics
 
 import (
+	"context"
 	"log"
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
 )
 
 var (
@@ -39,6 +41,42 @@ var (
 		Help: "Total number of deletion errors",
 	})
 
+	// Deletions waiting on a GCS response
+	DeletionsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gcs_cache_deletions_in_flight",
+		Help: "Number of delete requests currently in flight",
+	})
+
+	// Retried deletions
+	DeletionRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gcs_cache_deletion_retries_total",
+		Help: "Total number of delete requests retried after a transient error",
+	})
+
+	// Deletions skipped because the object was gone or rewritten since listing
+	DeletionsSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gcs_cache_deletions_skipped_total",
+		Help: "Total number of selected objects left alone because they were deleted or rewritten since listing",
+	})
+
+	// Trashed objects permanently deleted after the trash retention
+	ObjectsPurged = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gcs_cache_objects_purged_total",
+		Help: "Total number of soft-deleted objects permanently deleted from the trash",
+	})
+
+	// Bytes held in the trash
+	TrashBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gcs_cache_trash_bytes",
+		Help: "Bytes of soft-deleted objects awaiting purge",
+	})
+
+	// Fraction of listing shards processed in the current phase
+	PruningProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gcs_cache_pruning_progress_ratio",
+		Help: "Fraction of listing shards processed in the current pruning phase",
+	})
+
 	// Pruning duration histogram
 	PruningDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name:    "gcs_cache_pruning_duration_seconds",
@@ -51,18 +89,45 @@ var (
 		Name: "gcs_cache_pruning_efficiency_percent",
 		Help: "Percentage of cache freed during last pruning",
 	})
+
+	// Completion time of the last successful pruning run, for staleness alerts
+	LastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gcs_cache_pruning_last_success_timestamp_seconds",
+		Help: "Unix time the last pruning run completed successfully",
+	})
 )
 
+// collectors are the pruner metrics, served and pushed
+var collectors = []prometheus.Collector{
+	TotalBytes,
+	ObjectsScanned,
+	ObjectsDeleted,
+	BytesFreed,
+	DeletionErrors,
+	DeletionsInFlight,
+	DeletionRetries,
+	DeletionsSkipped,
+	ObjectsPurged,
+	TrashBytes,
+	PruningProgress,
+	PruningDuration,
+	PruningEfficiency,
+	LastSuccess,
+}
+
 func init() {
-	prometheus.MustRegister(
-		TotalBytes,
-		ObjectsScanned,
-		ObjectsDeleted,
-		BytesFreed,
-		DeletionErrors,
-		PruningDuration,
-		PruningEfficiency,
-	)
+	prometheus.MustRegister(collectors...)
+}
+
+// Push sends the pruner metrics to a Pushgateway, replacing those previously
+// pushed for the same job and instance. The pruner exits right after a run,
+// usually before Prometheus gets to scrape it.
+func Push(ctx context.Context, url, job, instance string) error {
+	pusher := push.New(url, job).Grouping("instance", instance)
+	for _, c := range collectors {
+		pusher.Collector(c)
+	}
+	return pusher.PushContext(ctx)
 }
 
 func Serve(addr string) error {
@@ -3,7 +3,10 @@ package integration
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
@@ -35,6 +38,15 @@ func setupSecureConnection(t *testing.T) *grpc.ClientConn {
 	return conn
 }
 
+// sha256Digest builds the digest the server validates and verifies uploads against
+func sha256Digest(data []byte) *server.Digest {
+	sum := sha256.Sum256(data)
+	return &server.Digest{
+		Hash:      hex.EncodeToString(sum[:]),
+		SizeBytes: int64(len(data)),
+	}
+}
+
 func TestCacheIntegration(t *testing.T) {
 	// Connect to cache server with security
 	conn := setupSecureConnection(t)
@@ -60,10 +72,7 @@ func TestCacheIntegration(t *testing.T) {
 func testPutAndGet(t *testing.T, client server.BuildCacheServiceClient, ctx context.Context) {
 	// Test data
 	testData := []byte("Hello, Cache!")
-	digest := &server.Digest{
-		Hash:      "sha256:test123",
-		SizeBytes: int64(len(testData)),
-	}
+	digest := sha256Digest(testData)
 
 	// Put request
 	putStream, err := client.Put(ctx)
@@ -129,10 +138,8 @@ func testPutAndGet(t *testing.T, client server.BuildCacheServiceClient, ctx cont
 }
 
 func testContains(t *testing.T, client server.BuildCacheServiceClient, ctx context.Context) {
-	digest := &server.Digest{
-		Hash:      "sha256:test123",
-		SizeBytes: 13,
-	}
+	// Stored by testPutAndGet
+	digest := sha256Digest([]byte("Hello, Cache!"))
 
 	resp, err := client.Contains(ctx, &server.ContainsRequest{
 		Digests:      []*server.Digest{digest},
@@ -160,10 +167,7 @@ func testLargeFile(t *testing.T, client server.BuildCacheServiceClient, ctx cont
 		t.Fatalf("Failed to generate test data: %v", err)
 	}
 
-	digest := &server.Digest{
-		Hash:      "sha256:large_file_test",
-		SizeBytes: int64(dataSize),
-	}
+	digest := sha256Digest(testData)
 
 	// Put large file
 	putStream, err := client.Put(ctx)
@@ -240,16 +244,14 @@ func BenchmarkCachePut(b *testing.B) {
 	client := server.NewBuildCacheServiceClient(conn)
 	ctx := context.Background()
 
-	testData := []byte(strings.Repeat("test data ", 1000))
+	baseData := strings.Repeat("test data ", 1000)
 
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		i := 0
 		for pb.Next() {
-			digest := &server.Digest{
-				Hash:      fmt.Sprintf("sha256:bench_%d", i),
-				SizeBytes: int64(len(testData)),
-			}
+			testData := []byte(fmt.Sprintf("%s%d", baseData, i))
+			digest := sha256Digest(testData)
 
 			putStream, err := client.Put(ctx)
 			if err != nil {